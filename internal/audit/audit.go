@@ -0,0 +1,328 @@
+package audit
+
+import (
+	// Built-ins
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// External imports
+	"github.com/go-chi/chi"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/auth"
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Entry is a single value changed by an upload. OldValue is null when the
+// value did not exist before; NewValue is null when it was removed.
+type Entry struct {
+	ID         int64     `json:"ID"`
+	UploadID   *int64    `json:"UploadID"`
+	KeyID      *int64    `json:"KeyID"`
+	Resource   string    `json:"Resource"`
+	LocationID int64     `json:"LocationID"`
+	Admin2     string    `json:"Admin2"`
+	Address1   string    `json:"Province/State"`
+	Address2   string    `json:"Country/Region"`
+	Date       time.Time `json:"Date"`
+	Metric     string    `json:"Metric"`
+	OldValue   *int      `json:"OldValue"`
+	NewValue   *int      `json:"NewValue"`
+	CreatedAt  time.Time `json:"CreatedAt"`
+}
+
+// Results are capped unless the client asks for more with limit
+const defaultLimit = 1000
+
+func Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/", List)
+
+	return r
+}
+
+// Record appends entries to the audit log, attributing them to the upload
+// and key of ctx (see auth.Middleware). Entries whose value did not change
+// are skipped.
+func Record(ctx context.Context, entries ...Entry) error {
+	var uploadID, keyID interface{}
+	if id := auth.UploadID(ctx); id != 0 {
+		uploadID = id
+	}
+	if key, ok := auth.FromContext(ctx); ok {
+		keyID = key.ID
+	}
+
+	for _, e := range entries {
+		if !Changed(e.OldValue, e.NewValue) {
+			continue
+		}
+		_, err := db.Db.Exec(`
+			INSERT INTO AuditLog(UploadID, KeyID, Resource, LocationID,
+			Admin2, Address1, Address2, Date, Metric, OldValue, NewValue)
+			VALUES(?,?,?,?,?,?,?,?,?,?,?)
+		`, uploadID, keyID, e.Resource, e.LocationID,
+			nullable(e.Admin2), nullable(e.Address1), e.Address2,
+			e.Date, e.Metric, e.OldValue, e.NewValue)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Changed reports whether going from old to new is worth recording
+func Changed(old *int, new *int) bool {
+	if old == nil || new == nil {
+		return old != new
+	}
+	return *old != *new
+}
+
+// Value is a helper to fill OldValue/NewValue from a plain int
+func Value(v int) *int {
+	return &v
+}
+
+func List(w http.ResponseWriter, r *http.Request) {
+	query, args, status := makeQuery(r.URL.Query())
+	if status != 0 {
+		utils.HandleErr(w, 400, errors.New("Invalid input"))
+		return
+	}
+
+	rows, err := db.Db.Query(query, args...)
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		e := Entry{}
+		var (
+			uploadID, keyID    sql.NullInt64
+			admin2, address1   sql.NullString
+			oldValue, newValue sql.NullInt64
+		)
+		err := rows.Scan(&e.ID, &uploadID, &keyID, &e.Resource, &e.LocationID,
+			&admin2, &address1, &e.Address2, &e.Date, &e.Metric,
+			&oldValue, &newValue, &e.CreatedAt)
+		if err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if uploadID.Valid {
+			e.UploadID = &uploadID.Int64
+		}
+		if keyID.Valid {
+			e.KeyID = &keyID.Int64
+		}
+		e.Admin2, e.Address1 = admin2.String, address1.String
+		if oldValue.Valid {
+			e.OldValue = Value(int(oldValue.Int64))
+		}
+		if newValue.Valid {
+			e.NewValue = Value(int(newValue.Int64))
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+
+	// Check 'Accept' type
+	if r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		b := new(bytes.Buffer)
+		writer := csv.NewWriter(b)
+		if err := writer.WriteAll(writeCSV(entries)); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+}
+
+// Helper functions
+
+// Builds the query listing entries, newest first. On top of the location and
+// date parameters of utils.ParamValidate it accepts:
+//   - metric: Confirmed, Death, Recovered or Active
+//   - resource: time_series or daily_reports
+//   - upload / key: ID of the upload or API key
+//   - limit: maximum number of entries (default 1000)
+func makeQuery(params map[string][]string) (string, []interface{}, int) {
+	conds := []string{}
+	args := []interface{}{}
+	limit := defaultLimit
+	latest := utils.LatestDate("AuditLog")
+
+	// Sorted to make the query deterministic
+	keys := []string{}
+	for param := range params {
+		keys = append(keys, param)
+	}
+	sort.Strings(keys)
+
+	for _, param := range keys {
+		values := strings.Split(params[param][0], ",")
+		param = strings.ToLower(param)
+
+		if param == "limit" {
+			n, err := strconv.Atoi(values[0])
+			if err != nil || n <= 0 {
+				return "", nil, 400
+			}
+			limit = n
+			continue
+		}
+
+		alternatives := []string{}
+		switch param {
+		case "metric":
+			for _, v := range values {
+				metric, ok := metrics[strings.ToLower(v)]
+				if !ok {
+					return "", nil, 400
+				}
+				alternatives = append(alternatives, "Metric=?")
+				args = append(args, metric)
+			}
+		case "resource":
+			for _, v := range values {
+				if v != "time_series" && v != "daily_reports" {
+					return "", nil, 400
+				}
+				alternatives = append(alternatives, "Resource=?")
+				args = append(args, v)
+			}
+		case "upload", "key":
+			column := map[string]string{"upload": "UploadID", "key": "KeyID"}[param]
+			for _, v := range values {
+				id, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return "", nil, 400
+				}
+				alternatives = append(alternatives, column+"=?")
+				args = append(args, id)
+			}
+		default:
+			column, valid := utils.ParamValidate(param)
+			if !valid {
+				return "", nil, 400
+			}
+			switch column {
+			case "admin2", "address1", "address2":
+				for _, v := range values {
+					alternatives = append(alternatives, column+"=?")
+					args = append(args, v)
+				}
+			case "date", "from", "to", "range", "month":
+				op := map[string]string{"from": ">=", "to": "<="}[column]
+				if op == "" {
+					op = "="
+				}
+				for _, v := range values {
+					rng, err := dates.Resolve(v, latest)
+					if err != nil {
+						return "", nil, 400
+					}
+					alternatives = append(alternatives, utils.DateCondition("Date", op, rng))
+				}
+			default:
+				return "", nil, 400
+			}
+		}
+		conds = append(conds, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	query := `
+		SELECT ID, UploadID, KeyID, Resource, LocationID,
+		Admin2, Address1, Address2, Date, Metric,
+		OldValue, NewValue, CreatedAt
+		FROM AuditLog
+	`
+	if len(conds) > 0 {
+		query += "WHERE " + strings.Join(conds, " AND ") + "\n"
+	}
+	query += "ORDER BY ID DESC LIMIT " + strconv.Itoa(limit)
+	return query, args, 0
+}
+
+var metrics = map[string]string{
+	"confirmed": "Confirmed",
+	"death":     "Death",
+	"recovered": "Recovered",
+	"active":    "Active",
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func writeCSV(entries []Entry) [][]string {
+	csvArr := [][]string{
+		{"ID", "UploadID", "KeyID", "Resource", "LocationID", "Admin2",
+			"Province/State", "Country/Region", "Date", "Metric",
+			"OldValue", "NewValue", "CreatedAt"},
+	}
+	optional := func(v *int64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatInt(*v, 10)
+	}
+	value := func(v *int) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	}
+	for _, e := range entries {
+		csvArr = append(csvArr, []string{
+			strconv.FormatInt(e.ID, 10),
+			optional(e.UploadID),
+			optional(e.KeyID),
+			e.Resource,
+			strconv.FormatInt(e.LocationID, 10),
+			e.Admin2,
+			e.Address1,
+			e.Address2,
+			dates.Format(e.Date),
+			e.Metric,
+			value(e.OldValue),
+			value(e.NewValue),
+			e.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	return csvArr
+}
@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMakeQueryNoParams(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	query, args, status := makeQuery(r.URL.Query())
+	if status != 0 || len(args) != 0 {
+		t.Fatalf("Test failed: expected no args and status 0, got %v %d", args, status)
+	}
+	if strings.Contains(query, "WHERE") {
+		t.Fatalf("Test failed: expected no WHERE clause, got %s", query)
+	}
+	if !strings.HasSuffix(query, "ORDER BY ID DESC LIMIT 1000") {
+		t.Fatalf("Test failed: expected default limit, got %s", query)
+	}
+}
+
+func TestMakeQueryFilters(t *testing.T) {
+	r := httptest.NewRequest("GET",
+		"http://example.com/foo?country=Canada&metric=death,Active&upload=7&from=2021-03-01&limit=5", nil)
+	query, args, status := makeQuery(r.URL.Query())
+	if status != 0 {
+		t.Fatalf("Test failed: expected status 0, got %d", status)
+	}
+
+	expected := `WHERE (address2=?) AND (Date>="2021-03-01") AND (Metric=? OR Metric=?) AND (UploadID=?)`
+	if !strings.Contains(query, expected) {
+		t.Fatalf("Test failed: expected %s in %s", expected, query)
+	}
+	if !strings.HasSuffix(query, "LIMIT 5") {
+		t.Fatalf("Test failed: expected limit 5, got %s", query)
+	}
+	if len(args) != 4 || args[0] != "Canada" || args[1] != "Death" ||
+		args[2] != "Active" || args[3] != int64(7) {
+		t.Fatalf("Test failed: unexpected args %v", args)
+	}
+}
+
+func TestMakeQueryInvalidParams(t *testing.T) {
+	for _, url := range []string{
+		"http://example.com/foo?abc=def",
+		"http://example.com/foo?metric=cases",
+		"http://example.com/foo?resource=latest",
+		"http://example.com/foo?upload=abc",
+		"http://example.com/foo?limit=0",
+		"http://example.com/foo?date=2/30/21",
+		"http://example.com/foo?death",
+	} {
+		r := httptest.NewRequest("GET", url, nil)
+		if _, _, status := makeQuery(r.URL.Query()); status != 400 {
+			t.Fatalf("Test failed: expected 400 for %s, got %d", url, status)
+		}
+	}
+}
+
+func TestChanged(t *testing.T) {
+	if Changed(nil, nil) {
+		t.Fatalf("Test failed: nil to nil is not a change")
+	}
+	if !Changed(nil, Value(0)) {
+		t.Fatalf("Test failed: a new value is a change")
+	}
+	if Changed(Value(3), Value(3)) {
+		t.Fatalf("Test failed: same value is not a change")
+	}
+	if !Changed(Value(3), Value(4)) {
+		t.Fatalf("Test failed: different values are a change")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	upload := int64(2)
+	entries := []Entry{{
+		ID:         1,
+		UploadID:   &upload,
+		Resource:   "daily_reports",
+		LocationID: 3,
+		Address1:   "Ontario",
+		Address2:   "Canada",
+		Date:       time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		Metric:     "Confirmed",
+		NewValue:   Value(5),
+		CreatedAt:  time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC),
+	}}
+
+	csvArr := writeCSV(entries)
+	if len(csvArr) != 2 {
+		t.Fatalf("Test failed: expected 2 rows, got %d", len(csvArr))
+	}
+	expected := []string{"1", "2", "", "daily_reports", "3", "", "Ontario", "Canada",
+		"2021-03-01", "Confirmed", "", "5", "2021-03-02T10:00:00Z"}
+	for i, v := range expected {
+		if csvArr[1][i] != v {
+			t.Fatalf("Test failed: column %s expected %q, got %q", csvArr[0][i], v, csvArr[1][i])
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	// Built-ins
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	// External imports
+	"github.com/go-chi/chi/middleware"
+
+	// Internal imports
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Key is an API key as stored in the database; the key itself is never kept
+type Key struct {
+	ID     int64
+	Name   string
+	Role   string
+	Scopes []string
+}
+
+type contextKey string
+
+const (
+	keyContext    contextKey = "auth.key"
+	uploadContext contextKey = "auth.upload"
+	readContext   contextKey = "auth.read"
+)
+
+var (
+	errNoKey      = errors.New("API key required")
+	errInvalidKey = errors.New("Invalid API key")
+)
+
+// Middleware authenticates requests by API key, read from the "X-API-Key"
+// header or an "Authorization: Bearer" header.
+//
+// Writes (anything but GET, HEAD and OPTIONS) always need a valid key. Reads
+// are public unless AUTH_READS is "true"; a key given on a public read is
+// still checked. Writes are recorded by Record.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := keyFromRequest(r)
+
+		if raw == "" {
+			if isWrite(r) || os.Getenv("AUTH_READS") == "true" {
+				unauthorized(w, errNoKey)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := lookup(raw)
+		if err == errInvalidKey {
+			unauthorized(w, err)
+			return
+		}
+		if err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), keyContext, key)))
+	})
+}
+
+// Record records writes in Uploads along with the key attached by Middleware
+// that performed them. It goes after rate limiting and authorization, so
+// rejected writes leave no row.
+func Record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := FromContext(r.Context())
+		if !ok || !isWrite(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Record the write before it happens so the handler can refer to it
+		uploadID, err := recordWrite(key, r)
+		if err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), uploadContext, uploadID)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		// The response is already sent, so the error can only be logged
+		if err := recordStatus(uploadID, ww.Status()); err != nil {
+			log.Printf("auth: upload %d: %v", uploadID, err)
+		}
+	})
+}
+
+// Read marks requests as reads for Middleware, Require and Record whatever
+// their method, i.e. for POST routes that only preview an upload
+func Read(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), readContext, true)))
+	})
+}
+
+// DryRun marks uploads with dry_run=true as reads (see Read), since they
+// only report what the upload would do
+func DryRun(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("dry_run") == "true" {
+			Read(next).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// KeyDigest returns the digest of the key sent with r, checked or not, or ""
+// if there is none; i.e. to tell clients apart before Middleware runs
+func KeyDigest(r *http.Request) string {
+	raw := keyFromRequest(r)
+	if raw == "" {
+		return ""
+	}
+	return hashKey(raw)
+}
+
+// FromContext returns the key that authenticated the request, if any
+func FromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(keyContext).(Key)
+	return key, ok
+}
+
+// UploadID returns the ID of the Uploads row recording this write, or 0
+func UploadID(ctx context.Context) int64 {
+	id, _ := ctx.Value(uploadContext).(int64)
+	return id
+}
+
+// WithKey attaches key to ctx, as Middleware does, i.e. for background work
+func WithKey(ctx context.Context, key Key, uploadID int64) context.Context {
+	ctx = context.WithValue(ctx, keyContext, key)
+	return context.WithValue(ctx, uploadContext, uploadID)
+}
+
+// Helper functions
+
+func isWrite(r *http.Request) bool {
+	if read, _ := r.Context().Value(readContext).(bool); read {
+		return false
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+func keyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return strings.TrimSpace(key)
+	}
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// Keys are only ever stored as their SHA-256 digest
+func hashKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generates a new random key, returned once to the admin who created it
+func newKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "a2_" + hex.EncodeToString(b), nil
+}
+
+func lookup(raw string) (Key, error) {
+	return loadKey("KeyHash = ?", hashKey(raw))
+}
+
+// KeyByID returns the key of ID unless it was revoked, i.e. to act on its
+// behalf outside of a request
+func KeyByID(id int64) (Key, error) {
+	return loadKey("ID = ?", id)
+}
+
+func loadKey(cond string, arg interface{}) (Key, error) {
+	key := Key{}
+	var scopes string
+	err := db.Db.QueryRow(`
+		SELECT ID, Name, Role, Scopes FROM ApiKeys
+		WHERE `+cond+` AND NOT Revoked
+	`, arg).Scan(&key.ID, &key.Name, &key.Role, &scopes)
+	if err == sql.ErrNoRows {
+		return key, errInvalidKey
+	}
+	if err != nil {
+		return key, err
+	}
+	key.Scopes, err = parseScopes(scopes)
+	return key, err
+}
+
+func recordWrite(key Key, r *http.Request) (int64, error) {
+	res, err := db.Db.Exec(
+		"INSERT INTO Uploads(KeyID, Method, Path) VALUES(?,?,?)",
+		key.ID, r.Method, r.URL.Path)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func recordStatus(uploadID int64, status int) error {
+	_, err := db.Db.Exec("UPDATE Uploads SET Status = ? WHERE ID = ?", status, uploadID)
+	return err
+}
+
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="a2"`)
+	utils.HandleErr(w, 401, err)
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestKeyFromRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://example.com/foo", nil)
+	if key := keyFromRequest(r); key != "" {
+		t.Fatalf("Test failed: expected no key, got %s", key)
+	}
+
+	r.Header.Set("Authorization", "Bearer abc")
+	if key := keyFromRequest(r); key != "abc" {
+		t.Fatalf("Test failed: expected abc, got %s", key)
+	}
+
+	// X-API-Key wins over Authorization
+	r.Header.Set("X-API-Key", "def")
+	if key := keyFromRequest(r); key != "def" {
+		t.Fatalf("Test failed: expected def, got %s", key)
+	}
+
+	r = httptest.NewRequest("POST", "http://example.com/foo", nil)
+	r.Header.Set("Authorization", "Basic abc")
+	if key := keyFromRequest(r); key != "" {
+		t.Fatalf("Test failed: expected no key for basic auth, got %s", key)
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	hash := hashKey("a2_secret")
+	if len(hash) != 64 {
+		t.Fatalf("Test failed: expected a 64 character digest, got %d", len(hash))
+	}
+	if hash != hashKey("a2_secret") {
+		t.Fatalf("Test failed: hashing is not deterministic")
+	}
+	if hash == hashKey("a2_secreT") {
+		t.Fatalf("Test failed: different keys share a digest")
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	a, err := newKey()
+	if err != nil {
+		t.Fatalf("Error while generating key: %v", err)
+	}
+	b, err := newKey()
+	if err != nil {
+		t.Fatalf("Error while generating key: %v", err)
+	}
+	if a == b {
+		t.Fatalf("Test failed: keys are not random")
+	}
+	if !strings.HasPrefix(a, "a2_") || len(a) != 51 {
+		t.Fatalf("Test failed: unexpected key format %s", a)
+	}
+}
+
+func TestMiddlewareWithoutKey(t *testing.T) {
+	called := false
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if _, ok := FromContext(r.Context()); ok {
+			t.Fatalf("Test failed: anonymous request has a key")
+		}
+	}))
+
+	// Reads are public
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/foo", nil))
+	if !called || w.Result().StatusCode != 200 {
+		t.Fatalf("Test failed: public read rejected")
+	}
+
+	// Writes are not
+	called = false
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/foo", bytes.NewBufferString("a,b")))
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	if called {
+		t.Fatalf("Test failed: anonymous write reached the handler")
+	}
+	if resp.StatusCode != 401 || string(body) != "Error status 401" {
+		t.Fatalf("Test failed: expected 401, got %d %s", resp.StatusCode, string(body))
+	}
+	if resp.Header.Get("WWW-Authenticate") == "" {
+		t.Fatalf("Test failed: missing WWW-Authenticate header")
+	}
+
+	// Reads can be keyed too
+	os.Setenv("AUTH_READS", "true")
+	defer os.Unsetenv("AUTH_READS")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/foo", nil))
+	if called || w.Result().StatusCode != 401 {
+		t.Fatalf("Test failed: expected 401 with AUTH_READS")
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	out := new(bytes.Buffer)
+	for _, args := range [][]string{
+		{}, {"create"}, {"revoke"}, {"rotate"},
+		{"create", "-role", "owner", "name"},
+		{"create", "-scopes", "everything", "name"},
+	} {
+		if err := Command(args, out); err == nil {
+			t.Fatalf("Test failed: expected usage error for %v", args)
+		}
+	}
+	if err := Command([]string{"revoke", "abc"}, out); err == nil {
+		t.Fatalf("Test failed: expected an error for a non numeric id")
+	}
+}
+
+func TestRecordWithoutKey(t *testing.T) {
+	called := false
+	handler := Record(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if id := UploadID(r.Context()); id != 0 {
+			t.Fatalf("Test failed: anonymous request recorded as upload %d", id)
+		}
+	}))
+
+	// Nothing to record without a key, i.e. on public reads
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/foo", nil))
+	if !called || w.Result().StatusCode != 200 {
+		t.Fatalf("Test failed: expected the read to pass through")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	called := false
+	handler := DryRun(Middleware(Require("time_series")(Record(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})))))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/foo?dry_run=true", bytes.NewBufferString("a,b")))
+	if !called || w.Result().StatusCode != 200 {
+		t.Fatalf("Test failed: expected the dry run to pass as a read, got %d", w.Result().StatusCode)
+	}
+
+	called = false
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/foo?dry_run=false", bytes.NewBufferString("a,b")))
+	if called || w.Result().StatusCode != 401 {
+		t.Fatalf("Test failed: expected 401 for an anonymous upload, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestRead(t *testing.T) {
+	called := false
+	handler := Read(Middleware(Require("time_series")(Record(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})))))
+
+	// A POST marked as a read is public
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/foo", bytes.NewBufferString("a,b")))
+	if !called || w.Result().StatusCode != 200 {
+		t.Fatalf("Test failed: expected the POST to pass as a read, got %d", w.Result().StatusCode)
+	}
+
+	// Unless reads are keyed
+	os.Setenv("AUTH_READS", "true")
+	defer os.Unsetenv("AUTH_READS")
+	called = false
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/foo", bytes.NewBufferString("a,b")))
+	if called || w.Result().StatusCode != 401 {
+		t.Fatalf("Test failed: expected 401 with AUTH_READS, got %d", w.Result().StatusCode)
+	}
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	// Built-ins
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	// Internal imports
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+)
+
+const keysUsage = `usage:
+  keys create [-role reader|uploader|admin] [-scopes s1,s2] <name>
+                       create a key; it is only shown once
+  keys list            list keys
+  keys revoke <id>     revoke a key
+
+scopes restrict uploads to resources (time_series, daily_reports)
+and/or countries (country:Canada); no scope means no restriction`
+
+// Command runs the "keys" admin subcommand, i.e. ./a2 keys create uploader
+func Command(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		role := flags.String("role", RoleUploader, "")
+		rawScopes := flags.String("scopes", "", "")
+		if err := flags.Parse(args[1:]); err != nil {
+			return errors.New(keysUsage)
+		}
+		if flags.NArg() != 1 || strings.TrimSpace(flags.Arg(0)) == "" {
+			return errors.New(keysUsage)
+		}
+		if !validRole(*role) {
+			return fmt.Errorf("invalid role %q\n%s", *role, keysUsage)
+		}
+		scopes, err := parseScopes(*rawScopes)
+		if err != nil {
+			return err
+		}
+
+		name := flags.Arg(0)
+		raw, id, err := CreateKey(name, *role, scopes)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Created %s key %d (%s):\n%s\n", *role, id, name, raw)
+		fmt.Fprintln(out, "Store it now; it cannot be shown again.")
+	case "list":
+		return listKeys(out)
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(keysUsage)
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		if err := RevokeKey(id); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Revoked key %d\n", id)
+	default:
+		return errors.New(keysUsage)
+	}
+	return nil
+}
+
+// CreateKey stores a new key and returns it in clear along with its ID
+func CreateKey(name string, role string, scopes []string) (string, int64, error) {
+	raw, err := newKey()
+	if err != nil {
+		return "", -1, err
+	}
+	res, err := db.Db.Exec(
+		"INSERT INTO ApiKeys(Name, KeyHash, Role, Scopes) VALUES(?,?,?,?)",
+		name, hashKey(raw), role, strings.Join(scopes, ","))
+	if err != nil {
+		return "", -1, err
+	}
+	id, err := res.LastInsertId()
+	return raw, id, err
+}
+
+func RevokeKey(id int64) error {
+	res, err := db.Db.Exec("UPDATE ApiKeys SET Revoked = TRUE WHERE ID = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("No key with ID %d", id)
+	}
+	return err
+}
+
+func listKeys(out io.Writer) error {
+	rows, err := db.Db.Query(`
+		SELECT ID, Name, Role, Scopes, CreatedAt, Revoked
+		FROM ApiKeys ORDER BY ID
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tName\tRole\tScopes\tCreated\tRevoked")
+	for rows.Next() {
+		var (
+			id         int64
+			name, role string
+			scopes     string
+			createdAt  time.Time
+			revoked    bool
+		)
+		if err := rows.Scan(&id, &name, &role, &scopes, &createdAt, &revoked); err != nil {
+			return err
+		}
+		if scopes == "" {
+			scopes = "*"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%v\n",
+			id, name, role, scopes, createdAt.Format(time.RFC3339), revoked)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
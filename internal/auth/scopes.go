@@ -0,0 +1,180 @@
+package auth
+
+import (
+	// Built-ins
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Roles, from least to most privileged
+const (
+	RoleReader   = "reader"
+	RoleUploader = "uploader"
+	RoleAdmin    = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleReader:   0,
+	RoleUploader: 1,
+	RoleAdmin:    2,
+}
+
+// Resources a key can be scoped to
+var resources = map[string]bool{
+	"time_series":   true,
+	"daily_reports": true,
+}
+
+const countryScope = "country:"
+
+// ScopeError explains which role or scope a key is missing
+type ScopeError struct {
+	Missing string
+}
+
+func (e ScopeError) Error() string {
+	return "API key lacks " + e.Missing
+}
+
+// Require authorizes writes to resource (i.e. "time_series") for the key
+// attached by Middleware. Readers cannot write at all; uploaders need the
+// resource in their scopes unless they have no resource scope; admins can
+// write anywhere. Requests without a key are left to Middleware.
+func Require(resource string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := FromContext(r.Context())
+			if ok && isWrite(r) {
+				if err := key.CanWrite(resource); err != nil {
+					Forbidden(w, err)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRole rejects requests whose key is below role (or that have no key)
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := FromContext(r.Context())
+			if !ok {
+				unauthorized(w, errNoKey)
+				return
+			}
+			if err := key.HasRole(role); err != nil {
+				Forbidden(w, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CheckWrite checks that the key attached to ctx may write resource, i.e.
+// when a job runs long after the request that submitted it. Returns nil if
+// there is no key.
+func CheckWrite(ctx context.Context, resource string) error {
+	key, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return key.CanWrite(resource)
+}
+
+// CheckCountries checks that the key attached to ctx may upload data for
+// every country. Returns nil if there is no key, i.e. when ingestion runs
+// outside of a request, or if the request is a read (see Read), i.e. a diff.
+func CheckCountries(ctx context.Context, countries []string) error {
+	key, ok := FromContext(ctx)
+	if read, _ := ctx.Value(readContext).(bool); !ok || read {
+		return nil
+	}
+	for _, country := range countries {
+		if !key.AllowsCountry(country) {
+			return ScopeError{Missing: "scope " + countryScope + country}
+		}
+	}
+	return nil
+}
+
+// Forbidden writes a 403 that explains the missing role or scope
+func Forbidden(w http.ResponseWriter, err error) {
+	utils.HandleErrDetail(w, 403, err)
+}
+
+func (k Key) HasRole(role string) error {
+	if roleRank[k.Role] < roleRank[role] {
+		return ScopeError{Missing: "role " + role}
+	}
+	return nil
+}
+
+func (k Key) CanWrite(resource string) error {
+	if err := k.HasRole(RoleUploader); err != nil {
+		return err
+	}
+	if k.Role == RoleAdmin {
+		return nil
+	}
+	scoped := false
+	for _, scope := range k.Scopes {
+		if resources[scope] {
+			scoped = true
+			if scope == resource {
+				return nil
+			}
+		}
+	}
+	if scoped {
+		return ScopeError{Missing: "scope " + resource}
+	}
+	return nil
+}
+
+func (k Key) AllowsCountry(country string) bool {
+	if k.Role == RoleAdmin {
+		return true
+	}
+	scoped := false
+	for _, scope := range k.Scopes {
+		if strings.HasPrefix(scope, countryScope) {
+			scoped = true
+			if strings.EqualFold(strings.TrimPrefix(scope, countryScope), country) {
+				return true
+			}
+		}
+	}
+	return !scoped
+}
+
+// Helper functions
+
+// Splits and validates a comma separated list of scopes
+func parseScopes(raw string) ([]string, error) {
+	scopes := []string{}
+	for _, scope := range strings.Split(raw, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if !resources[scope] &&
+			!(strings.HasPrefix(scope, countryScope) && len(scope) > len(countryScope)) {
+			return nil, fmt.Errorf("invalid scope %q; expected time_series, daily_reports or country:<name>", scope)
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+func validRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
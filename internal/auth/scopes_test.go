@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := parseScopes(" time_series, country:Canada ,")
+	if err != nil {
+		t.Fatalf("Error while parsing scopes: %v", err)
+	}
+	if len(scopes) != 2 || scopes[0] != "time_series" || scopes[1] != "country:Canada" {
+		t.Fatalf("Test failed: unexpected scopes %v", scopes)
+	}
+
+	if scopes, err := parseScopes(""); err != nil || len(scopes) != 0 {
+		t.Fatalf("Test failed: expected no scopes, got %v %v", scopes, err)
+	}
+
+	for _, raw := range []string{"timeseries", "country:", "admin"} {
+		if _, err := parseScopes(raw); err == nil {
+			t.Fatalf("Test failed: expected an error for %s", raw)
+		}
+	}
+}
+
+func TestCanWrite(t *testing.T) {
+	reader := Key{Role: RoleReader}
+	if err := reader.CanWrite("time_series"); err == nil {
+		t.Fatalf("Test failed: readers cannot write")
+	}
+
+	// No resource scope means every resource
+	uploader := Key{Role: RoleUploader, Scopes: []string{"country:Canada"}}
+	if err := uploader.CanWrite("daily_reports"); err != nil {
+		t.Fatalf("Test failed: unexpected error %v", err)
+	}
+
+	uploader.Scopes = []string{"time_series"}
+	if err := uploader.CanWrite("time_series"); err != nil {
+		t.Fatalf("Test failed: unexpected error %v", err)
+	}
+	err := uploader.CanWrite("daily_reports")
+	expected := "API key lacks scope daily_reports"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Test failed: expected %s, got %v", expected, err)
+	}
+
+	admin := Key{Role: RoleAdmin, Scopes: []string{"time_series"}}
+	if err := admin.CanWrite("daily_reports"); err != nil {
+		t.Fatalf("Test failed: admins can write anywhere, got %v", err)
+	}
+}
+
+func TestCheckWrite(t *testing.T) {
+	if err := CheckWrite(context.Background(), "time_series"); err != nil {
+		t.Fatalf("Test failed: expected no error without a key, got %v", err)
+	}
+
+	// i.e. a key downgraded after submitting a job
+	ctx := WithKey(context.Background(), Key{Role: RoleReader}, 0)
+	if err := CheckWrite(ctx, "time_series"); err == nil {
+		t.Fatalf("Test failed: readers cannot write")
+	}
+	ctx = WithKey(context.Background(), Key{Role: RoleUploader, Scopes: []string{"daily_reports"}}, 0)
+	if err := CheckWrite(ctx, "time_series"); err == nil {
+		t.Fatalf("Test failed: expected an error for a key without the time_series scope")
+	}
+}
+
+func TestAllowsCountry(t *testing.T) {
+	key := Key{Role: RoleUploader}
+	if !key.AllowsCountry("US") {
+		t.Fatalf("Test failed: unscoped keys allow every country")
+	}
+
+	key.Scopes = []string{"time_series", "country:Canada", "country:US"}
+	if !key.AllowsCountry("canada") || !key.AllowsCountry("US") {
+		t.Fatalf("Test failed: scoped countries should be allowed")
+	}
+	if key.AllowsCountry("France") {
+		t.Fatalf("Test failed: France is not in scope")
+	}
+
+	ctx := WithKey(context.Background(), key, 1)
+	err := CheckCountries(ctx, []string{"Canada", "France"})
+	expected := "API key lacks scope country:France"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Test failed: expected %s, got %v", expected, err)
+	}
+
+	// Without a key (i.e. ingestion outside of a request) nothing is checked
+	if err := CheckCountries(context.Background(), []string{"France"}); err != nil {
+		t.Fatalf("Test failed: unexpected error %v", err)
+	}
+
+	// Nor on reads, i.e. diffs, which store nothing
+	ctx = context.WithValue(ctx, readContext, true)
+	if err := CheckCountries(ctx, []string{"France"}); err != nil {
+		t.Fatalf("Test failed: unexpected error on a read %v", err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	handler := Require("daily_reports")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	key := Key{Role: RoleUploader, Scopes: []string{"time_series"}}
+
+	// Reads are not affected by scopes
+	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r.WithContext(WithKey(r.Context(), key, 0)))
+	if w.Result().StatusCode != 200 {
+		t.Fatalf("Test failed: expected 200, got %d", w.Result().StatusCode)
+	}
+
+	r = httptest.NewRequest("POST", "http://example.com/foo", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r.WithContext(WithKey(r.Context(), key, 1)))
+
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 403 {
+		t.Fatalf("Test failed: expected 403, got %d", resp.StatusCode)
+	}
+	expected := "Error status 403: API key lacks scope daily_reports"
+	if string(body) != expected {
+		t.Fatalf("Test failed: expected %s, got %s", expected, string(body))
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	handler := RequireRole(RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Result().StatusCode != 401 {
+		t.Fatalf("Test failed: expected 401, got %d", w.Result().StatusCode)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r.WithContext(WithKey(r.Context(), Key{Role: RoleUploader}, 0)))
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	expected := "Error status 403: API key lacks role admin"
+	if resp.StatusCode != 403 || string(body) != expected {
+		t.Fatalf("Test failed: expected 403 %s, got %d %s", expected, resp.StatusCode, string(body))
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r.WithContext(WithKey(r.Context(), Key{Role: RoleAdmin}, 0)))
+	if w.Result().StatusCode != 200 {
+		t.Fatalf("Test failed: expected 200, got %d", w.Result().StatusCode)
+	}
+}
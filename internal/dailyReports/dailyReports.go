@@ -3,13 +3,13 @@ package dailyReports
 import (
 	// Built-ins
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -19,7 +19,13 @@ import (
 	"github.com/go-chi/chi"
 
 	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/audit"
+	"gitlab.com/csc301-assignments/a2/internal/auth"
+	"gitlab.com/csc301-assignments/a2/internal/dates"
 	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/idempotency"
+	"gitlab.com/csc301-assignments/a2/internal/jobs"
+	"gitlab.com/csc301-assignments/a2/internal/preview"
 	"gitlab.com/csc301-assignments/a2/internal/utils"
 )
 
@@ -38,7 +44,7 @@ type DailyReports struct {
 func Routes() chi.Router {
 	r := chi.NewRouter()
 	r.Get("/", List)
-	r.Post("/", Create)
+	r.With(idempotency.Middleware("daily_reports")).Post("/", Create)
 
 	return r
 }
@@ -111,7 +117,7 @@ func List(w http.ResponseWriter, r *http.Request) {
 		for _, dr := range drArr {
 			row := []string{
 				dr.ID,
-				dr.Date.Format("2006/01/02"),
+				dates.Format(dr.Date),
 				dr.Admin2,
 				dr.Address1,
 				dr.Address2,
@@ -140,22 +146,125 @@ func List(w http.ResponseWriter, r *http.Request) {
 }
 
 func Create(w http.ResponseWriter, r *http.Request) {
-	notAllRead := false
 	date := r.Header.Get("Date")
-	_, err := utils.ParseDate(date)
-	if date == "" || err != nil {
-		utils.HandleErr(w, 400, err)
+
+	// Only report what the upload would do
+	if r.URL.Query().Get("dry_run") == "true" {
+		u, status, err := parseUpload(r.Context(), date, r.Body)
+		if err != nil {
+			uploadErr(w, status, err)
+			return
+		}
+		plan, err := u.plan()
+		if err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		preview.Write(w, r, plan)
 		return
 	}
 
-	dr := DailyReports{}
-	reader := csv.NewReader(r.Body)
+	// Large files can be stored in the background, see jobs.Submit
+	if r.URL.Query().Get("async") == "true" {
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.HandleErr(w, 400, err)
+			return
+		}
+		if _, status, err := parseUpload(r.Context(), date, bytes.NewReader(payload)); err != nil {
+			uploadErr(w, status, err)
+			return
+		}
+		jobs.Submit(w, r, "daily_reports", date, payload)
+		return
+	}
+
+	u, status, err := parseUpload(r.Context(), date, r.Body)
+	if err != nil {
+		uploadErr(w, status, err)
+		return
+	}
+
+	// Rows with unparsable numbers are skipped
+	notAllRead := false
+	err = u.store(r.Context(), func(err error) error {
+		if err == errUnparsable {
+			notAllRead = true
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+
+	// Write to respond body
+	if notAllRead {
+		if _, err := w.Write([]byte("400 Input Error: could not parse some data into the system")); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		w.WriteHeader(400)
+	} else {
+		if _, err := w.Write([]byte("201 Created: created/updated data to the system")); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		w.WriteHeader(201)
+	}
+}
+
+// Process stores a daily report submitted with async=true; registered with
+// jobs by main. Rows that fail are counted and skipped.
+func Process(ctx context.Context, date string, payload []byte, tracker *jobs.Tracker) (string, error) {
+	// The key may have lost its role or scope since the job was submitted
+	if err := auth.CheckWrite(ctx, "daily_reports"); err != nil {
+		return "", err
+	}
+	u, _, err := parseUpload(ctx, date, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	tracker.SetTotal(len(u.records))
+	err = u.store(ctx, func(err error) error {
+		tracker.Row(err)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	_, processed, errs, _ := tracker.Progress()
+	return fmt.Sprintf("created/updated %d of %d rows", processed-errs, processed), nil
+}
+
+var errUnparsable = errors.New("could not parse some data into the system")
+
+// OnStore, if set, is called with every row an upload stores, i.e. to derive
+// time series from it (see projection). An error counts as the row's own.
+var OnStore func(ctx context.Context, dr DailyReports) error
+
+// A daily report, parsed and checked but not stored yet
+type upload struct {
+	date    time.Time
+	indices map[string]int
+	records [][]string
+}
+
+// Parses a daily report of the given date (the Date header). On error, the
+// status is the one to respond with.
+func parseUpload(ctx context.Context, date string, body io.Reader) (*upload, int, error) {
+	reportDate, err := dates.Parse(date)
+	if date == "" || err != nil {
+		return nil, 400, err
+	}
+
+	reader := csv.NewReader(body)
 
 	// get header names
 	result, err := reader.Read()
 	if err != nil {
-		utils.HandleErr(w, 400, err)
-		return
+		return nil, 400, err
 	}
 
 	// Directly access column values
@@ -188,25 +297,41 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	for {
-		result, err = reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Fatal(err)
-		}
+	if indices["add2"] < 0 {
+		return nil, 400, errors.New("Missing Country_Region column")
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, 400, err
+	}
+
+	// The key must be allowed to upload every country in the file
+	countries := []string{}
+	for _, record := range records {
+		countries = append(countries, record[indices["add2"]])
+	}
+	if err := auth.CheckCountries(ctx, countries); err != nil {
+		return nil, 403, err
+	}
+
+	return &upload{date: reportDate, indices: indices, records: records}, 0, nil
+}
+
+// Stores every row of the report. onRow is called after each row with the
+// error that kept it from being stored, if any (errUnparsable for rows with
+// numbers that cannot be read); returning an error stops.
+func (u *upload) store(ctx context.Context, onRow func(error) error) error {
+	dr := DailyReports{}
+	indices := u.indices
+	for _, result := range u.records {
 		// Admin2 exists
 		if indices["admin2"] >= 0 && result[indices["admin2"]] != "" {
 			dr.Admin2 = result[indices["admin2"]]
 		} else {
 			indices["admin2"] = -1
 		}
-		dr.Date, err = utils.ParseDate(date)
-		if err != nil {
-			utils.HandleErr(w, 400, err)
-			return
-		}
+		dr.Date = u.date
 		// Address1 exists
 		if indices["add1"] > -1 && result[indices["add1"]] != "" {
 			dr.Address1 = result[indices["add1"]]
@@ -216,59 +341,73 @@ func Create(w http.ResponseWriter, r *http.Request) {
 
 		dr.Address2 = result[indices["add2"]]
 
-		var floatHolder float64
-		floatHolder, err = strconv.ParseFloat(result[indices["c"]], 64)
-		if err != nil {
-			notAllRead = true
-			continue
+		err := parseCounts(&dr, result, indices)
+		if err == nil {
+			_, err = injectDailyReport(ctx, indices["admin2"], indices["add1"], dr)
 		}
-		dr.Confirmed = int(floatHolder)
-
-		floatHolder, err = strconv.ParseFloat(result[indices["d"]], 64)
-		if err != nil {
-			notAllRead = true
-			continue
+		if err == nil && OnStore != nil {
+			err = OnStore(ctx, dr)
 		}
-		dr.Death = int(floatHolder)
-
-		floatHolder, err = strconv.ParseFloat(result[indices["r"]], 64)
-		if err != nil {
-			notAllRead = true
-			continue
+		if err := onRow(err); err != nil {
+			return err
 		}
-		dr.Recovered = int(floatHolder)
+	}
+	return nil
+}
 
-		floatHolder, err = strconv.ParseFloat(result[indices["a"]], 64)
-		if err != nil {
-			notAllRead = true
-			continue
-		}
-		dr.Active = int(floatHolder)
+// Counts of a stored report (nil if there is none) once metric is value.
+// Derived reports have Active recomputed, unless the stored one does not add
+// up to Confirmed - Death - Recovered: then it came with the report and is
+// kept.
+func withCount(stored map[string]int, metric string, value int, derive bool) map[string]int {
+	counts := map[string]int{}
+	for k, v := range stored {
+		counts[k] = v
+	}
+	active := func() int { return counts["Confirmed"] - counts["Death"] - counts["Recovered"] }
+	reported := stored != nil && counts["Active"] != active()
 
-		_, err := injectDailyReport(indices["admin2"], indices["add1"], dr)
-		if err != nil {
-			utils.HandleErr(w, 500, err)
-			return
-		}
+	counts[metric] = value
+	if derive && !reported {
+		counts["Active"] = active()
+	}
+	return counts
+}
 
+// Reads Confirmed, Death, Recovered and Active of a row
+func parseCounts(dr *DailyReports, result []string, indices map[string]int) error {
+	counts := []struct {
+		column string
+		value  *int
+	}{
+		{"c", &dr.Confirmed},
+		{"d", &dr.Death},
+		{"r", &dr.Recovered},
+		{"a", &dr.Active},
 	}
-	// Write to respond body
-	if notAllRead {
-		if _, err := w.Write([]byte("400 Input Error: could not parse some data into the system")); err != nil {
-			utils.HandleErr(w, 500, err)
-			return
+	for _, count := range counts {
+		if indices[count.column] < 0 {
+			return errUnparsable
 		}
-		w.WriteHeader(400)
-	} else {
-		if _, err := w.Write([]byte("201 Created: created/updated data to the system")); err != nil {
-			utils.HandleErr(w, 500, err)
-			return
+		floatHolder, err := strconv.ParseFloat(result[indices[count.column]], 64)
+		if err != nil {
+			return errUnparsable
 		}
-		w.WriteHeader(201)
+		*count.value = int(floatHolder)
+	}
+	return nil
+}
+
+// Responds to a report rejected by parseUpload
+func uploadErr(w http.ResponseWriter, status int, err error) {
+	if status == 403 {
+		auth.Forbidden(w, err)
+		return
 	}
+	utils.HandleErr(w, status, err)
 }
 
-func injectDailyReport(Admin2Index int, Address1Index int, dr DailyReports) (bool, error) {
+func injectDailyReport(ctx context.Context, Admin2Index int, Address1Index int, dr DailyReports) (bool, error) {
 	// check if address exists
 	var (
 		ID            int64
@@ -296,8 +435,7 @@ func injectDailyReport(Admin2Index int, Address1Index int, dr DailyReports) (boo
 			return false, err
 		}
 
-		layout := "2006-1-2"
-		if Admin2.String == dr.Admin2 && Date.Format(layout) == dr.Date.Format(layout) &&
+		if Admin2.String == dr.Admin2 && dates.Format(Date) == dates.Format(dr.Date) &&
 			Address1.String == dr.Address1 && Address2 == dr.Address2 {
 			AddressExists = true
 			break
@@ -343,30 +481,73 @@ func injectDailyReport(Admin2Index int, Address1Index int, dr DailyReports) (boo
 		return false, err
 	}
 
+	var res sql.Result
 	if Admin2Index >= 0 && AddressExists {
-		_, err = stmt.Exec(ID, dr.Date, dr.Admin2, dr.Address1, dr.Address2, dr.Confirmed, dr.Death, dr.Recovered, dr.Active)
+		res, err = stmt.Exec(ID, dr.Date, dr.Admin2, dr.Address1, dr.Address2, dr.Confirmed, dr.Death, dr.Recovered, dr.Active)
 		if err != nil {
 			return false, err
 		}
 	} else if (Admin2Index >= 0 && !AddressExists) || (Admin2Index < 0 && AddressExists) {
 		if Admin2Index >= 0 {
-			_, err = stmt.Exec(dr.Date, dr.Admin2, dr.Address1, dr.Address2, dr.Confirmed, dr.Death, dr.Recovered, dr.Active)
+			res, err = stmt.Exec(dr.Date, dr.Admin2, dr.Address1, dr.Address2, dr.Confirmed, dr.Death, dr.Recovered, dr.Active)
 		} else if Address1Index > -1 {
-			_, err = stmt.Exec(ID, dr.Date, dr.Address1, dr.Address2, dr.Confirmed, dr.Death, dr.Recovered, dr.Active)
+			res, err = stmt.Exec(ID, dr.Date, dr.Address1, dr.Address2, dr.Confirmed, dr.Death, dr.Recovered, dr.Active)
 		} else {
-			_, err = stmt.Exec(ID, dr.Date, dr.Address2, dr.Confirmed, dr.Death, dr.Recovered, dr.Active)
+			res, err = stmt.Exec(ID, dr.Date, dr.Address2, dr.Confirmed, dr.Death, dr.Recovered, dr.Active)
 		}
 	} else if Admin2Index < 0 && !AddressExists {
 		if Address1Index == -1 {
-			_, err = stmt.Exec(dr.Date, dr.Address2, dr.Confirmed, dr.Death, dr.Recovered, dr.Active)
+			res, err = stmt.Exec(dr.Date, dr.Address2, dr.Confirmed, dr.Death, dr.Recovered, dr.Active)
 		} else {
-			_, err = stmt.Exec(dr.Date, dr.Address1, dr.Address2, dr.Confirmed, dr.Death, dr.Recovered, dr.Active)
+			res, err = stmt.Exec(dr.Date, dr.Address1, dr.Address2, dr.Confirmed, dr.Death, dr.Recovered, dr.Active)
 		}
 	}
 	if err != nil {
 		return false, err
 	}
 
+	// New rows get their ID from the insert
+	if !AddressExists {
+		ID, err = res.LastInsertId()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// Record what changed; old values only exist if the row did
+	entries := []audit.Entry{}
+	newValues := map[string]int{
+		"Confirmed": dr.Confirmed,
+		"Death":     dr.Death,
+		"Recovered": dr.Recovered,
+		"Active":    dr.Active,
+	}
+	oldValues := map[string]int{
+		"Confirmed": Confirmed,
+		"Death":     Death,
+		"Recovered": Recovered,
+		"Active":    Active,
+	}
+	for _, metric := range []string{"Confirmed", "Death", "Recovered", "Active"} {
+		e := audit.Entry{
+			Resource:   "daily_reports",
+			LocationID: ID,
+			Admin2:     dr.Admin2,
+			Address1:   dr.Address1,
+			Address2:   dr.Address2,
+			Date:       dr.Date,
+			Metric:     metric,
+			NewValue:   audit.Value(newValues[metric]),
+		}
+		if AddressExists {
+			e.OldValue = audit.Value(oldValues[metric])
+		}
+		entries = append(entries, e)
+	}
+	if err := audit.Record(ctx, entries...); err != nil {
+		return false, err
+	}
+
 	return true, nil
 }
 
@@ -382,6 +563,9 @@ func makeQuery(params map[string][]string) (string, int) {
 		return query, status
 	}
 
+	// Relative dates (e.g. "latest", "-30d") are resolved against this table
+	latest := utils.LatestDate("DailyReports")
+
 	whereCounter := 0
 	for param, value := range params {
 		param = strings.ToLower(param)
@@ -392,6 +576,11 @@ func makeQuery(params map[string][]string) (string, int) {
 			return "", 400
 		}
 
+		// Daily reports are not versioned
+		if param == "as_of" {
+			return "", 400
+		}
+
 		// Ignore these parameters
 		if param == "confirmed" ||
 			param == "death" ||
@@ -410,6 +599,10 @@ func makeQuery(params map[string][]string) (string, int) {
 			}
 			param = "date"
 		}
+		// A whole week/month, i.e. range=2020-W12 or month=2021-03
+		if param == "range" || param == "month" {
+			param = "date"
+		}
 
 		value := strings.Split(value[0], ",")
 
@@ -424,13 +617,12 @@ func makeQuery(params map[string][]string) (string, int) {
 			_, ok := stringParams[param]
 			if ok {
 				if param == "date" {
-					// mm/dd/yy
-					temp, err := utils.ParseDate(v)
+					// Any expression understood by dates.Resolve
+					rng, err := dates.Resolve(v, latest)
 					if err != nil {
 						return "", 400
 					}
-					value[i] = temp.Format("2006/1/2")
-					value[i] = fmt.Sprintf(`"%s"`, value[i])
+					value[i] = utils.DateCondition(param, op, rng)
 				} else {
 					value[i] = stringParams[param]
 				}
@@ -442,15 +634,21 @@ func makeQuery(params map[string][]string) (string, int) {
 				}
 			}
 
+			// Date conditions are already formatted by DateCondition
+			cond := param + op + value[i]
+			if param == "date" {
+				cond = value[i]
+			}
+
 			// Format first param and after
 			if whereCounter == 0 {
-				query += "WHERE " + param + op + value[i]
+				query += "WHERE " + cond
 				whereCounter++
 			} else {
 				if i != 0 {
-					query += " OR " + param + op + value[i]
+					query += " OR " + cond
 				} else {
-					query += " AND " + param + op + value[i]
+					query += " AND " + cond
 				}
 
 			}
@@ -485,3 +683,41 @@ func nullIntHandler(dr *DailyReports, ni map[string]*sql.NullInt64) {
 		dr.Active = int(ni["active"].Int64)
 	}
 }
+
+// SetCount overwrites one metric (Confirmed, Death or Recovered) of the
+// stored report of dr's location and date, keeping its other counts. Used
+// to reconcile daily reports with time series; the change is audited as an
+// upload would be.
+func SetCount(ctx context.Context, dr DailyReports, metric string, value int) error {
+	return setCount(ctx, dr, metric, value, false)
+}
+
+// DeriveCount is SetCount for reports derived from time series: a missing
+// report is created with its other counts at 0, and Active is recomputed as
+// Confirmed - Death - Recovered, unless the report came with its own.
+func DeriveCount(ctx context.Context, dr DailyReports, metric string, value int) error {
+	return setCount(ctx, dr, metric, value, true)
+}
+
+func setCount(ctx context.Context, dr DailyReports, metric string, value int, derive bool) error {
+	id, stored, err := findReport(dr)
+	if err != nil {
+		return err
+	}
+	if id == nil && !derive {
+		return fmt.Errorf("no daily report for %s on %s", dr.Address2, dates.Format(dr.Date))
+	}
+	stored = withCount(stored, metric, value, derive)
+	dr.Confirmed, dr.Death = stored["Confirmed"], stored["Death"]
+	dr.Recovered, dr.Active = stored["Recovered"], stored["Active"]
+
+	admin2Index, address1Index := 0, 0
+	if dr.Admin2 == "" {
+		admin2Index = -1
+	}
+	if dr.Address1 == "" {
+		address1Index = -1
+	}
+	_, err = injectDailyReport(ctx, admin2Index, address1Index, dr)
+	return err
+}
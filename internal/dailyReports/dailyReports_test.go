@@ -2,6 +2,7 @@ package dailyReports
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"testing"
 
+	"gitlab.com/csc301-assignments/a2/internal/auth"
 	db "gitlab.com/csc301-assignments/a2/internal/db"
 )
 
@@ -130,27 +132,52 @@ func TestMakeQueryWithDateParams(t *testing.T) {
 	lines := strings.Split(query, "\n")
 	lastline := lines[len(lines)-1]
 
-	checker := "date>=\"2020/1/1\""
+	checker := "date>=\"2020-01-01\""
 	if !strings.Contains(lastline, checker) {
 		t.Fatalf("Test failed: query does not contain %s", checker)
 	}
 
-	checker = "date<=\"2022/1/1\""
+	checker = "date<=\"2022-01-01\""
 	if !strings.Contains(lastline, checker) {
 		t.Fatalf("Test failed: query does not contain %s", checker)
 	}
 
-	checker = "date=\"2020/11/16\""
+	checker = "date=\"2020-11-16\""
 	if !strings.Contains(lastline, checker) {
 		t.Fatalf("Test failed: query does not contain %s", checker)
 	}
 
-	checker = "date=\"2021/2/14\""
+	checker = "date=\"2021-02-14\""
 	if !strings.Contains(lastline, checker) {
 		t.Fatalf("Test failed: query does not contain %s", checker)
 	}
 }
 
+func TestMakeQueryWithNamedRanges(t *testing.T) {
+	r := httptest.NewRequest(
+		"GET",
+		"http://example.com/foo?range=2020-W12",
+		nil)
+	query, status := makeQuery(r.URL.Query())
+	if status != 0 {
+		t.Fatalf("Test failed: expected status 0, got %d", status)
+	}
+	lines := strings.Split(query, "\n")
+	lastline := lines[len(lines)-1]
+
+	checker := "WHERE (date>=\"2020-03-16\" AND date<=\"2020-03-22\")"
+	if !strings.Contains(lastline, checker) {
+		t.Fatalf("Test failed: query does not contain %s", checker)
+	}
+
+	r = httptest.NewRequest("GET", "http://example.com/foo?month=2021-02", nil)
+	query, _ = makeQuery(r.URL.Query())
+	checker = "(date>=\"2021-02-01\" AND date<=\"2021-02-28\")"
+	if !strings.Contains(query, checker) {
+		t.Fatalf("Test failed: query does not contain %s", checker)
+	}
+}
+
 func TestMakeQueryWithAddressParams(t *testing.T) {
 	r := httptest.NewRequest("GET", "http://example.com/foo?country=canada,us&province=ontario&admin2=toronto", nil)
 	query, _ := makeQuery(r.URL.Query())
@@ -192,6 +219,12 @@ func TestMakeQueryInvalidParams(t *testing.T) {
 	if status != 400 {
 		t.Fatalf("Test failed: response status (%d) not 400", status)
 	}
+	// only time series are versioned
+	r = httptest.NewRequest("GET", "http://example.com/foo?as_of=2021-03-01T00:00:00Z", nil)
+	_, status = makeQuery(r.URL.Query())
+	if status != 400 {
+		t.Fatalf("Test failed: response status (%d) not 400", status)
+	}
 }
 
 func TestListNoParams(t *testing.T) {
@@ -354,8 +387,8 @@ func TestCreateBadHeader(t *testing.T) {
 
 	w = httptest.NewRecorder()
 	r = httptest.NewRequest("POST", "http://example.com/foo", b)
-	//test invalid header
-	r.Header.Set("Date", "1/20/2021")
+	//test invalid header (February 30th does not exist)
+	r.Header.Set("Date", "2/30/21")
 
 	// Goal: call Create()
 	Create(w, r)
@@ -438,3 +471,81 @@ func TestCreateDuplicateAddress(t *testing.T) {
 		t.Fatalf("Test failed: expected code %v, got %v", oldDailyReportsArr, newDailyReportsArr)
 	}
 }
+
+func TestParseUpload(t *testing.T) {
+	csvFile := "Admin2,Province_State,Country_Region,Confirmed,Deaths,Recovered,Active\n,Ontario,Canada,5,1,2,2\n"
+	u, status, err := parseUpload(context.Background(), "2021-03-01", strings.NewReader(csvFile))
+	if err != nil || status != 0 {
+		t.Fatalf("Test failed: expected no error, got %d %v", status, err)
+	}
+	if u.date.Format("2006-01-02") != "2021-03-01" || u.indices["add2"] != 2 || len(u.records) != 1 {
+		t.Fatalf("Test failed: unexpected upload %+v", u)
+	}
+
+	if _, status, _ = parseUpload(context.Background(), "", strings.NewReader(csvFile)); status != 400 {
+		t.Fatalf("Test failed: expected 400 without a date, got %d", status)
+	}
+
+	noCountry := "Admin2,Province_State,Confirmed\n,Ontario,5\n"
+	if _, status, _ = parseUpload(context.Background(), "2021-03-01", strings.NewReader(noCountry)); status != 400 {
+		t.Fatalf("Test failed: expected 400 without Country_Region, got %d", status)
+	}
+
+	ctx := auth.WithKey(context.Background(), auth.Key{ID: 1, Role: auth.RoleUploader, Scopes: []string{"country:US"}}, 0)
+	if _, status, _ = parseUpload(ctx, "2021-03-01", strings.NewReader(csvFile)); status != 403 {
+		t.Fatalf("Test failed: expected 403 for an out of scope country, got %d", status)
+	}
+}
+
+func TestParseCounts(t *testing.T) {
+	indices := map[string]int{"c": 0, "d": 1, "r": 2, "a": 3}
+	dr := DailyReports{}
+	if err := parseCounts(&dr, []string{"5", "1.0", "2", "2"}, indices); err != nil {
+		t.Fatalf("Test failed: expected no error, got %v", err)
+	}
+	if dr.Confirmed != 5 || dr.Death != 1 || dr.Recovered != 2 || dr.Active != 2 {
+		t.Fatalf("Test failed: unexpected counts %+v", dr)
+	}
+
+	if err := parseCounts(&dr, []string{"5", "", "2", "2"}, indices); err != errUnparsable {
+		t.Fatalf("Test failed: expected errUnparsable, got %v", err)
+	}
+
+	// Missing column
+	indices["a"] = -1
+	if err := parseCounts(&dr, []string{"5", "1", "2", "2"}, indices); err != errUnparsable {
+		t.Fatalf("Test failed: expected errUnparsable, got %v", err)
+	}
+}
+
+func TestWithCount(t *testing.T) {
+	// New derived reports start at 0, with Active computed
+	counts := withCount(nil, "Confirmed", 10, true)
+	if counts["Confirmed"] != 10 || counts["Death"] != 0 || counts["Active"] != 10 {
+		t.Fatalf("Test failed: unexpected new report %v", counts)
+	}
+
+	// Derived Active follows the other counts
+	stored := map[string]int{"Confirmed": 10, "Death": 0, "Recovered": 0, "Active": 10}
+	counts = withCount(stored, "Death", 2, true)
+	if counts["Death"] != 2 || counts["Active"] != 8 {
+		t.Fatalf("Test failed: expected Active to be recomputed, got %v", counts)
+	}
+	if stored["Death"] != 0 {
+		t.Fatalf("Test failed: stored counts were modified")
+	}
+
+	// An Active uploaded with the report is kept
+	stored = map[string]int{"Confirmed": 10, "Death": 1, "Recovered": 4, "Active": 7}
+	counts = withCount(stored, "Death", 2, true)
+	if counts["Death"] != 2 || counts["Active"] != 7 {
+		t.Fatalf("Test failed: expected the reported Active to be kept, got %v", counts)
+	}
+
+	// Reconciliation only sets the metric
+	stored = map[string]int{"Confirmed": 10, "Death": 0, "Recovered": 0, "Active": 10}
+	counts = withCount(stored, "Confirmed", 12, false)
+	if counts["Confirmed"] != 12 || counts["Active"] != 10 {
+		t.Fatalf("Test failed: expected Active to be left alone, got %v", counts)
+	}
+}
@@ -0,0 +1,85 @@
+package dailyReports
+
+import (
+	// Built-ins
+	"database/sql"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/audit"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/preview"
+)
+
+// Works out what store would do to every count of the report, without
+// writing anything
+func (u *upload) plan() (*preview.Plan, error) {
+	plan := preview.New()
+	for _, result := range u.records {
+		dr := DailyReports{Date: u.date, Address2: result[u.indices["add2"]]}
+		if i := u.indices["admin2"]; i >= 0 {
+			dr.Admin2 = result[i]
+		}
+		if i := u.indices["add1"]; i >= 0 {
+			dr.Address1 = result[i]
+		}
+
+		id, existing, err := findReport(dr)
+		if err != nil {
+			return nil, err
+		}
+		parseErr := parseCounts(&dr, result, u.indices)
+
+		for _, metric := range []string{"Confirmed", "Death", "Recovered", "Active"} {
+			c := preview.Change{
+				Admin2:   dr.Admin2,
+				Address1: dr.Address1,
+				Address2: dr.Address2,
+				Date:     dr.Date,
+				Metric:   metric,
+			}
+			if id != nil {
+				c.LocationID = id
+				c.OldValue = audit.Value(existing[metric])
+			}
+			if parseErr != nil {
+				c.Reason = parseErr.Error()
+			} else {
+				c.NewValue = audit.Value(counts(dr)[metric])
+			}
+			plan.Add(c)
+		}
+	}
+	return plan, nil
+}
+
+// Finds the stored report injectDailyReport would replace with dr, if any,
+// and its counts by metric
+func findReport(dr DailyReports) (*int64, map[string]int, error) {
+	var id int64
+	var confirmed, death, recovered, active sql.NullInt64
+	err := db.Db.QueryRow(`
+		SELECT ID, Confirmed, Death, Recovered, Active FROM DailyReports
+		WHERE Date = ? AND IFNULL(Admin2, '') = ? AND IFNULL(Address1, '') = ? AND Address2 = ?
+	`, dr.Date, dr.Admin2, dr.Address1, dr.Address2).Scan(&id, &confirmed, &death, &recovered, &active)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &id, map[string]int{
+		"Confirmed": int(confirmed.Int64),
+		"Death":     int(death.Int64),
+		"Recovered": int(recovered.Int64),
+		"Active":    int(active.Int64),
+	}, nil
+}
+
+func counts(dr DailyReports) map[string]int {
+	return map[string]int{
+		"Confirmed": dr.Confirmed,
+		"Death":     dr.Death,
+		"Recovered": dr.Recovered,
+		"Active":    dr.Active,
+	}
+}
@@ -0,0 +1,79 @@
+package dates
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Layout is the ISO-8601 calendar date layout used for every date the API
+// writes, whether in SQL, CSV or JSON responses.
+const Layout = "2006-01-02"
+
+var (
+	// i.e. "2020-01-31"
+	isoPattern = regexp.MustCompile(`^(\d{4})-(\d{1,2})-(\d{1,2})$`)
+	// i.e. "1/31/20" or "1/31/2020"
+	legacyPattern = regexp.MustCompile(`^(\d{1,2})/(\d{1,2})/(\d{2}|\d{4})$`)
+)
+
+// Parse takes a date string in any of the accepted formats and returns it
+// as a time.Time at midnight UTC. Accepted formats are:
+//   - yyyy-mm-dd (ISO-8601)
+//   - m/d/yy (two digit years follow Go's convention: 69-99 -> 19xx, 00-68 -> 20xx)
+//   - m/d/yyyy
+//
+// Dates that do not exist on the calendar (e.g. 2/31/20) are rejected.
+func Parse(date string) (time.Time, error) {
+	var year, month, day int
+	if m := isoPattern.FindStringSubmatch(date); m != nil {
+		year, _ = strconv.Atoi(m[1])
+		month, _ = strconv.Atoi(m[2])
+		day, _ = strconv.Atoi(m[3])
+	} else if m := legacyPattern.FindStringSubmatch(date); m != nil {
+		month, _ = strconv.Atoi(m[1])
+		day, _ = strconv.Atoi(m[2])
+		year, _ = strconv.Atoi(m[3])
+		if len(m[3]) == 2 {
+			if year >= 69 {
+				year += 1900
+			} else {
+				year += 2000
+			}
+		}
+	} else {
+		return time.Time{}, errors.New("Syntax Error")
+	}
+
+	if month < 1 || month > 12 {
+		return time.Time{}, errors.New("Month Syntax Error")
+	}
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	// time.Date normalizes overflowing days (2/31 -> 3/2)
+	if day < 1 || t.Day() != day {
+		return time.Time{}, errors.New("Day Syntax Error")
+	}
+	return t, nil
+}
+
+// LooksLikeDate reports whether a CSV header looks like a date column. This
+// only checks the shape of the string so that malformed dates (e.g. 13/1/20)
+// are still picked up as dates and rejected by Parse rather than skipped.
+func LooksLikeDate(s string) bool {
+	slashes := 0
+	for _, c := range s {
+		if c == '/' {
+			slashes++
+		}
+	}
+	if slashes == 2 {
+		return true
+	}
+	return isoPattern.MatchString(s)
+}
+
+// Format writes the date in the ISO-8601 layout
+func Format(t time.Time) string {
+	return t.Format(Layout)
+}
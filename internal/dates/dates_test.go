@@ -0,0 +1,256 @@
+package dates
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseValidInput(t *testing.T) {
+	// 1 Jan 2020
+	input := "1/31/20"
+	date, err := Parse(input)
+	if err != nil {
+		t.Errorf("Error while parsing date: %v", err)
+	}
+
+	expect := time.Date(2020, time.Month(1), 31, 0, 0, 0, 0, time.UTC)
+
+	if expect != date {
+		t.Fatalf("Test failed: Expected %s, got %s", expect.String(), date.String())
+	}
+}
+
+func TestParseInvalidInput(t *testing.T) {
+	// Month over 12
+	input := "31/1/20"
+	date, err := Parse(input)
+	if err == nil {
+		t.Fatalf("Test failed: Error not raised")
+	}
+	expect := time.Time{}
+	if date != expect {
+		t.Fatalf("Test failed: Date not default:%s", date.String())
+	}
+
+	// Date over 99
+	input = "1/99/20"
+	date, err = Parse(input)
+	if err == nil {
+		t.Fatalf("Test failed: Error not raised")
+	}
+	expect = time.Time{}
+	if date != expect {
+		t.Fatalf("Test failed: Date not default:%s", date.String())
+	}
+
+	// Year over 999
+	input = "1/1/999"
+	date, err = Parse(input)
+	if err == nil {
+		t.Fatalf("Test failed: Error not raised")
+	}
+	expect = time.Time{}
+	if date != expect {
+		t.Fatalf("Test failed: Date not default:%s", date.String())
+	}
+
+	// Length after split not 3
+	input = "abc"
+	date, err = Parse(input)
+	if err == nil {
+		t.Fatalf("Test failed: Error not raised")
+	}
+	expect = time.Time{}
+	if date != expect {
+		t.Fatalf("Test failed: Date not default:%s", date.String())
+	}
+
+	// Bad inputs
+	input = "a/1/20"
+	date, err = Parse(input)
+	if err == nil {
+		t.Fatalf("Test failed: Error not raised")
+	}
+	expect = time.Time{}
+	if date != expect {
+		t.Fatalf("Test failed: Date not default:%s", date.String())
+	}
+
+	input = "1/b/20"
+	date, err = Parse(input)
+	if err == nil {
+		t.Fatalf("Test failed: Error not raised")
+	}
+	expect = time.Time{}
+	if date != expect {
+		t.Fatalf("Test failed: Date not default:%s", date.String())
+	}
+
+	input = "1/1/c"
+	date, err = Parse(input)
+	if err == nil {
+		t.Fatalf("Test failed: Error not raised")
+	}
+	expect = time.Time{}
+	if date != expect {
+		t.Fatalf("Test failed: Date not default:%s", date.String())
+	}
+}
+
+func TestParseISOAndLongYear(t *testing.T) {
+	expect := time.Date(2020, time.Month(1), 31, 0, 0, 0, 0, time.UTC)
+	for _, input := range []string{"2020-01-31", "2020-1-31", "1/31/2020", "01/31/20"} {
+		date, err := Parse(input)
+		if err != nil {
+			t.Fatalf("Test failed: error while parsing %s: %v", input, err)
+		}
+		if expect != date {
+			t.Fatalf("Test failed: Expected %s, got %s", expect.String(), date.String())
+		}
+	}
+
+	// Two digit years before 69 belong to this century
+	date, err := Parse("4/5/68")
+	if err != nil {
+		t.Fatalf("Test failed: error while parsing: %v", err)
+	}
+	if date.Year() != 2068 {
+		t.Fatalf("Test failed: expected 2068, got %d", date.Year())
+	}
+	date, err = Parse("4/5/69")
+	if err != nil {
+		t.Fatalf("Test failed: error while parsing: %v", err)
+	}
+	if date.Year() != 1969 {
+		t.Fatalf("Test failed: expected 1969, got %d", date.Year())
+	}
+}
+
+func TestParseImpossibleDates(t *testing.T) {
+	inputs := []string{
+		"2/31/20",    // February 31st
+		"2/29/21",    // not a leap year
+		"0/1/20",     // month 0
+		"1/0/20",     // day 0
+		"2020-13-01", // month 13
+		"2020-02-30",
+		"20-01-31", // short ISO year
+	}
+	for _, input := range inputs {
+		date, err := Parse(input)
+		if err == nil {
+			t.Fatalf("Test failed: Error not raised for %s", input)
+		}
+		if date != (time.Time{}) {
+			t.Fatalf("Test failed: Date not default:%s", date.String())
+		}
+	}
+
+	// Leap day is fine
+	if _, err := Parse("2/29/20"); err != nil {
+		t.Fatalf("Test failed: 2/29/20 should be valid: %v", err)
+	}
+}
+
+func TestLooksLikeDate(t *testing.T) {
+	dates := []string{"1/31/20", "13/1/20", "2020-01-31", "a/b/c"}
+	for _, v := range dates {
+		if !LooksLikeDate(v) {
+			t.Fatalf("Test failed: %s should look like a date", v)
+		}
+	}
+
+	notDates := []string{"Admin2", "Province/State", "Country_Region", "Lat", "2020-01"}
+	for _, v := range notDates {
+		if LooksLikeDate(v) {
+			t.Fatalf("Test failed: %s should not look like a date", v)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	date := time.Date(2021, time.Month(3), 4, 0, 0, 0, 0, time.UTC)
+	expect := "2021-03-04"
+	if res := Format(date); res != expect {
+		t.Fatalf("Test failed: expected %s, got %s", expect, res)
+	}
+}
+
+func TestResolveNamedAndRelative(t *testing.T) {
+	latest := time.Date(2021, time.Month(3), 15, 0, 0, 0, 0, time.UTC)
+	anchor := func() (time.Time, error) { return latest, nil }
+
+	cases := map[string]time.Time{
+		"latest":     latest,
+		"LATEST":     latest,
+		"-30d":       time.Date(2021, time.Month(2), 13, 0, 0, 0, 0, time.UTC),
+		"-2w":        time.Date(2021, time.Month(3), 1, 0, 0, 0, 0, time.UTC),
+		"-1m":        time.Date(2021, time.Month(2), 15, 0, 0, 0, 0, time.UTC),
+		"2020-01-31": time.Date(2020, time.Month(1), 31, 0, 0, 0, 0, time.UTC),
+		"1/31/20":    time.Date(2020, time.Month(1), 31, 0, 0, 0, 0, time.UTC),
+	}
+	for expr, expect := range cases {
+		rng, err := Resolve(expr, anchor)
+		if err != nil {
+			t.Fatalf("Test failed: error while resolving %s: %v", expr, err)
+		}
+		if !rng.IsSingle() || !rng.From.Equal(expect) {
+			t.Fatalf("Test failed: %s expected %s, got %s..%s", expr, expect, rng.From, rng.To)
+		}
+	}
+
+	// today does not depend on the dataset
+	rng, err := Resolve("today", nil)
+	if err != nil {
+		t.Fatalf("Test failed: error while resolving today: %v", err)
+	}
+	if rng.From.After(time.Now()) || time.Since(rng.From) > 24*time.Hour {
+		t.Fatalf("Test failed: today resolved to %s", rng.From)
+	}
+
+	// Relative dates need a dataset
+	if _, err := Resolve("latest", nil); err == nil {
+		t.Fatalf("Test failed: expected an error without an anchor")
+	}
+}
+
+func TestResolveWeekAndMonth(t *testing.T) {
+	rng, err := Resolve("2020-W12", nil)
+	if err != nil {
+		t.Fatalf("Test failed: error while resolving week: %v", err)
+	}
+	expectFrom := time.Date(2020, time.Month(3), 16, 0, 0, 0, 0, time.UTC)
+	expectTo := time.Date(2020, time.Month(3), 22, 0, 0, 0, 0, time.UTC)
+	if !rng.From.Equal(expectFrom) || !rng.To.Equal(expectTo) {
+		t.Fatalf("Test failed: expected %s..%s, got %s..%s", expectFrom, expectTo, rng.From, rng.To)
+	}
+
+	// 2021-01-01 is in the last week of 2020
+	rng, err = Resolve("2020-W53", nil)
+	if err != nil {
+		t.Fatalf("Test failed: error while resolving week: %v", err)
+	}
+	expectFrom = time.Date(2020, time.Month(12), 28, 0, 0, 0, 0, time.UTC)
+	if !rng.From.Equal(expectFrom) {
+		t.Fatalf("Test failed: expected %s, got %s", expectFrom, rng.From)
+	}
+	if _, err := Resolve("2021-W53", nil); err == nil {
+		t.Fatalf("Test failed: 2021 has no week 53")
+	}
+
+	rng, err = Resolve("2021-02", nil)
+	if err != nil {
+		t.Fatalf("Test failed: error while resolving month: %v", err)
+	}
+	expectFrom = time.Date(2021, time.Month(2), 1, 0, 0, 0, 0, time.UTC)
+	expectTo = time.Date(2021, time.Month(2), 28, 0, 0, 0, 0, time.UTC)
+	if !rng.From.Equal(expectFrom) || !rng.To.Equal(expectTo) {
+		t.Fatalf("Test failed: expected %s..%s, got %s..%s", expectFrom, expectTo, rng.From, rng.To)
+	}
+
+	for _, expr := range []string{"2021-13", "2021-W0", "-30x", "soon"} {
+		if _, err := Resolve(expr, nil); err == nil {
+			t.Fatalf("Test failed: expected an error for %s", expr)
+		}
+	}
+}
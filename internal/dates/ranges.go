@@ -0,0 +1,130 @@
+package dates
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Range is an inclusive span of calendar days. Single dates have From == To.
+type Range struct {
+	From time.Time
+	To   time.Time
+}
+
+// Anchor returns the last date that has data in the dataset being queried.
+// It is only called when an expression is relative to the dataset.
+type Anchor func() (time.Time, error)
+
+var (
+	// i.e. "-30d", "-2w", "-3m"
+	relativePattern = regexp.MustCompile(`^-(\d+)([dwm])$`)
+	// i.e. "2020-W12"
+	weekPattern = regexp.MustCompile(`^(\d{4})-[wW](\d{1,2})$`)
+	// i.e. "2021-03"
+	monthPattern = regexp.MustCompile(`^(\d{4})-(\d{1,2})$`)
+)
+
+// Single wraps a date into a one day Range
+func Single(t time.Time) Range {
+	return Range{From: t, To: t}
+}
+
+// IsSingle reports whether the range covers exactly one day
+func (r Range) IsSingle() bool {
+	return r.From.Equal(r.To)
+}
+
+// Resolve turns a date expression into a Range. Besides the formats accepted
+// by Parse, it understands:
+//   - "latest": the last date available in the dataset
+//   - "-30d", "-2w", "-3m": days, weeks or months before "latest"
+//   - "today", "yesterday": calendar days (UTC) regardless of the dataset
+//   - "2020-W12": ISO-8601 week, Monday to Sunday
+//   - "2021-03": a calendar month
+//
+// latest may be nil if the caller knows no relative expressions are used.
+func Resolve(expr string, latest Anchor) (Range, error) {
+	expr = strings.ToLower(strings.TrimSpace(expr))
+
+	switch expr {
+	case "latest":
+		t, err := anchor(latest)
+		if err != nil {
+			return Range{}, err
+		}
+		return Single(t), nil
+	case "today":
+		return Single(today()), nil
+	case "yesterday":
+		return Single(today().AddDate(0, 0, -1)), nil
+	}
+
+	if m := relativePattern.FindStringSubmatch(expr); m != nil {
+		t, err := anchor(latest)
+		if err != nil {
+			return Range{}, err
+		}
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "d":
+			t = t.AddDate(0, 0, -n)
+		case "w":
+			t = t.AddDate(0, 0, -7*n)
+		case "m":
+			t = t.AddDate(0, -n, 0)
+		}
+		return Single(t), nil
+	}
+
+	if m := weekPattern.FindStringSubmatch(expr); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		week, _ := strconv.Atoi(m[2])
+		return isoWeek(year, week)
+	}
+
+	if m := monthPattern.FindStringSubmatch(expr); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		if month < 1 || month > 12 {
+			return Range{}, errors.New("Month Syntax Error")
+		}
+		from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+		return Range{From: from, To: from.AddDate(0, 1, -1)}, nil
+	}
+
+	t, err := Parse(expr)
+	if err != nil {
+		return Range{}, err
+	}
+	return Single(t), nil
+}
+
+func anchor(latest Anchor) (time.Time, error) {
+	if latest == nil {
+		return time.Time{}, errors.New("No dataset to resolve relative date against")
+	}
+	return latest()
+}
+
+func today() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// isoWeek returns Monday to Sunday of the given ISO-8601 week
+func isoWeek(year int, week int) (Range, error) {
+	if week < 1 || week > 53 {
+		return Range{}, errors.New("Week Syntax Error")
+	}
+	// January 4th is always in week 1
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	offset := (int(jan4.Weekday()) + 6) % 7 // days since Monday
+	from := jan4.AddDate(0, 0, -offset+(week-1)*7)
+	if y, w := from.ISOWeek(); y != year || w != week {
+		return Range{}, errors.New("Week Syntax Error")
+	}
+	return Range{From: from, To: from.AddDate(0, 0, 6)}, nil
+}
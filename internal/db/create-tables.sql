@@ -1,14 +1,32 @@
+DROP TABLE IF EXISTS ImportedFiles CASCADE;
+DROP TABLE IF EXISTS ProcessedUploads CASCADE;
+DROP TABLE IF EXISTS Jobs CASCADE;
+DROP TABLE IF EXISTS TimeSeriesRevisions CASCADE;
+DROP TABLE IF EXISTS AuditLog CASCADE;
 DROP TABLE IF EXISTS TimeSeriesConfirmed CASCADE;
 DROP TABLE IF EXISTS TimeSeriesDeath CASCADE;
 DROP TABLE IF EXISTS TimeSeriesRecovered CASCADE;
 DROP TABLE IF EXISTS TimeSeries CASCADE;
 DROP TABLE IF EXISTS DailyReports CASCADE;
+DROP TABLE IF EXISTS Uploads CASCADE;
+DROP TABLE IF EXISTS ApiKeys CASCADE;
 
 CREATE TABLE TimeSeries(
 	ID INT AUTO_INCREMENT,
 	Admin2 VARCHAR(128),
 	Address1 VARCHAR(128),
 	Address2 VARCHAR(128) NOT NULL,
+	-- Location attributes of the JHU US files; Lat and Long_ are also in the
+	-- global ones. Population is only in the deaths files
+	UID BIGINT,
+	Iso2 CHAR(2),
+	Iso3 CHAR(3),
+	Code3 INT,
+	FIPS INT,
+	Lat DOUBLE,
+	Long_ DOUBLE,
+	CombinedKey VARCHAR(255),
+	Population BIGINT,
 	PRIMARY KEY(ID),
 	CONSTRAINT AddressKey UNIQUE (Admin2,Address1,Address2)
 );
@@ -51,8 +69,136 @@ CREATE TABLE DailyReports(
 	CONSTRAINT ADKey UNIQUE (Date,Admin2,Address1,Address2)
 );
 
-INSERT INTO TimeSeries(Admin2, Address1, Address2)
-VALUES('Autauga', 'Alabama', 'US');
+-- Only the SHA-256 digest of each key is stored.
+-- Role is reader, uploader or admin; Scopes is a comma separated list of
+-- resources (time_series, daily_reports) and countries (country:Canada)
+CREATE TABLE ApiKeys(
+	ID INT AUTO_INCREMENT,
+	Name VARCHAR(128) NOT NULL,
+	KeyHash CHAR(64) NOT NULL,
+	Role VARCHAR(16) NOT NULL DEFAULT 'uploader',
+	Scopes VARCHAR(1024) NOT NULL DEFAULT '',
+	CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	Revoked BOOLEAN NOT NULL DEFAULT FALSE,
+	PRIMARY KEY(ID),
+	CONSTRAINT KeyHashKey UNIQUE (KeyHash)
+);
+
+-- Every write request and the key that performed it
+CREATE TABLE Uploads(
+	ID INT AUTO_INCREMENT,
+	KeyID INT,
+	Method VARCHAR(8) NOT NULL,
+	Path VARCHAR(255) NOT NULL,
+	Status INT,
+	CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(ID),
+	FOREIGN KEY (KeyID) REFERENCES ApiKeys(ID)
+);
+
+-- Responses of past uploads, replayed to repeats of the same upload.
+-- Status is NULL while the upload is being processed. AuditID is the last
+-- AuditLog row of Resource when the upload finished
+CREATE TABLE ProcessedUploads(
+	ID INT AUTO_INCREMENT,
+	Resource VARCHAR(32) NOT NULL,
+	KeyID INT,
+	IdempotencyKey VARCHAR(255),
+	ContentHash CHAR(64) NOT NULL,
+	UploadID INT,
+	Status INT,
+	ContentType VARCHAR(128),
+	Location VARCHAR(255),
+	Response MEDIUMBLOB,
+	AuditID INT,
+	CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(ID),
+	CONSTRAINT IdempotencyKeyKey UNIQUE (KeyID, IdempotencyKey),
+	INDEX ContentKey (Resource, ContentHash),
+	FOREIGN KEY (KeyID) REFERENCES ApiKeys(ID),
+	FOREIGN KEY (UploadID) REFERENCES Uploads(ID)
+);
+
+-- Uploads sent with async=true, stored by background workers. The file is
+-- kept in Payload so that jobs survive a restart
+CREATE TABLE Jobs(
+	ID INT AUTO_INCREMENT,
+	Kind VARCHAR(32) NOT NULL,
+	Header VARCHAR(128) NOT NULL DEFAULT '',
+	Payload LONGBLOB NOT NULL,
+	Status VARCHAR(16) NOT NULL DEFAULT 'queued',
+	Total INT NOT NULL DEFAULT 0,
+	Processed INT NOT NULL DEFAULT 0,
+	Errors INT NOT NULL DEFAULT 0,
+	LastError TEXT,
+	Result TEXT,
+	KeyID INT,
+	UploadID INT,
+	CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	StartedAt DATETIME,
+	FinishedAt DATETIME,
+	PRIMARY KEY(ID),
+	INDEX StatusKey (Status),
+	FOREIGN KEY (KeyID) REFERENCES ApiKeys(ID),
+	FOREIGN KEY (UploadID) REFERENCES Uploads(ID)
+);
+
+-- Every version of every TimeSeries value, so values can be queried as they
+-- were known at a point in time. RecordedAt is in UTC
+CREATE TABLE TimeSeriesRevisions(
+	RevisionID INT AUTO_INCREMENT,
+	ID INT NOT NULL,
+	Type VARCHAR(16) NOT NULL,
+	Date Date NOT NULL,
+	Value INT NOT NULL,
+	UploadID INT,
+	RecordedAt DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	PRIMARY KEY(RevisionID),
+	INDEX ValueKey (ID, Type, Date, RecordedAt),
+	FOREIGN KEY (ID) REFERENCES TimeSeries(ID),
+	FOREIGN KEY (UploadID) REFERENCES Uploads(ID)
+);
+
+-- One row per value changed by an upload. OldValue is NULL for new values.
+-- Rows can only be appended; the triggers reject updates and deletes
+CREATE TABLE AuditLog(
+	ID INT AUTO_INCREMENT,
+	UploadID INT,
+	KeyID INT,
+	Resource VARCHAR(32) NOT NULL,
+	LocationID INT NOT NULL,
+	Admin2 VARCHAR(128),
+	Address1 VARCHAR(128),
+	Address2 VARCHAR(128) NOT NULL,
+	Date Date NOT NULL,
+	Metric VARCHAR(16) NOT NULL,
+	OldValue INT,
+	NewValue INT,
+	CreatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(ID),
+	FOREIGN KEY (UploadID) REFERENCES Uploads(ID),
+	FOREIGN KEY (KeyID) REFERENCES ApiKeys(ID)
+);
+
+CREATE TRIGGER AuditLogNoUpdate BEFORE UPDATE ON AuditLog FOR EACH ROW
+SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'AuditLog is append-only';
+
+CREATE TRIGGER AuditLogNoDelete BEFORE DELETE ON AuditLog FOR EACH ROW
+SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'AuditLog is append-only';
+
+-- Files ingested by the sync command, so unchanged files are skipped.
+-- Path is relative to the synced directory
+CREATE TABLE ImportedFiles(
+	Path VARCHAR(512) NOT NULL,
+	Kind VARCHAR(32) NOT NULL,
+	ContentHash CHAR(64) NOT NULL,
+	Result TEXT,
+	ImportedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(Path)
+);
+
+INSERT INTO TimeSeries(Admin2, Address1, Address2, UID, Iso2, Iso3, Code3, FIPS, Lat, Long_, CombinedKey, Population)
+VALUES('Autauga', 'Alabama', 'US', 84001001, 'US', 'USA', 840, 1001, 32.53952745, -86.64408227, 'Autauga, Alabama, US', 55869);
 
 INSERT INTO TimeSeries(Address1, Address2)
 VALUES('Ontario', 'Canada');
@@ -75,6 +221,11 @@ INSERT INTO TimeSeriesRecovered VALUES
 (2, "2020/01/31", 3),
 (2, "2021/10/31", 311);
 
+INSERT INTO TimeSeriesRevisions(ID, Type, Date, Value, RecordedAt)
+SELECT ID, 'Confirmed', Date, Confirmed, UTC_TIMESTAMP(6) FROM TimeSeriesConfirmed
+UNION ALL SELECT ID, 'Death', Date, Death, UTC_TIMESTAMP(6) FROM TimeSeriesDeath
+UNION ALL SELECT ID, 'Recovered', Date, Recovered, UTC_TIMESTAMP(6) FROM TimeSeriesRecovered;
+
 INSERT INTO DailyReports(Date, Admin2, Address1, Address2, Confirmed, Death, Recovered, Active) VALUES
 ("2020/06/05", 'Abbeville', 'South Carolina', 'US', 47,0,0,47),
 ("2020/01/31", 'Abbeville', 'South Carolina', 'US', 1,2,3,4);
@@ -0,0 +1,131 @@
+package epi
+
+import (
+	// Built-ins
+	"math"
+)
+
+// Serial interval of COVID-19 (days between the symptom onsets of an
+// infector and the person they infect): a gamma distribution of mean 4.7
+// and standard deviation 2.9 days (Nishiura et al., 2020), cut at 20 days
+const (
+	SerialIntervalMean = 4.7
+	SerialIntervalSD   = 2.9
+	serialIntervalMax  = 20
+)
+
+// Prior of Rt, a gamma distribution of shape 1 and scale 5 (mean 5), as
+// suggested by Cori et al. (2013): wide enough to let the data decide
+const (
+	priorShape = 1.0
+	priorScale = 5.0
+)
+
+// Estimates of Rt are only made from windows with at least this many new
+// cases; with fewer, the posterior coefficient of variation is above 0.3
+const MinCases = 12
+
+// 95% intervals, from a normal approximation of the posterior
+const z = 1.96
+
+// Estimate is an estimated value and its 95% credible interval. Value is
+// NaN where nothing could be estimated.
+type Estimate struct {
+	Value float64
+	Lower float64
+	Upper float64
+}
+
+// SerialInterval returns the probability that the serial interval is s
+// days, for s = 0..20 (0 for s = 0): the gamma density at each whole day,
+// normalized to sum to 1
+func SerialInterval() []float64 {
+	shape := math.Pow(SerialIntervalMean/SerialIntervalSD, 2)
+	scale := SerialIntervalSD * SerialIntervalSD / SerialIntervalMean
+
+	w := make([]float64, serialIntervalMax+1)
+	sum := 0.0
+	for s := 1; s <= serialIntervalMax; s++ {
+		x := float64(s)
+		w[s] = math.Pow(x, shape-1) * math.Exp(-x/scale)
+		sum += w[s]
+	}
+	for s := range w {
+		w[s] /= sum
+	}
+	return w
+}
+
+// Incidence returns the new cases of each day of a cumulative series. The
+// first day has none, as the cases before it are unknown; decreases (i.e.
+// corrections) count as none.
+func Incidence(cumulative []float64) []float64 {
+	incidence := make([]float64, len(cumulative))
+	for t := 1; t < len(cumulative); t++ {
+		incidence[t] = math.Max(0, cumulative[t]-cumulative[t-1])
+	}
+	return incidence
+}
+
+// Rt estimates the effective reproduction number of each day from daily
+// incidence, with the method of Cori et al. (2013): assuming Rt constant
+// over the window of days ending on t, its posterior is a gamma
+// distribution of shape priorShape + the cases of the window and rate
+// 1/priorScale + their infectiousness, the cases of the days before
+// weighted by the serial interval. Days without a full window before them,
+// or with fewer than MinCases cases in it, have no estimate.
+func Rt(incidence []float64, window int) []Estimate {
+	w := SerialInterval()
+
+	// Infectiousness of each day: how many cases it could get from the ones
+	// before it
+	lambda := make([]float64, len(incidence))
+	for t := range incidence {
+		for s := 1; s < len(w) && s <= t; s++ {
+			lambda[t] += w[s] * incidence[t-s]
+		}
+	}
+
+	estimates := make([]Estimate, len(incidence))
+	for t := range incidence {
+		estimates[t] = Estimate{Value: math.NaN(), Lower: math.NaN(), Upper: math.NaN()}
+		// The first day has no incidence, so windows start on the second
+		if t < window || window <= 0 {
+			continue
+		}
+		cases, infectiousness := 0.0, 0.0
+		for k := t - window + 1; k <= t; k++ {
+			cases += incidence[k]
+			infectiousness += lambda[k]
+		}
+		if cases < MinCases || infectiousness == 0 {
+			continue
+		}
+
+		shape := priorShape + cases
+		scale := 1 / (1/priorScale + infectiousness)
+		mean, sd := shape*scale, math.Sqrt(shape)*scale
+		estimates[t] = Estimate{Value: mean, Lower: math.Max(0, mean-z*sd), Upper: mean + z*sd}
+	}
+	return estimates
+}
+
+// DoublingTime returns, for each day, the days cumulative cases would take
+// to double at the growth rate of the window of days ending on it:
+// window * ln 2 / ln(C[t] / C[t-window]). Days without a full window
+// before them, without cases at its start or without growth are NaN.
+func DoublingTime(cumulative []float64, window int) []float64 {
+	doubling := make([]float64, len(cumulative))
+	for t := range cumulative {
+		doubling[t] = math.NaN()
+		if t < window || window <= 0 {
+			continue
+		}
+		start, end := cumulative[t-window], cumulative[t]
+		if start <= 0 || end <= start {
+			continue
+		}
+		doubling[t] = float64(window) * math.Ln2 / math.Log(end/start)
+	}
+	return doubling
+}
@@ -0,0 +1,115 @@
+package epi
+
+import (
+	"math"
+	"testing"
+)
+
+// Simulates daily incidence with the renewal equation the method assumes:
+// the cases of a day are r times the infectiousness of the days before
+func renewal(r float64, seed float64, days int) []float64 {
+	w := SerialInterval()
+	incidence := make([]float64, days)
+	incidence[1] = seed
+	for t := 2; t < days; t++ {
+		for s := 1; s < len(w) && s <= t; s++ {
+			incidence[t] += r * w[s] * incidence[t-s]
+		}
+	}
+	return incidence
+}
+
+func TestSerialInterval(t *testing.T) {
+	w := SerialInterval()
+	if len(w) != 21 || w[0] != 0 {
+		t.Fatalf("Test failed: expected 21 weights without day 0, got %v", w)
+	}
+	sum, mean := 0.0, 0.0
+	for s, p := range w {
+		sum += p
+		mean += float64(s) * p
+	}
+	if math.Abs(sum-1) > 1e-9 || math.Abs(mean-SerialIntervalMean) > 0.3 {
+		t.Fatalf("Test failed: expected weights summing to 1 with a mean near %v, got %v and %v",
+			SerialIntervalMean, sum, mean)
+	}
+}
+
+func TestIncidence(t *testing.T) {
+	incidence := Incidence([]float64{10, 15, 15, 12, 20})
+	expected := []float64{0, 5, 0, 0, 8}
+	for i := range expected {
+		if incidence[i] != expected[i] {
+			t.Fatalf("Test failed: expected %v, got %v", expected, incidence)
+		}
+	}
+}
+
+func TestRtGrowing(t *testing.T) {
+	incidence := renewal(1.5, 100, 60)
+	estimates := Rt(incidence, 7)
+	for day := 30; day < 60; day++ {
+		e := estimates[day]
+		if math.Abs(e.Value-1.5) > 0.01 || !(e.Lower < e.Value && e.Value < e.Upper) {
+			t.Fatalf("Test failed: expected about 1.5 on day %d, got %+v", day, e)
+		}
+	}
+}
+
+func TestRtDeclining(t *testing.T) {
+	incidence := renewal(2, 100, 30)
+	// Then 0.8 from day 30 on
+	w := SerialInterval()
+	incidence = append(incidence, make([]float64, 40)...)
+	for day := 30; day < 70; day++ {
+		for s := 1; s < len(w); s++ {
+			incidence[day] += 0.8 * w[s] * incidence[day-s]
+		}
+	}
+
+	estimates := Rt(incidence, 7)
+	if e := estimates[25]; math.Abs(e.Value-2) > 0.02 {
+		t.Fatalf("Test failed: expected about 2 before the change, got %+v", e)
+	}
+	if e := estimates[60]; math.Abs(e.Value-0.8) > 0.02 {
+		t.Fatalf("Test failed: expected about 0.8 after the change, got %+v", e)
+	}
+}
+
+func TestRtUndefined(t *testing.T) {
+	// Too early, then too few cases
+	incidence := []float64{0, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+	for day, e := range Rt(incidence, 7) {
+		if !math.IsNaN(e.Value) {
+			t.Fatalf("Test failed: expected no estimate on day %d, got %+v", day, e)
+		}
+	}
+}
+
+func TestDoublingTime(t *testing.T) {
+	// Doubling every 5 days
+	cumulative := []float64{}
+	for day := 0; day < 20; day++ {
+		cumulative = append(cumulative, 100*math.Pow(2, float64(day)/5))
+	}
+	doubling := DoublingTime(cumulative, 7)
+	for day := range doubling {
+		if day < 7 {
+			if !math.IsNaN(doubling[day]) {
+				t.Fatalf("Test failed: expected nothing before a full window, got %v", doubling[day])
+			}
+			continue
+		}
+		if math.Abs(doubling[day]-5) > 1e-9 {
+			t.Fatalf("Test failed: expected 5 on day %d, got %v", day, doubling[day])
+		}
+	}
+
+	// No growth, or no cases to start from
+	flat := DoublingTime([]float64{0, 0, 10, 10, 10}, 2)
+	for day, d := range flat {
+		if !math.IsNaN(d) {
+			t.Fatalf("Test failed: expected no doubling time on day %d, got %v", day, d)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package forecast
+
+import (
+	// Built-ins
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Models a series can be fitted with
+const (
+	// Least squares line through the values
+	Linear = "linear"
+	// Least squares line through the logarithm of the values (plus one, so
+	// zeros are allowed), i.e. constant growth
+	Exponential = "exp"
+	// Holt's linear exponential smoothing, which follows changes in trend
+	Holt = "holt"
+)
+
+// Models lists every model accepted by Fit
+var Models = []string{Linear, Exponential, Holt}
+
+// Confidence bands hold about 95% of outcomes, assuming normal errors
+const z = 1.96
+
+// Fewest values a model can be fitted to
+const MinPoints = 3
+
+// ErrTooFewPoints is returned for series shorter than MinPoints
+var ErrTooFewPoints = fmt.Errorf("at least %d values are needed", MinPoints)
+
+// Point is a predicted value and its confidence band
+type Point struct {
+	Value float64
+	Lower float64
+	Upper float64
+}
+
+// Fit fits model to values, evenly spaced and oldest first, and predicts
+// the next horizon values
+func Fit(model string, values []float64, horizon int) ([]Point, error) {
+	if len(values) < MinPoints {
+		return nil, ErrTooFewPoints
+	}
+	if horizon <= 0 {
+		return nil, errors.New("horizon must be positive")
+	}
+
+	switch model {
+	case Linear:
+		return linear(values, horizon), nil
+	case Exponential:
+		logs := make([]float64, len(values))
+		for i, v := range values {
+			if v < 0 {
+				return nil, errors.New("exp needs values of at least 0")
+			}
+			logs[i] = math.Log1p(v)
+		}
+		points := linear(logs, horizon)
+		for i, p := range points {
+			points[i] = Point{Value: math.Expm1(p.Value), Lower: math.Expm1(p.Lower), Upper: math.Expm1(p.Upper)}
+		}
+		return points, nil
+	case Holt:
+		return holt(values, horizon), nil
+	}
+	return nil, fmt.Errorf("unknown model %q", model)
+}
+
+// Helper functions
+
+// Least squares line over x = 0..n-1, with prediction intervals
+func linear(values []float64, horizon int) []Point {
+	n := float64(len(values))
+	meanX, meanY := (n-1)/2, 0.0
+	for _, v := range values {
+		meanY += v
+	}
+	meanY /= n
+
+	sxx, sxy := 0.0, 0.0
+	for i, v := range values {
+		dx := float64(i) - meanX
+		sxx += dx * dx
+		sxy += dx * (v - meanY)
+	}
+	slope := sxy / sxx
+	intercept := meanY - slope*meanX
+
+	// Standard error of the residuals, with n-2 degrees of freedom
+	sse := 0.0
+	for i, v := range values {
+		r := v - (intercept + slope*float64(i))
+		sse += r * r
+	}
+	s := math.Sqrt(sse / (n - 2))
+
+	points := make([]Point, horizon)
+	for h := range points {
+		x := n + float64(h)
+		y := intercept + slope*x
+		margin := z * s * math.Sqrt(1+1/n+(x-meanX)*(x-meanX)/sxx)
+		points[h] = Point{Value: y, Lower: y - margin, Upper: y + margin}
+	}
+	return points
+}
+
+// Holt's method with the smoothing parameters (in steps of 0.1) that best
+// predict each value from the ones before it
+func holt(values []float64, horizon int) []Point {
+	bestAlpha, bestBeta, bestSSE := 0.0, 0.0, math.Inf(1)
+	for a := 1; a <= 9; a++ {
+		for b := 1; b <= 9; b++ {
+			alpha, beta := float64(a)/10, float64(b)/10
+			_, _, sse := smooth(values, alpha, beta)
+			if sse < bestSSE {
+				bestAlpha, bestBeta, bestSSE = alpha, beta, sse
+			}
+		}
+	}
+
+	level, trend, sse := smooth(values, bestAlpha, bestBeta)
+	// Only the errors from the third value on tell anything
+	sigma := math.Sqrt(sse / float64(len(values)-2))
+
+	points := make([]Point, horizon)
+	variance := 0.0
+	for h := range points {
+		// Variance of the h+1 steps ahead error grows with every step
+		if h > 0 {
+			c := bestAlpha * (1 + float64(h)*bestBeta)
+			variance += c * c
+		}
+		y := level + float64(h+1)*trend
+		margin := z * sigma * math.Sqrt(1+variance)
+		points[h] = Point{Value: y, Lower: y - margin, Upper: y + margin}
+	}
+	return points
+}
+
+// Runs Holt's smoothing over values, starting from the first value and the
+// first difference (so the second value is always predicted exactly).
+// Returns the last level and trend, and the sum of squared one step ahead
+// errors.
+func smooth(values []float64, alpha float64, beta float64) (float64, float64, float64) {
+	level, trend := values[0], values[1]-values[0]
+	sse := 0.0
+	for _, v := range values[1:] {
+		predicted := level + trend
+		sse += (v - predicted) * (v - predicted)
+		previous := level
+		level = alpha*v + (1-alpha)*(level+trend)
+		trend = beta*(level-previous) + (1-beta)*trend
+	}
+	return level, trend, sse
+}
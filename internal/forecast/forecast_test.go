@@ -0,0 +1,87 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitLinear(t *testing.T) {
+	// 10, 12, 14, ... exactly on a line
+	values := []float64{}
+	for i := 0; i < 10; i++ {
+		values = append(values, 10+2*float64(i))
+	}
+	points, err := Fit(Linear, values, 3)
+	if err != nil || len(points) != 3 {
+		t.Fatalf("Test failed: expected 3 points, got %v %v", points, err)
+	}
+	for h, p := range points {
+		expected := 10 + 2*float64(10+h)
+		if math.Abs(p.Value-expected) > 1e-9 || math.Abs(p.Upper-p.Lower) > 1e-9 {
+			t.Fatalf("Test failed: expected exactly %v, got %+v", expected, p)
+		}
+	}
+}
+
+func TestFitLinearBandsWiden(t *testing.T) {
+	values := []float64{10, 13, 13, 17, 18, 19, 23, 24, 25, 29}
+	points, err := Fit(Linear, values, 5)
+	if err != nil {
+		t.Fatalf("Test failed: expected no error, got %v", err)
+	}
+	for h, p := range points {
+		if !(p.Lower < p.Value && p.Value < p.Upper) {
+			t.Fatalf("Test failed: expected the value within its band, got %+v", p)
+		}
+		if h > 0 && p.Upper-p.Lower <= points[h-1].Upper-points[h-1].Lower {
+			t.Fatalf("Test failed: expected bands to widen, got %+v", points)
+		}
+	}
+}
+
+func TestFitExponential(t *testing.T) {
+	// Doubling every step, from 1 (so log1p of values is not exactly linear)
+	values := []float64{}
+	for i := 0; i < 12; i++ {
+		values = append(values, math.Pow(2, float64(i+10))-1)
+	}
+	points, err := Fit(Exponential, values, 2)
+	if err != nil {
+		t.Fatalf("Test failed: expected no error, got %v", err)
+	}
+	expected := math.Pow(2, 22) - 1
+	if math.Abs(points[0].Value-expected)/expected > 1e-6 {
+		t.Fatalf("Test failed: expected %v, got %v", expected, points[0].Value)
+	}
+
+	if _, err := Fit(Exponential, []float64{1, -2, 3}, 1); err == nil {
+		t.Fatalf("Test failed: expected an error for negative values")
+	}
+}
+
+func TestFitHolt(t *testing.T) {
+	// Flat, then growing by 5 a step: Holt follows the new trend
+	values := []float64{100, 100, 100, 100, 100, 105, 110, 115, 120, 125, 130, 135, 140}
+	points, err := Fit(Holt, values, 2)
+	if err != nil {
+		t.Fatalf("Test failed: expected no error, got %v", err)
+	}
+	if math.Abs(points[0].Value-145) > 2 || math.Abs(points[1].Value-150) > 3 {
+		t.Fatalf("Test failed: expected about 145 and 150, got %+v", points)
+	}
+	if points[1].Upper-points[1].Lower <= points[0].Upper-points[0].Lower {
+		t.Fatalf("Test failed: expected bands to widen, got %+v", points)
+	}
+}
+
+func TestFitInvalid(t *testing.T) {
+	if _, err := Fit(Linear, []float64{1, 2}, 1); err != ErrTooFewPoints {
+		t.Fatalf("Test failed: expected ErrTooFewPoints, got %v", err)
+	}
+	if _, err := Fit(Linear, []float64{1, 2, 3}, 0); err == nil {
+		t.Fatalf("Test failed: expected an error for a horizon of 0")
+	}
+	if _, err := Fit("arima", []float64{1, 2, 3}, 1); err == nil {
+		t.Fatalf("Test failed: expected an error for an unknown model")
+	}
+}
@@ -0,0 +1,289 @@
+package idempotency
+
+import (
+	// Built-ins
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	// External imports
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-sql-driver/mysql"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/auth"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Header clients set to make retries of a write safe
+const Header = "Idempotency-Key"
+
+// Set on responses replayed from an earlier upload
+const ReplayedHeader = "Idempotent-Replayed"
+
+// Longest Idempotency-Key accepted; the column is VARCHAR(255)
+const maxKeyLength = 255
+
+// MySQL error number of a duplicate key
+const duplicateEntry = 1062
+
+// Uploads still unfinished after this long are assumed to have been cut
+// short, i.e. by a restart, and their claim is given up
+const claimTTL = 15 * time.Minute
+
+var (
+	errKeyTooLong = errors.New("Idempotency-Key is longer than 255 characters")
+	errKeyReused  = errors.New("Idempotency-Key was already used for a different upload")
+	errInProgress = errors.New("The same upload is still being processed")
+)
+
+// A response stored for replay
+type stored struct {
+	id          int64
+	contentHash string
+	status      sql.NullInt64
+	contentType string
+	location    string
+	body        []byte
+}
+
+// Middleware short-circuits repeated uploads to resource (i.e.
+// "time_series") with the response of the original one. An upload is a
+// repeat if either:
+//   - it has the Idempotency-Key of an earlier upload by the same API key;
+//     reusing a key for a different upload gets 422, and while the first is
+//     still running 409
+//   - it has the same content (body, FileType/Date headers and query) as the
+//     last successful upload to resource by the same API key, and no value of
+//     resource was changed since by any other write (see audit.Record), i.e. a
+//     client retrying blindly; 409 if that upload is still running
+//
+// Uploads that fail with a 5xx or panic are forgotten so they can be
+// retried, and so are those unfinished after claimTTL. Must run after
+// auth.Middleware.
+func Middleware(resource string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Dry runs write nothing, so there is nothing to repeat
+			if r.Method != http.MethodPost || r.URL.Query().Get("dry_run") == "true" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			idempotencyKey := r.Header.Get(Header)
+			if len(idempotencyKey) > maxKeyLength {
+				utils.HandleErrDetail(w, 400, errKeyTooLong)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				utils.HandleErr(w, 400, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			hash := fingerprint(r, body)
+
+			var keyID interface{}
+			if key, ok := auth.FromContext(r.Context()); ok {
+				keyID = key.ID
+			}
+
+			if err := expire(); err != nil {
+				utils.HandleErr(w, 500, err)
+				return
+			}
+			var prior *stored
+			if idempotencyKey != "" {
+				prior, err = findByKey(keyID, idempotencyKey)
+			} else {
+				prior, err = findByContent(resource, keyID, hash)
+			}
+			if err != nil {
+				utils.HandleErr(w, 500, err)
+				return
+			}
+			if prior != nil {
+				switch {
+				case prior.contentHash != hash:
+					utils.HandleErrDetail(w, 422, errKeyReused)
+				case !prior.status.Valid:
+					utils.HandleErrDetail(w, 409, errInProgress)
+				default:
+					replay(w, prior)
+				}
+				return
+			}
+
+			// Claim the upload; a concurrent one with the same key loses
+			id, err := begin(resource, keyID, idempotencyKey, hash, auth.UploadID(r.Context()))
+			if isDuplicate(err) {
+				utils.HandleErrDetail(w, 409, errInProgress)
+				return
+			}
+			if err != nil {
+				utils.HandleErr(w, 500, err)
+				return
+			}
+
+			// A panic must not leave the upload claimed
+			finished := false
+			defer func() {
+				if finished {
+					return
+				}
+				if err := release(id); err != nil {
+					log.Printf("idempotency: upload %d: %v", id, err)
+				}
+			}()
+
+			response := new(bytes.Buffer)
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(response)
+			next.ServeHTTP(ww, r)
+			finished = true
+
+			if err := complete(resource, id, ww, response.Bytes()); err != nil {
+				log.Printf("idempotency: upload %d: %v", id, err)
+			}
+		})
+	}
+}
+
+// Helper functions
+
+// Hash of everything that decides what an upload does
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{
+		r.Method,
+		r.URL.Path,
+		r.URL.Query().Encode(),
+		strings.ToLower(r.Header.Get("FileType")),
+		r.Header.Get("Date"),
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, prior *stored) {
+	if prior.contentType != "" {
+		w.Header().Set("Content-Type", prior.contentType)
+	}
+	if prior.location != "" {
+		w.Header().Set("Location", prior.location)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(int(prior.status.Int64))
+	if _, err := w.Write(prior.body); err != nil {
+		log.Printf("idempotency: replay of upload %d: %v", prior.id, err)
+	}
+}
+
+func findByKey(keyID interface{}, idempotencyKey string) (*stored, error) {
+	return find(`
+		SELECT ID, ContentHash, Status, ContentType, Location, Response
+		FROM ProcessedUploads
+		WHERE KeyID <=> ? AND IdempotencyKey = ?
+	`, keyID, idempotencyKey)
+}
+
+// Only the last upload to resource counts, and only if no other write
+// changed resource after it finished; replaying it would hide that its data
+// has been overwritten since. Every write, whether by an upload, projection,
+// reconciliation or import, is in AuditLog.
+func findByContent(resource string, keyID interface{}, hash string) (*stored, error) {
+	return find(`
+		SELECT ID, ContentHash, Status, ContentType, Location, Response
+		FROM ProcessedUploads p
+		WHERE ID = (SELECT MAX(ID) FROM ProcessedUploads WHERE Resource = ?)
+		AND KeyID <=> ? AND ContentHash = ?
+		AND (Status IS NULL OR (Status BETWEEN 200 AND 299 AND NOT EXISTS (
+			SELECT 1 FROM AuditLog a
+			WHERE a.Resource = p.Resource AND a.ID > COALESCE(p.AuditID, 0)
+			AND (a.UploadID IS NULL OR p.UploadID IS NULL OR a.UploadID <> p.UploadID)
+		)))
+	`, resource, keyID, hash)
+}
+
+func find(query string, args ...interface{}) (*stored, error) {
+	prior := &stored{}
+	var contentType, location sql.NullString
+	err := db.Db.QueryRow(query, args...).Scan(&prior.id, &prior.contentHash,
+		&prior.status, &contentType, &location, &prior.body)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prior.contentType, prior.location = contentType.String, location.String
+	return prior, nil
+}
+
+func begin(resource string, keyID interface{}, idempotencyKey string, hash string, uploadID int64) (int64, error) {
+	var key, upload interface{}
+	if idempotencyKey != "" {
+		key = idempotencyKey
+	}
+	if uploadID != 0 {
+		upload = uploadID
+	}
+	res, err := db.Db.Exec(`
+		INSERT INTO ProcessedUploads(Resource, KeyID, IdempotencyKey, ContentHash, UploadID)
+		VALUES(?,?,?,?,?)
+	`, resource, keyID, key, hash, upload)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// Stores the response of the upload, along with the last AuditLog row of
+// resource by then, which findByContent compares later writes against
+func complete(resource string, id int64, ww middleware.WrapResponseWriter, body []byte) error {
+	status := ww.Status()
+	if status == 0 {
+		status = 200
+	}
+	if status >= 500 {
+		return release(id)
+	}
+	_, err := db.Db.Exec(`
+		UPDATE ProcessedUploads SET Status = ?, ContentType = ?, Location = ?, Response = ?,
+		AuditID = (SELECT MAX(ID) FROM AuditLog WHERE Resource = ?)
+		WHERE ID = ?
+	`, status, ww.Header().Get("Content-Type"), ww.Header().Get("Location"), body, resource, id)
+	return err
+}
+
+// Forgets an upload so it can be retried
+func release(id int64) error {
+	_, err := db.Db.Exec("DELETE FROM ProcessedUploads WHERE ID = ?", id)
+	return err
+}
+
+// Gives up the claims of uploads unfinished after claimTTL
+func expire() error {
+	_, err := db.Db.Exec(`
+		DELETE FROM ProcessedUploads
+		WHERE Status IS NULL AND CreatedAt < CURRENT_TIMESTAMP - INTERVAL ? SECOND
+	`, int(claimTTL.Seconds()))
+	return err
+}
+
+func isDuplicate(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == duplicateEntry
+}
@@ -0,0 +1,106 @@
+package idempotency
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestFingerprint(t *testing.T) {
+	request := func(url string, fileType string) *http.Request {
+		r := httptest.NewRequest("POST", url, nil)
+		r.Header.Set("FileType", fileType)
+		return r
+	}
+	body := []byte("Province/State,Country/Region,1/22/20\nOntario,Canada,3\n")
+
+	base := fingerprint(request("/api/v1/time_series", "confirmed"), body)
+	if len(base) != 64 {
+		t.Fatalf("Test failed: expected a SHA-256 hex digest, got %s", base)
+	}
+	if same := fingerprint(request("/api/v1/time_series", "Confirmed"), body); same != base {
+		t.Fatalf("Test failed: FileType is case insensitive")
+	}
+
+	for name, other := range map[string]string{
+		"body":     fingerprint(request("/api/v1/time_series", "confirmed"), []byte("other")),
+		"FileType": fingerprint(request("/api/v1/time_series", "death"), body),
+		"query":    fingerprint(request("/api/v1/time_series?async=true", "confirmed"), body),
+		"path":     fingerprint(request("/api/v1/daily_reports", "confirmed"), body),
+	} {
+		if other == base {
+			t.Fatalf("Test failed: a different %s should change the fingerprint", name)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	w := httptest.NewRecorder()
+	replay(w, &stored{
+		status:      sql.NullInt64{Int64: 202, Valid: true},
+		contentType: "application/json",
+		location:    "/api/v1/jobs/3",
+		body:        []byte(`{"ID":3}`),
+	})
+
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 202 || string(body) != `{"ID":3}` {
+		t.Fatalf("Test failed: unexpected replay %d %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get(ReplayedHeader) != "true" || resp.Header.Get("Location") != "/api/v1/jobs/3" ||
+		resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Test failed: unexpected headers %v", resp.Header)
+	}
+}
+
+func TestMiddlewareSkipsReads(t *testing.T) {
+	called := false
+	handler := Middleware("time_series")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/time_series", nil))
+	if !called {
+		t.Fatalf("Test failed: reads should pass through")
+	}
+}
+
+func TestMiddlewareRejectsLongKeys(t *testing.T) {
+	handler := Middleware("time_series")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("Test failed: handler should not run")
+	}))
+	r := httptest.NewRequest("POST", "/api/v1/time_series", strings.NewReader("a,b\n"))
+	r.Header.Set(Header, strings.Repeat("k", maxKeyLength+1))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != 400 {
+		t.Fatalf("Test failed: expected 400, got %d", w.Code)
+	}
+}
+
+func TestIsDuplicate(t *testing.T) {
+	if !isDuplicate(&mysql.MySQLError{Number: duplicateEntry}) {
+		t.Fatalf("Test failed: expected a duplicate entry")
+	}
+	if isDuplicate(&mysql.MySQLError{Number: 1064}) || isDuplicate(errors.New("other")) || isDuplicate(nil) {
+		t.Fatalf("Test failed: expected no duplicate entry")
+	}
+}
+
+func TestMiddlewareSkipsDryRuns(t *testing.T) {
+	called := false
+	handler := Middleware("time_series")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	r := httptest.NewRequest("POST", "/api/v1/time_series?dry_run=true", strings.NewReader("a,b\n"))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if !called {
+		t.Fatalf("Test failed: dry runs should pass through")
+	}
+}
@@ -0,0 +1,236 @@
+package importer
+
+import (
+	// Built-ins
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/dailyReports"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/jobs"
+	"gitlab.com/csc301-assignments/a2/internal/timeSeries"
+)
+
+// Kinds of files, named after the resource they are uploaded to
+const (
+	TimeSeries   = "time_series"
+	DailyReports = "daily_reports"
+)
+
+// File is a CSV recognized as an upload. Header is what it would be POSTed
+// with: the FileType of a time series or the Date of a daily report.
+type File struct {
+	Path   string
+	Kind   string
+	Header string
+}
+
+const syncUsage = `usage:
+  sync [-dry-run] <dir>   ingest the new or changed files of a directory laid
+                          out like the JHU CSSE repository, i.e.
+                          csse_covid_19_daily_reports/01-22-2020.csv
+                          csse_covid_19_time_series/time_series_covid19_confirmed_global.csv`
+
+var (
+	// i.e. 01-22-2020.csv
+	dailyReportPattern = regexp.MustCompile(`^(\d{2})-(\d{2})-(\d{4})\.csv$`)
+	// i.e. time_series_covid19_deaths_global.csv or the county level
+	// time_series_covid19_deaths_US.csv
+	timeSeriesPattern = regexp.MustCompile(`^time_series_covid19_(confirmed|deaths|recovered)_(global|US)\.csv$`)
+)
+
+// Ingests a file the way its resource's async upload would
+var processors = map[string]jobs.Processor{
+	TimeSeries:   timeSeries.Process,
+	DailyReports: dailyReports.Process,
+}
+
+// Classify recognizes a file by its name and the directory it is in, as the
+// CSSE repository lays them out
+func Classify(path string) (File, bool) {
+	f, ok := ClassifyName(path)
+	if !ok {
+		return File{}, false
+	}
+	dir := map[string]string{
+		DailyReports: "csse_covid_19_daily_reports",
+		TimeSeries:   "csse_covid_19_time_series",
+	}[f.Kind]
+	if filepath.Base(filepath.Dir(path)) != dir {
+		return File{}, false
+	}
+	return f, true
+}
+
+// ClassifyName recognizes a file by its name alone, i.e. in a drop folder
+func ClassifyName(path string) (File, bool) {
+	name := filepath.Base(path)
+	if m := dailyReportPattern.FindStringSubmatch(name); m != nil {
+		return File{Path: path, Kind: DailyReports, Header: m[3] + "-" + m[1] + "-" + m[2]}, true
+	}
+	if m := timeSeriesPattern.FindStringSubmatch(name); m != nil {
+		fileType := m[1]
+		if fileType == "deaths" {
+			fileType = "death"
+		}
+		return File{Path: path, Kind: TimeSeries, Header: fileType}, true
+	}
+	return File{}, false
+}
+
+// Scan lists the files of dir that Classify recognizes: time series first,
+// then daily reports oldest first, so that reports derived from time series
+// (see projection) are overwritten by actual ones
+func Scan(dir string) ([]File, error) {
+	files := []File{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if f, ok := Classify(path); ok {
+			files = append(files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].Kind != files[j].Kind {
+			return files[i].Kind == TimeSeries
+		}
+		return files[i].Header < files[j].Header
+	})
+	return files, nil
+}
+
+// Ingest stores payload as a file of kind, with the header it would be
+// POSTed with. Returns a summary of the result; rows that could not be
+// stored make it an error, so the file is retried.
+func Ingest(ctx context.Context, kind string, header string, payload []byte) (string, error) {
+	process, ok := processors[kind]
+	if !ok {
+		return "", fmt.Errorf("unknown kind %q", kind)
+	}
+	tracker := jobs.NewTracker()
+	result, err := process(ctx, header, payload, tracker)
+	if err != nil {
+		return result, err
+	}
+	if _, _, errs, lastError := tracker.Progress(); errs > 0 {
+		return result, fmt.Errorf("%s; last error: %s", result, lastError)
+	}
+	return result, nil
+}
+
+// Command runs the "sync" admin subcommand, i.e. ./a2 sync ~/COVID-19
+func Command(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	dryRun := flags.Bool("dry-run", false, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errors.New(syncUsage)
+	}
+	return Sync(context.Background(), flags.Arg(0), *dryRun, out)
+}
+
+// Sync ingests every file of dir that is new or changed since it was last
+// synced. A file that fails is reported and retried on the next sync; the
+// others are still ingested.
+func Sync(ctx context.Context, dir string, dryRun bool, out io.Writer) error {
+	files, err := Scan(dir)
+	if err != nil {
+		return err
+	}
+
+	imported, unchanged, failed := 0, 0, 0
+	for _, f := range files {
+		rel, err := filepath.Rel(dir, f.Path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		payload, err := os.ReadFile(f.Path)
+		if err != nil {
+			return err
+		}
+		hash := hashOf(payload)
+		stored, err := storedHash(rel)
+		if err != nil {
+			return err
+		}
+		if stored == hash {
+			unchanged++
+			continue
+		}
+		if dryRun {
+			fmt.Fprintf(out, "would import %s (%s %s)\n", rel, f.Kind, f.Header)
+			imported++
+			continue
+		}
+
+		result, err := Ingest(ctx, f.Kind, f.Header, payload)
+		if err != nil {
+			fmt.Fprintf(out, "failed %s: %v\n", rel, err)
+			failed++
+			continue
+		}
+		if err := record(rel, f.Kind, hash, result); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "imported %s: %s\n", rel, result)
+		imported++
+	}
+
+	verb := "Imported"
+	if dryRun {
+		verb = "Would import"
+	}
+	fmt.Fprintf(out, "%s %d files, %d unchanged, %d failed\n", verb, imported, unchanged, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d files failed to import", failed)
+	}
+	return nil
+}
+
+// Helper functions
+
+func hashOf(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// Hash of path when it was last imported, or "" if it never was
+func storedHash(path string) (string, error) {
+	var hash string
+	err := db.Db.QueryRow("SELECT ContentHash FROM ImportedFiles WHERE Path = ?", path).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
+
+func record(path string, kind string, hash string, result string) error {
+	_, err := db.Db.Exec(`
+		INSERT INTO ImportedFiles(Path, Kind, ContentHash, Result) VALUES(?,?,?,?)
+		ON DUPLICATE KEY UPDATE Kind = VALUES(Kind), ContentHash = VALUES(ContentHash),
+		Result = VALUES(Result), ImportedAt = CURRENT_TIMESTAMP
+	`, path, kind, hash, result)
+	return err
+}
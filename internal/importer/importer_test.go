@@ -0,0 +1,84 @@
+package importer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		path   string
+		ok     bool
+		kind   string
+		header string
+	}{
+		{"data/csse_covid_19_daily_reports/01-22-2020.csv", true, DailyReports, "2020-01-22"},
+		{"data/csse_covid_19_time_series/time_series_covid19_confirmed_global.csv", true, TimeSeries, "confirmed"},
+		{"data/csse_covid_19_time_series/time_series_covid19_deaths_global.csv", true, TimeSeries, "death"},
+		{"data/csse_covid_19_time_series/time_series_covid19_recovered_global.csv", true, TimeSeries, "recovered"},
+		{"data/csse_covid_19_time_series/time_series_covid19_deaths_US.csv", true, TimeSeries, "death"},
+		{"data/csse_covid_19_daily_reports_us/01-22-2020.csv", false, "", ""},
+		{"data/csse_covid_19_daily_reports/README.md", false, "", ""},
+		{"data/csse_covid_19_time_series/01-22-2020.csv", false, "", ""},
+		{"data/csse_covid_19_daily_reports/time_series_covid19_confirmed_global.csv", false, "", ""},
+	}
+	for _, test := range tests {
+		f, ok := Classify(filepath.FromSlash(test.path))
+		if ok != test.ok || f.Kind != test.kind || f.Header != test.header {
+			t.Fatalf("Test failed: %s: expected %v %s %s, got %v %+v",
+				test.path, test.ok, test.kind, test.header, ok, f)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{
+		"csse_covid_19_data/csse_covid_19_daily_reports/02-01-2020.csv",
+		"csse_covid_19_data/csse_covid_19_daily_reports/01-22-2020.csv",
+		"csse_covid_19_data/csse_covid_19_daily_reports/README.md",
+		"csse_covid_19_data/csse_covid_19_time_series/time_series_covid19_deaths_global.csv",
+	} {
+		full := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Test failed: %v", err)
+		}
+		if err := os.WriteFile(full, []byte("a,b\n"), 0644); err != nil {
+			t.Fatalf("Test failed: %v", err)
+		}
+	}
+
+	files, err := Scan(dir)
+	if err != nil {
+		t.Fatalf("Test failed: %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Test failed: expected 3 files, got %+v", files)
+	}
+	if files[0].Kind != TimeSeries || files[1].Header != "2020-01-22" || files[2].Header != "2020-02-01" {
+		t.Fatalf("Test failed: expected time series then reports oldest first, got %+v", files)
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-force", "dir"},
+		{"a", "b"},
+	} {
+		if err := Command(args, new(bytes.Buffer)); err == nil || err.Error() != syncUsage {
+			t.Fatalf("Test failed: expected usage for %v, got %v", args, err)
+		}
+	}
+}
+
+func TestHashOf(t *testing.T) {
+	if hashOf([]byte("a")) == hashOf([]byte("b")) {
+		t.Fatalf("Test failed: expected different contents to hash differently")
+	}
+	if len(hashOf(nil)) != 64 {
+		t.Fatalf("Test failed: expected a hex SHA-256")
+	}
+}
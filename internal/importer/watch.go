@@ -0,0 +1,289 @@
+package importer
+
+import (
+	// Built-ins
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// External imports
+	"github.com/go-chi/chi"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Subfolders of the drop folder files are moved to once handled
+const (
+	processedDir = "processed"
+	failedDir    = "failed"
+)
+
+// How often the drop folder is polled unless WATCH_INTERVAL says otherwise
+const defaultInterval = time.Minute
+
+// Outcomes of a dropped file
+const (
+	StatusProcessed = "processed"
+	StatusFailed    = "failed"
+)
+
+// FileResult is what became of a dropped file. MovedTo is its path in
+// processed/ or failed/; the reason a file failed is also written next to
+// it, in a file of the same name ending in .error.
+type FileResult struct {
+	Name    string `json:"Name"`
+	Kind    string `json:"Kind,omitempty"`
+	Header  string `json:"Header,omitempty"`
+	Status  string `json:"Status"`
+	Result  string `json:"Result,omitempty"`
+	Error   string `json:"Error,omitempty"`
+	MovedTo string `json:"MovedTo"`
+}
+
+// WatchStatus describes the drop folder watcher and its last run. Processed
+// and Failed count files since the server started.
+type WatchStatus struct {
+	Enabled   bool         `json:"Enabled"`
+	Dir       string       `json:"Dir,omitempty"`
+	Interval  string       `json:"Interval,omitempty"`
+	LastRun   *time.Time   `json:"LastRun"`
+	LastError string       `json:"LastError,omitempty"`
+	Processed int          `json:"Processed"`
+	Failed    int          `json:"Failed"`
+	Files     []FileResult `json:"Files"`
+}
+
+// Polls a drop folder and ingests the CSVs dropped in it
+type watcher struct {
+	dir      string
+	interval time.Duration
+	ingest   func(ctx context.Context, kind string, header string, payload []byte) (string, error)
+	now      func() time.Time
+
+	// Size and modification time of files seen on the previous poll; a file
+	// is only picked up once it stops changing, so half-copied files wait
+	seen map[string]string
+
+	mu     sync.Mutex
+	status WatchStatus
+}
+
+// The running watcher, if any
+var (
+	mu      sync.Mutex
+	current *watcher
+)
+
+func Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/", Status)
+
+	return r
+}
+
+// Watch polls dir every interval in the background, routing dropped files
+// to time series or daily reports by name (see ClassifyName) and moving them
+// to processed/ or failed/
+func Watch(dir string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %v", interval)
+	}
+	for _, sub := range []string{processedDir, failedDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+
+	w := newWatcher(dir, interval)
+	mu.Lock()
+	current = w
+	mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			w.poll(context.Background())
+			<-ticker.C
+		}
+	}()
+	return nil
+}
+
+// WatchFromEnv watches WATCH_DIR every WATCH_INTERVAL (i.e. 30s, default
+// 1m). Does nothing if WATCH_DIR is not set.
+func WatchFromEnv() error {
+	dir := os.Getenv("WATCH_DIR")
+	if dir == "" {
+		return nil
+	}
+	interval := defaultInterval
+	if raw := os.Getenv("WATCH_INTERVAL"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("WATCH_INTERVAL: %v", err)
+		}
+		interval = d
+	}
+	return Watch(dir, interval)
+}
+
+// Status godoc
+// @Summary Status of the drop folder watcher
+// @Description when the drop folder was last polled and what became of the files it found
+// @Tags Watch
+// @Produce json
+// @Success 200 {object} WatchStatus
+// @Router /watch [get]
+func Status(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	watching := current
+	mu.Unlock()
+
+	status := WatchStatus{Files: []FileResult{}}
+	if watching != nil {
+		status = watching.snapshot()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+}
+
+// Helper functions
+
+func newWatcher(dir string, interval time.Duration) *watcher {
+	return &watcher{
+		dir:      dir,
+		interval: interval,
+		ingest:   Ingest,
+		now:      time.Now,
+		seen:     map[string]string{},
+		status: WatchStatus{
+			Enabled:  true,
+			Dir:      dir,
+			Interval: interval.String(),
+			Files:    []FileResult{},
+		},
+	}
+}
+
+func (w *watcher) snapshot() WatchStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := w.status
+	status.Files = append([]FileResult{}, w.status.Files...)
+	return status
+}
+
+// Handles every settled CSV of the drop folder, oldest name first
+func (w *watcher) poll(ctx context.Context) {
+	results := []FileResult{}
+	entries, err := os.ReadDir(w.dir)
+	if err == nil {
+		seen := map[string]string{}
+		names := []string{}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".csv") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			state := fmt.Sprintf("%d/%d", info.Size(), info.ModTime().UnixNano())
+			seen[entry.Name()] = state
+			if w.seen[entry.Name()] == state {
+				names = append(names, entry.Name())
+			}
+		}
+		w.seen = seen
+
+		// Time series first, as Scan orders them
+		sort.SliceStable(names, func(i, j int) bool {
+			a, _ := ClassifyName(names[i])
+			b, _ := ClassifyName(names[j])
+			if a.Kind != b.Kind {
+				return a.Kind == TimeSeries
+			}
+			return a.Header < b.Header
+		})
+		for _, name := range names {
+			result, err := w.handle(ctx, name)
+			if err != nil {
+				log.Printf("watch: %s: %v", name, err)
+			}
+			results = append(results, result)
+		}
+	}
+
+	now := w.now().UTC()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.LastRun = &now
+	w.status.LastError = ""
+	if err != nil {
+		w.status.LastError = err.Error()
+	}
+	w.status.Files = results
+	for _, r := range results {
+		if r.Status == StatusProcessed {
+			w.status.Processed++
+		} else {
+			w.status.Failed++
+		}
+	}
+}
+
+// Ingests a single file and moves it out of the way. The error is only set
+// if the file could not be moved.
+func (w *watcher) handle(ctx context.Context, name string) (FileResult, error) {
+	result := FileResult{Name: name, Status: StatusFailed}
+	path := filepath.Join(w.dir, name)
+
+	var failure error
+	f, ok := ClassifyName(name)
+	if !ok {
+		failure = errors.New("unrecognized file name; expected MM-DD-YYYY.csv or time_series_covid19_<confirmed|deaths|recovered>_<global|US>.csv")
+	} else {
+		result.Kind, result.Header = f.Kind, f.Header
+		payload, err := os.ReadFile(path)
+		if err == nil {
+			result.Result, err = w.ingest(ctx, f.Kind, f.Header, payload)
+		}
+		failure = err
+	}
+
+	dest := processedDir
+	if failure == nil {
+		result.Status = StatusProcessed
+	} else {
+		result.Error = failure.Error()
+		dest = failedDir
+	}
+
+	// Prefixed with the time so that files dropped again do not collide
+	moved := filepath.Join(w.dir, dest, w.now().UTC().Format("20060102T150405")+"-"+name)
+	result.MovedTo = filepath.ToSlash(filepath.Join(dest, filepath.Base(moved)))
+	if err := os.Rename(path, moved); err != nil {
+		return result, err
+	}
+	if failure != nil {
+		if err := os.WriteFile(moved+".error", []byte(failure.Error()+"\n"), 0644); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,115 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestWatcher(t *testing.T) *watcher {
+	dir := t.TempDir()
+	for _, sub := range []string{processedDir, failedDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("Test failed: %v", err)
+		}
+	}
+	w := newWatcher(dir, time.Minute)
+	w.now = func() time.Time { return time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC) }
+	w.ingest = func(ctx context.Context, kind string, header string, payload []byte) (string, error) {
+		if string(payload) == "bad" {
+			return "", errors.New("Bad Header Error")
+		}
+		return "created/updated 1 of 1 rows", nil
+	}
+	return w
+}
+
+func drop(t *testing.T, dir string, name string, content string) {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("Test failed: %v", err)
+	}
+}
+
+func TestPollWaitsForFilesToSettle(t *testing.T) {
+	w := newTestWatcher(t)
+	drop(t, w.dir, "01-22-2020.csv", "a,b\n")
+
+	w.poll(context.Background())
+	if status := w.snapshot(); len(status.Files) != 0 || status.LastRun == nil {
+		t.Fatalf("Test failed: expected a run that handles nothing yet, got %+v", status)
+	}
+
+	w.poll(context.Background())
+	status := w.snapshot()
+	if len(status.Files) != 1 || status.Files[0].Status != StatusProcessed || status.Processed != 1 {
+		t.Fatalf("Test failed: expected the file to be processed, got %+v", status)
+	}
+	if status.Files[0].MovedTo != "processed/20210301T120000-01-22-2020.csv" {
+		t.Fatalf("Test failed: unexpected destination %s", status.Files[0].MovedTo)
+	}
+	if _, err := os.Stat(filepath.Join(w.dir, filepath.FromSlash(status.Files[0].MovedTo))); err != nil {
+		t.Fatalf("Test failed: expected the file to be moved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(w.dir, "01-22-2020.csv")); !os.IsNotExist(err) {
+		t.Fatalf("Test failed: expected the file to leave the drop folder")
+	}
+}
+
+func TestPollFailures(t *testing.T) {
+	w := newTestWatcher(t)
+	drop(t, w.dir, "notes.csv", "a,b\n")
+	drop(t, w.dir, "time_series_covid19_deaths_global.csv", "bad")
+	drop(t, w.dir, "README.md", "not a csv")
+
+	w.poll(context.Background())
+	w.poll(context.Background())
+	status := w.snapshot()
+	if len(status.Files) != 2 || status.Failed != 2 || status.Processed != 0 {
+		t.Fatalf("Test failed: expected two failures, got %+v", status)
+	}
+
+	// Time series come first
+	first := status.Files[0]
+	if first.Kind != TimeSeries || first.Header != "death" || first.Error != "Bad Header Error" {
+		t.Fatalf("Test failed: unexpected result %+v", first)
+	}
+	reason, err := os.ReadFile(filepath.Join(w.dir, filepath.FromSlash(first.MovedTo)) + ".error")
+	if err != nil || string(reason) != "Bad Header Error\n" {
+		t.Fatalf("Test failed: expected the reason next to the file, got %q %v", reason, err)
+	}
+	if status.Files[1].Name != "notes.csv" || status.Files[1].Kind != "" {
+		t.Fatalf("Test failed: expected notes.csv to be unrecognized, got %+v", status.Files[1])
+	}
+	if _, err := os.Stat(filepath.Join(w.dir, "README.md")); err != nil {
+		t.Fatalf("Test failed: expected other files to be left alone")
+	}
+}
+
+func TestStatusDisabled(t *testing.T) {
+	mu.Lock()
+	current = nil
+	mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	Status(rec, httptest.NewRequest("GET", "http://example.com/foo", nil))
+	status := WatchStatus{}
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("Test failed: %v", err)
+	}
+	if status.Enabled || status.Files == nil {
+		t.Fatalf("Test failed: expected a disabled watcher with no files, got %+v", status)
+	}
+}
+
+func TestWatchFromEnvInvalid(t *testing.T) {
+	t.Setenv("WATCH_DIR", t.TempDir())
+	t.Setenv("WATCH_INTERVAL", "soon")
+	if err := WatchFromEnv(); err == nil {
+		t.Fatalf("Test failed: expected an invalid interval to be rejected")
+	}
+}
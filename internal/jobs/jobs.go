@@ -0,0 +1,368 @@
+package jobs
+
+import (
+	// Built-ins
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	// External imports
+	"github.com/go-chi/chi"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/auth"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Job statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Job is an upload processed in the background. Errors counts the rows that
+// could not be stored; LastError is the most recent of them, or what made the
+// whole job fail.
+type Job struct {
+	ID         int64      `json:"ID"`
+	Kind       string     `json:"Kind"`
+	Status     string     `json:"Status"`
+	Total      int        `json:"Total"`
+	Processed  int        `json:"Processed"`
+	Errors     int        `json:"Errors"`
+	LastError  string     `json:"LastError,omitempty"`
+	Result     string     `json:"Result,omitempty"`
+	KeyID      *int64     `json:"KeyID"`
+	UploadID   *int64     `json:"UploadID"`
+	CreatedAt  time.Time  `json:"CreatedAt"`
+	StartedAt  *time.Time `json:"StartedAt"`
+	FinishedAt *time.Time `json:"FinishedAt"`
+}
+
+// Processor stores the payload of a job of one kind. header is the header the
+// upload came with (i.e. FileType); ctx carries the key and upload that
+// submitted the job. Returns a summary of the result.
+type Processor func(ctx context.Context, header string, payload []byte, tracker *Tracker) (string, error)
+
+// Number of workers unless JOB_WORKERS says otherwise
+const defaultWorkers = 2
+
+// How often progress is written while a job runs
+const flushInterval = time.Second
+
+var (
+	mu         sync.Mutex
+	processors = map[string]Processor{}
+	queue      chan int64
+)
+
+func Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/{id}", Get)
+
+	return r
+}
+
+// Register makes jobs of kind (i.e. "time_series") run with p
+func Register(kind string, p Processor) {
+	mu.Lock()
+	defer mu.Unlock()
+	processors[kind] = p
+}
+
+// Start launches the worker pool. Jobs left queued or running by a previous
+// run of the server are picked up again from the start; ingestion overwrites
+// existing values, so running a job twice is harmless.
+func Start(workers int) error {
+	if workers <= 0 {
+		return fmt.Errorf("need at least one worker, got %d", workers)
+	}
+
+	_, err := db.Db.Exec("UPDATE Jobs SET Status = ? WHERE Status = ?", StatusQueued, StatusRunning)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Db.Query("SELECT ID FROM Jobs WHERE Status = ? ORDER BY ID", StatusQueued)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	pending := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		pending = append(pending, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	queue = make(chan int64, 1024)
+	mu.Unlock()
+	for i := 0; i < workers; i++ {
+		go work(queue)
+	}
+	for _, id := range pending {
+		enqueue(id)
+	}
+	return nil
+}
+
+// StartFromEnv starts as many workers as JOB_WORKERS, default 2
+func StartFromEnv() error {
+	workers := defaultWorkers
+	if raw := os.Getenv("JOB_WORKERS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("JOB_WORKERS: %v", err)
+		}
+		workers = n
+	}
+	return Start(workers)
+}
+
+// Submit stores an upload as a job and responds 202 with the job and its
+// location. The upload should already be validated; only storing it is left
+// to the worker.
+func Submit(w http.ResponseWriter, r *http.Request, kind string, header string, payload []byte) {
+	var keyID, uploadID interface{}
+	if key, ok := auth.FromContext(r.Context()); ok {
+		keyID = key.ID
+	}
+	if id := auth.UploadID(r.Context()); id != 0 {
+		uploadID = id
+	}
+
+	res, err := db.Db.Exec(`
+		INSERT INTO Jobs(Kind, Header, Payload, Status, KeyID, UploadID)
+		VALUES(?,?,?,?,?,?)
+	`, kind, header, payload, StatusQueued, keyID, uploadID)
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+	job, err := load(id)
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+	enqueue(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/jobs/%d", id))
+	w.WriteHeader(202)
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		log.Printf("job %d: %v", id, err)
+	}
+}
+
+// Get godoc
+// @Summary Status of an upload job
+// @Description progress and result of an upload processed in the background
+// @Tags Jobs
+// @Produce json
+// @Param id path int true ID of the job
+// @Success 200 {object} Job
+// @Failure 404 {string} string "Error status 404"
+// @Router /jobs/{id} [get]
+func Get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.HandleErr(w, 400, errors.New("Invalid input"))
+		return
+	}
+
+	job, err := load(id)
+	if err == sql.ErrNoRows {
+		utils.HandleErr(w, 404, errors.New("Not found"))
+		return
+	}
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+
+	// Jobs of other keys do not exist as far as the caller knows
+	key, _ := auth.FromContext(r.Context())
+	if !visible(job, key) {
+		utils.HandleErr(w, 404, errors.New("Not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+}
+
+// Helper functions
+
+// Admins see every job, other keys only their own
+func visible(job Job, key auth.Key) bool {
+	if key.Role == auth.RoleAdmin {
+		return true
+	}
+	return job.KeyID != nil && *job.KeyID == key.ID
+}
+
+// Hands a job to the workers without blocking the caller. Jobs submitted
+// before Start are picked up by Start itself.
+func enqueue(id int64) {
+	mu.Lock()
+	q := queue
+	mu.Unlock()
+	if q == nil {
+		return
+	}
+	select {
+	case q <- id:
+	default:
+		go func() { q <- id }()
+	}
+}
+
+func work(q chan int64) {
+	for id := range q {
+		if err := run(id); err != nil {
+			log.Printf("job %d: %v", id, err)
+		}
+	}
+}
+
+// Runs a single job and records its outcome
+func run(id int64) error {
+	var (
+		kind, header    string
+		payload         []byte
+		keyID, uploadID sql.NullInt64
+		status          string
+	)
+	err := db.Db.QueryRow(`
+		SELECT Kind, Header, Payload, KeyID, UploadID, Status FROM Jobs WHERE ID = ?
+	`, id).Scan(&kind, &header, &payload, &keyID, &uploadID, &status)
+	if err != nil {
+		return err
+	}
+	if status != StatusQueued {
+		return nil
+	}
+
+	mu.Lock()
+	process, ok := processors[kind]
+	mu.Unlock()
+	if !ok {
+		return finish(id, StatusFailed, "", fmt.Sprintf("no processor for %q", kind))
+	}
+
+	// Claim the job; another worker may have been handed it too
+	res, err := db.Db.Exec(`
+		UPDATE Jobs SET Status = ?, StartedAt = ?, Total = 0, Processed = 0, Errors = 0
+		WHERE ID = ? AND Status = ?
+	`, StatusRunning, time.Now().UTC(), id, StatusQueued)
+	if err != nil {
+		return err
+	}
+	if claimed, err := res.RowsAffected(); err != nil || claimed == 0 {
+		return err
+	}
+
+	// Same key (with its current role and scopes) and upload as the request,
+	// i.e. for scope checks and the audit log. Keys revoked since cannot
+	// finish their jobs.
+	ctx := context.Background()
+	if keyID.Valid {
+		key, err := auth.KeyByID(keyID.Int64)
+		if err != nil {
+			return finish(id, StatusFailed, "", err.Error())
+		}
+		ctx = auth.WithKey(ctx, key, uploadID.Int64)
+	}
+	tracker := newTracker(func(t *Tracker) error { return save(id, t) })
+	result, err := process(ctx, header, payload, tracker)
+	if flushErr := tracker.Flush(); flushErr != nil {
+		return flushErr
+	}
+	if err != nil {
+		return finish(id, StatusFailed, result, err.Error())
+	}
+	return finish(id, StatusSucceeded, result, "")
+}
+
+func save(id int64, t *Tracker) error {
+	total, processed, errs, lastError := t.Progress()
+	_, err := db.Db.Exec(`
+		UPDATE Jobs SET Total = ?, Processed = ?, Errors = ?, LastError = ? WHERE ID = ?
+	`, total, processed, errs, nullable(lastError), id)
+	return err
+}
+
+func finish(id int64, status string, result string, lastError string) error {
+	query := "UPDATE Jobs SET Status = ?, Result = ?, FinishedAt = ? WHERE ID = ?"
+	args := []interface{}{status, nullable(result), time.Now().UTC(), id}
+	if lastError != "" {
+		query = "UPDATE Jobs SET Status = ?, Result = ?, FinishedAt = ?, LastError = ? WHERE ID = ?"
+		args = []interface{}{status, nullable(result), time.Now().UTC(), lastError, id}
+	}
+	_, err := db.Db.Exec(query, args...)
+	return err
+}
+
+func load(id int64) (Job, error) {
+	job := Job{}
+	var (
+		lastError, result     sql.NullString
+		keyID, uploadID       sql.NullInt64
+		startedAt, finishedAt sql.NullTime
+	)
+	err := db.Db.QueryRow(`
+		SELECT ID, Kind, Status, Total, Processed, Errors, LastError, Result,
+		KeyID, UploadID, CreatedAt, StartedAt, FinishedAt
+		FROM Jobs WHERE ID = ?
+	`, id).Scan(&job.ID, &job.Kind, &job.Status, &job.Total, &job.Processed,
+		&job.Errors, &lastError, &result, &keyID, &uploadID,
+		&job.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return job, err
+	}
+	job.LastError, job.Result = lastError.String, result.String
+	if keyID.Valid {
+		job.KeyID = &keyID.Int64
+	}
+	if uploadID.Valid {
+		job.UploadID = &uploadID.Int64
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, nil
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package jobs
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"gitlab.com/csc301-assignments/a2/internal/auth"
+)
+
+func TestTrackerCounts(t *testing.T) {
+	tracker := newTracker(nil)
+	tracker.SetTotal(3)
+	tracker.Row(nil)
+	tracker.Row(errors.New("bad row"))
+	tracker.Row(nil)
+
+	total, processed, errs, lastError := tracker.Progress()
+	if total != 3 || processed != 3 || errs != 1 || lastError != "bad row" {
+		t.Fatalf("Test failed: unexpected progress %d %d %d %q", total, processed, errs, lastError)
+	}
+}
+
+func TestTrackerFlushesPeriodically(t *testing.T) {
+	now := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	saved := 0
+	tracker := newTracker(func(*Tracker) error {
+		saved++
+		return nil
+	})
+	tracker.now = func() time.Time { return now }
+
+	// First row is always saved, the next ones only after flushInterval
+	tracker.Row(nil)
+	tracker.Row(nil)
+	if saved != 1 {
+		t.Fatalf("Test failed: expected 1 save, got %d", saved)
+	}
+	now = now.Add(flushInterval)
+	tracker.Row(nil)
+	if saved != 2 {
+		t.Fatalf("Test failed: expected 2 saves, got %d", saved)
+	}
+
+	if err := tracker.Flush(); err != nil || saved != 3 {
+		t.Fatalf("Test failed: expected Flush to save, got %d %v", saved, err)
+	}
+}
+
+func TestVisible(t *testing.T) {
+	owner := int64(1)
+	job := Job{KeyID: &owner}
+
+	if !visible(job, auth.Key{ID: 1, Role: auth.RoleUploader}) {
+		t.Fatalf("Test failed: owner should see its job")
+	}
+	if visible(job, auth.Key{ID: 2, Role: auth.RoleUploader}) {
+		t.Fatalf("Test failed: other keys should not see the job")
+	}
+	if !visible(job, auth.Key{ID: 2, Role: auth.RoleAdmin}) {
+		t.Fatalf("Test failed: admins should see every job")
+	}
+	if visible(Job{}, auth.Key{ID: 0, Role: auth.RoleReader}) {
+		t.Fatalf("Test failed: jobs without a key are for admins only")
+	}
+}
+
+func TestStartInvalidWorkers(t *testing.T) {
+	if err := Start(0); err == nil {
+		t.Fatalf("Test failed: expected an error for 0 workers")
+	}
+
+	os.Setenv("JOB_WORKERS", "many")
+	defer os.Unsetenv("JOB_WORKERS")
+	if err := StartFromEnv(); err == nil {
+		t.Fatalf("Test failed: expected an error for JOB_WORKERS=many")
+	}
+}
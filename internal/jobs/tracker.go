@@ -0,0 +1,72 @@
+package jobs
+
+import (
+	// Built-ins
+	"sync"
+	"time"
+)
+
+// Tracker counts the rows a Processor has handled. Progress is saved at
+// most once per flushInterval, and once more when the job ends.
+type Tracker struct {
+	mu        sync.Mutex
+	total     int
+	processed int
+	errors    int
+	lastError string
+	lastFlush time.Time
+	now       func() time.Time
+	save      func(*Tracker) error
+}
+
+func newTracker(save func(*Tracker) error) *Tracker {
+	return &Tracker{now: time.Now, save: save}
+}
+
+// NewTracker returns a Tracker that is never saved, to run a Processor
+// outside of a job, i.e. from the command line
+func NewTracker() *Tracker {
+	return newTracker(nil)
+}
+
+// SetTotal records how many rows the job has, once known
+func (t *Tracker) SetTotal(n int) {
+	t.mu.Lock()
+	t.total = n
+	t.mu.Unlock()
+}
+
+// Row records that one more row was handled; err is why it was not stored
+func (t *Tracker) Row(err error) {
+	t.mu.Lock()
+	t.processed++
+	if err != nil {
+		t.errors++
+		t.lastError = err.Error()
+	}
+	due := t.now().Sub(t.lastFlush) >= flushInterval
+	t.mu.Unlock()
+
+	if due {
+		// A failed save only delays progress; the final Flush reports it
+		_ = t.Flush()
+	}
+}
+
+// Progress returns the counts so far
+func (t *Tracker) Progress() (total int, processed int, errors int, lastError string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.total, t.processed, t.errors, t.lastError
+}
+
+// Flush saves the progress now
+func (t *Tracker) Flush() error {
+	t.mu.Lock()
+	t.lastFlush = t.now()
+	t.mu.Unlock()
+	if t.save == nil {
+		return nil
+	}
+	return t.save(t)
+}
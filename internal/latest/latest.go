@@ -0,0 +1,275 @@
+package latest
+
+import (
+	// Built-ins
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// External imports
+	"github.com/go-chi/chi"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/dailyReports"
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Point is the last known value of a TimeSeries metric
+type Point struct {
+	ID    string    `json:"ID"`
+	AsOf  time.Time `json:"AsOf"`
+	Value int       `json:"Value"`
+}
+
+// Snapshot holds the most recent numbers of a single location
+type Snapshot struct {
+	Admin2   string `json:"Admin2"`
+	Address1 string `json:"Province/State"`
+	Address2 string `json:"Country/Region"`
+
+	// Newest DailyReports row; its Date is the as-of date
+	DailyReport *dailyReports.DailyReports `json:"DailyReport,omitempty"`
+	// Last point of each TimeSeries metric, keyed by Confirmed/Death/Recovered
+	TimeSeries map[string]Point `json:"TimeSeries,omitempty"`
+}
+
+var metrics = []string{"Confirmed", "Death", "Recovered"}
+
+func Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/", List)
+
+	return r
+}
+
+func List(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	snapshots := map[string]*Snapshot{}
+
+	// Newest DailyReports row per location
+	where, args, status := makeFilter(params, "d.")
+	if status != 0 {
+		utils.HandleErr(w, 400, errors.New("Invalid input"))
+		return
+	}
+	query := fmt.Sprintf(`
+		SELECT d.ID, d.Date, d.Admin2, d.Address1, d.Address2,
+		d.Confirmed, d.Death, d.Recovered, d.Active
+		FROM DailyReports d JOIN (
+			SELECT Admin2, Address1, Address2, MAX(Date) AS Date
+			FROM DailyReports GROUP BY Admin2, Address1, Address2
+		) m ON d.Admin2 <=> m.Admin2 AND d.Address1 <=> m.Address1
+		AND d.Address2 = m.Address2 AND d.Date = m.Date
+		%s
+	`, where)
+	if err := readDailyReports(snapshots, query, args); err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+
+	// Last point of each TimeSeries metric per location
+	where, args, _ = makeFilter(params, "ts.")
+	for _, metric := range metrics {
+		query := fmt.Sprintf(`
+			SELECT ts.ID, ts.Admin2, ts.Address1, ts.Address2, t.Date, t.%s
+			FROM TimeSeries ts JOIN TimeSeries%s t ON ts.ID = t.ID
+			JOIN (
+				SELECT ID, MAX(Date) AS Date FROM TimeSeries%s GROUP BY ID
+			) m ON t.ID = m.ID AND t.Date = m.Date
+			%s
+		`, metric, metric, metric, where)
+		if err := readTimeSeries(snapshots, metric, query, args); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	}
+
+	result := sortSnapshots(snapshots)
+
+	// Check 'Accept' type
+	if r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		b := new(bytes.Buffer)
+		writer := csv.NewWriter(b)
+		if err := writer.WriteAll(writeCSV(result)); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+}
+
+func readDailyReports(snapshots map[string]*Snapshot, query string, args []interface{}) error {
+	rows, err := db.Db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		dr := dailyReports.DailyReports{}
+		var (
+			admin2, address1           sql.NullString
+			confirmed, death, rec, act sql.NullInt64
+		)
+		err := rows.Scan(&dr.ID, &dr.Date, &admin2, &address1, &dr.Address2,
+			&confirmed, &death, &rec, &act)
+		if err != nil {
+			return err
+		}
+		dr.Admin2, dr.Address1 = admin2.String, address1.String
+		dr.Confirmed, dr.Death = int(confirmed.Int64), int(death.Int64)
+		dr.Recovered, dr.Active = int(rec.Int64), int(act.Int64)
+
+		s := snapshotOf(snapshots, dr.Admin2, dr.Address1, dr.Address2)
+		s.DailyReport = &dr
+	}
+	return rows.Err()
+}
+
+func readTimeSeries(snapshots map[string]*Snapshot, metric string, query string, args []interface{}) error {
+	rows, err := db.Db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			p                = Point{}
+			admin2, address1 sql.NullString
+			address2         string
+		)
+		if err := rows.Scan(&p.ID, &admin2, &address1, &address2, &p.AsOf, &p.Value); err != nil {
+			return err
+		}
+
+		s := snapshotOf(snapshots, admin2.String, address1.String, address2)
+		if s.TimeSeries == nil {
+			s.TimeSeries = map[string]Point{}
+		}
+		s.TimeSeries[metric] = p
+	}
+	return rows.Err()
+}
+
+// Helper functions
+
+// Builds the WHERE clause filtering locations. Only location parameters are
+// accepted; status is 400 otherwise. prefix is the alias of the table holding
+// Admin2, Address1 and Address2 (i.e. "d.").
+func makeFilter(params map[string][]string, prefix string) (string, []interface{}, int) {
+	conds := []string{}
+	args := []interface{}{}
+
+	// Sorted to make the query deterministic
+	keys := []string{}
+	for param := range params {
+		keys = append(keys, param)
+	}
+	sort.Strings(keys)
+
+	for _, param := range keys {
+		column, valid := utils.ParamValidate(param)
+		if !valid || (column != "admin2" && column != "address1" && column != "address2") {
+			return "", nil, 400
+		}
+
+		values := strings.Split(params[param][0], ",")
+		alternatives := []string{}
+		for _, v := range values {
+			alternatives = append(alternatives, prefix+column+"=?")
+			args = append(args, v)
+		}
+		conds = append(conds, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	if len(conds) == 0 {
+		return "", args, 0
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args, 0
+}
+
+func snapshotOf(snapshots map[string]*Snapshot, admin2 string, address1 string, address2 string) *Snapshot {
+	key := strings.Join([]string{admin2, address1, address2}, "|")
+	s, ok := snapshots[key]
+	if !ok {
+		s = &Snapshot{Admin2: admin2, Address1: address1, Address2: address2}
+		snapshots[key] = s
+	}
+	return s
+}
+
+// Orders snapshots by country, then province, then admin2
+func sortSnapshots(snapshots map[string]*Snapshot) []Snapshot {
+	result := []Snapshot{}
+	for _, s := range snapshots {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Address2 != b.Address2 {
+			return a.Address2 < b.Address2
+		}
+		if a.Address1 != b.Address1 {
+			return a.Address1 < b.Address1
+		}
+		return a.Admin2 < b.Admin2
+	})
+	return result
+}
+
+// One row per location and metric
+func writeCSV(snapshots []Snapshot) [][]string {
+	csvArr := [][]string{
+		{"Admin2", "Province/State", "Country/Region", "Source", "Metric", "AsOf", "Value"},
+	}
+	for _, s := range snapshots {
+		address := []string{s.Admin2, s.Address1, s.Address2}
+		if dr := s.DailyReport; dr != nil {
+			values := map[string]int{
+				"Confirmed": dr.Confirmed,
+				"Death":     dr.Death,
+				"Recovered": dr.Recovered,
+				"Active":    dr.Active,
+			}
+			for _, metric := range []string{"Confirmed", "Death", "Recovered", "Active"} {
+				row := append(append([]string{}, address...),
+					"daily_reports", metric, dates.Format(dr.Date), strconv.Itoa(values[metric]))
+				csvArr = append(csvArr, row)
+			}
+		}
+		for _, metric := range metrics {
+			p, ok := s.TimeSeries[metric]
+			if !ok {
+				continue
+			}
+			row := append(append([]string{}, address...),
+				"time_series", metric, dates.Format(p.AsOf), strconv.Itoa(p.Value))
+			csvArr = append(csvArr, row)
+		}
+	}
+	return csvArr
+}
@@ -0,0 +1,85 @@
+package latest
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/csc301-assignments/a2/internal/dailyReports"
+)
+
+func TestMakeFilterNoParams(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	where, args, status := makeFilter(r.URL.Query(), "d.")
+	if where != "" || len(args) != 0 || status != 0 {
+		t.Fatalf("Test failed: expected empty filter, got %s %v %d", where, args, status)
+	}
+}
+
+func TestMakeFilterLocationParams(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo?country=Canada,US&province=Ontario", nil)
+	where, args, status := makeFilter(r.URL.Query(), "ts.")
+	if status != 0 {
+		t.Fatalf("Test failed: expected status 0, got %d", status)
+	}
+
+	expected := "WHERE (ts.address2=? OR ts.address2=?) AND (ts.address1=?)"
+	if where != expected {
+		t.Fatalf("Test failed: expected %s, got %s", expected, where)
+	}
+	if len(args) != 3 || args[0] != "Canada" || args[1] != "US" || args[2] != "Ontario" {
+		t.Fatalf("Test failed: unexpected args %v", args)
+	}
+}
+
+func TestMakeFilterInvalidParams(t *testing.T) {
+	for _, url := range []string{
+		"http://example.com/foo?abc=def",
+		"http://example.com/foo?date=1/1/20", // dates make no sense for a snapshot
+		"http://example.com/foo?death",
+	} {
+		r := httptest.NewRequest("GET", url, nil)
+		if _, _, status := makeFilter(r.URL.Query(), "d."); status != 400 {
+			t.Fatalf("Test failed: expected 400 for %s, got %d", url, status)
+		}
+	}
+}
+
+func TestSnapshotsMergeAndSort(t *testing.T) {
+	snapshots := map[string]*Snapshot{}
+	asOf := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	snapshotOf(snapshots, "", "Ontario", "Canada").DailyReport = &dailyReports.DailyReports{
+		Date: asOf, Confirmed: 5, Death: 1, Recovered: 2, Active: 2,
+	}
+	s := snapshotOf(snapshots, "", "Ontario", "Canada")
+	s.TimeSeries = map[string]Point{"Confirmed": {ID: "2", AsOf: asOf, Value: 7}}
+	snapshotOf(snapshots, "Autauga", "Alabama", "US")
+
+	if len(snapshots) != 2 {
+		t.Fatalf("Test failed: expected 2 locations, got %d", len(snapshots))
+	}
+
+	result := sortSnapshots(snapshots)
+	if result[0].Address2 != "Canada" || result[1].Address2 != "US" {
+		t.Fatalf("Test failed: unexpected order %v", result)
+	}
+	if result[0].DailyReport == nil || result[0].TimeSeries["Confirmed"].Value != 7 {
+		t.Fatalf("Test failed: snapshot not merged: %v", result[0])
+	}
+
+	lines := writeCSV(result)
+	expected := "Admin2,Province/State,Country/Region,Source,Metric,AsOf,Value"
+	if strings.Join(lines[0], ",") != expected {
+		t.Fatalf("Test failed: expected %s, got %v", expected, lines[0])
+	}
+	// 4 daily report metrics + 1 time series metric for Ontario
+	if len(lines) != 6 {
+		t.Fatalf("Test failed: expected 6 lines, got %d", len(lines))
+	}
+	expected = ",Ontario,Canada,time_series,Confirmed,2021-03-01,7"
+	if strings.Join(lines[5], ",") != expected {
+		t.Fatalf("Test failed: expected %s, got %v", expected, lines[5])
+	}
+}
@@ -0,0 +1,746 @@
+package openapi
+
+import (
+	// Built-ins
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/audit"
+	"gitlab.com/csc301-assignments/a2/internal/dailyReports"
+	"gitlab.com/csc301-assignments/a2/internal/forecast"
+	"gitlab.com/csc301-assignments/a2/internal/importer"
+	"gitlab.com/csc301-assignments/a2/internal/jobs"
+	"gitlab.com/csc301-assignments/a2/internal/latest"
+	"gitlab.com/csc301-assignments/a2/internal/preview"
+	"gitlab.com/csc301-assignments/a2/internal/quality"
+	"gitlab.com/csc301-assignments/a2/internal/rankings"
+	"gitlab.com/csc301-assignments/a2/internal/reconcile"
+	"gitlab.com/csc301-assignments/a2/internal/timeSeries"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// The subset of OpenAPI 3 this API needs
+
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Servers    []Server            `json:"servers"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+// PathItem maps lowercase HTTP methods (i.e. "get") to their operation
+type PathItem map[string]Operation
+
+type Operation struct {
+	Summary     string                `json:"summary"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+
+	// Go type of a component, to tell apart types of the same name
+	goType reflect.Type
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`
+	In          string `json:"in,omitempty"`
+	Scheme      string `json:"scheme,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Descriptions of every query parameter accepted by utils.ParamValidate
+var paramDescriptions = map[string]string{
+	"id":        "ID of the object",
+	"admin2":    "County (or equivalent) name",
+	"province":  "Province or state name; same as state",
+	"state":     "Province or state name; same as province",
+	"country":   "Country or region name; same as region",
+	"region":    "Country or region name; same as country",
+	"date":      "A date (yyyy-mm-dd, m/d/yy or m/d/yyyy) or an expression such as latest, -30d, today, 2020-W12 or 2021-03",
+	"from":      "Earliest date to include; same formats as date",
+	"to":        "Latest date to include; same formats as date",
+	"range":     "A whole ISO week (i.e. 2020-W12) or any date expression",
+	"month":     "A whole calendar month (i.e. 2021-03)",
+	"death":     "Return deaths instead of confirmed cases; mutually exclusive with recovered",
+	"recovered": "Return recoveries instead of confirmed cases; mutually exclusive with death",
+	"as_of":     "Return values as they were known at this moment (RFC 3339, i.e. 2021-03-01T00:00:00Z, or a date meaning midnight UTC); time series only",
+}
+
+// Spec describes every route registered by main
+func Spec() Document {
+	schemas := map[string]*Schema{}
+	tsSchema := schemaOf(reflect.TypeOf(timeSeries.TimeSeries{}), schemas)
+	drSchema := schemaOf(reflect.TypeOf(dailyReports.DailyReports{}), schemas)
+	snapshotSchema := schemaOf(reflect.TypeOf(latest.Snapshot{}), schemas)
+	auditSchema := schemaOf(reflect.TypeOf(audit.Entry{}), schemas)
+	revisionSchema := schemaOf(reflect.TypeOf(timeSeries.Revision{}), schemas)
+	comparisonSchema := schemaOf(reflect.TypeOf(timeSeries.Comparison{}), schemas)
+	forecastSchema := schemaOf(reflect.TypeOf(timeSeries.Forecast{}), schemas)
+	estimatesSchema := schemaOf(reflect.TypeOf(timeSeries.Estimates{}), schemas)
+	jobSchema := schemaOf(reflect.TypeOf(jobs.Job{}), schemas)
+	planSchema := schemaOf(reflect.TypeOf(preview.Plan{}), schemas)
+	diffSchema := schemaOf(reflect.TypeOf(preview.Diff{}), schemas)
+	anomalySchema := schemaOf(reflect.TypeOf(quality.Anomaly{}), schemas)
+	discrepancySchema := schemaOf(reflect.TypeOf(reconcile.Discrepancy{}), schemas)
+	resultSchema := schemaOf(reflect.TypeOf(reconcile.Result{}), schemas)
+	watchSchema := schemaOf(reflect.TypeOf(importer.WatchStatus{}), schemas)
+	rankingSchema := schemaOf(reflect.TypeOf(rankings.Ranked{}), schemas)
+
+	return Document{
+		OpenAPI: "3.0.3",
+		Info: Info{
+			Title:       "CSC301 Assignment2 Group69",
+			Description: "An API for COVID-19 time series and daily reports",
+			Version:     "1.0",
+		},
+		Servers: []Server{{URL: "/"}},
+		Paths: map[string]PathItem{
+			"/": {
+				"get": {
+					Summary:   "Greeting, doubles as a health check",
+					Responses: map[string]Response{"200": textResponse("Greeting")},
+				},
+			},
+			"/api/v1/openapi.json": {
+				"get": {
+					Summary: "This document",
+					Responses: map[string]Response{
+						"200": {
+							Description: "OpenAPI 3 document",
+							Content:     map[string]MediaType{"application/json": {Schema: &Schema{Type: "object"}}},
+						},
+					},
+				},
+			},
+			"/api/v1/time_series": {
+				"get": {
+					Summary:    "List TimeSeries",
+					Tags:       []string{"TimeSeries"},
+					Parameters: append(queryParams(utils.ParamNames()...), levelParam(), per100kParam(), acceptHeader()),
+					Responses:  listResponses(tsSchema),
+					Security:   keyOptional(),
+				},
+				"post": {
+					Summary: "Create/Update TimeSeries",
+					Tags:    []string{"TimeSeries"},
+					Parameters: []Parameter{{
+						Name:        "FileType",
+						In:          "header",
+						Description: "Metric held by the file (case insensitive)",
+						Required:    true,
+						Schema:      &Schema{Type: "string", Enum: []string{"confirmed", "death", "recovered"}},
+					}, asyncParam(), dryRunParam(), idempotencyHeader()},
+					RequestBody: csvBody("Time series with one column per date"),
+					Responses:   checked(dryRun(accepted(createResponses(), jobSchema), planSchema), anomalySchema),
+					Security:    keyRequired(),
+				},
+			},
+			"/api/v1/time_series/diff": {
+				"post": {
+					Summary: "Preview how a TimeSeries file differs from stored data, flagging unusually large revisions; nothing is written",
+					Tags:    []string{"TimeSeries"},
+					Parameters: []Parameter{
+						{Name: "FileType", In: "header", Description: "Metric held by the file (case insensitive)", Required: true,
+							Schema: &Schema{Type: "string", Enum: []string{"confirmed", "death", "recovered"}}},
+						{Name: "ratio", In: "query", Description: "Flag revisions of at least this fraction of the stored value; default to 0.1",
+							Schema: &Schema{Type: "number"}},
+						{Name: "min_delta", In: "query", Description: "Flag revisions of at least this many cases; default to 10",
+							Schema: &Schema{Type: "integer"}},
+						acceptHeader(),
+					},
+					RequestBody: csvBody("Time series with one column per date"),
+					Responses:   objectResponses(diffSchema),
+					Security:    keyOptional(),
+				},
+			},
+			"/api/v1/time_series/compare": {
+				"get": {
+					Summary: "Compare TimeSeries aligned by days since each reached a threshold",
+					Tags:    []string{"TimeSeries"},
+					Parameters: append([]Parameter{
+						{Name: "threshold", In: "query", Description: "Day 0 of a series is the first date its value was at least this; default to 100",
+							Schema: &Schema{Type: "integer"}},
+						levelParam(),
+					}, append(queryParams(utils.ParamNames()...), acceptHeader())...),
+					Responses: objectResponses(comparisonSchema),
+					Security:  keyOptional(),
+				},
+			},
+			"/api/v1/time_series/{id}/forecast": {
+				"get": {
+					Summary: "Predict the next values of a TimeSeries, with a 95% confidence band",
+					Tags:    []string{"TimeSeries"},
+					Parameters: []Parameter{
+						{Name: "id", In: "path", Description: "ID of the TimeSeries", Required: true,
+							Schema: &Schema{Type: "integer"}},
+						{Name: "metric", In: "query", Description: "Metric to forecast; default to confirmed",
+							Schema: &Schema{Type: "string", Enum: []string{"confirmed", "death", "recovered"}}},
+						{Name: "model", In: "query", Description: "linear, exp (constant growth) or holt (Holt's linear exponential smoothing); default to linear",
+							Schema: &Schema{Type: "string", Enum: forecast.Models}},
+						{Name: "series", In: "query", Description: "Forecast cumulative values or new cases per day; default to cumulative",
+							Schema: &Schema{Type: "string", Enum: []string{"cumulative", "daily"}}},
+						{Name: "horizon", In: "query", Description: "Number of days to predict; default to 14, at most 365",
+							Schema: &Schema{Type: "integer"}},
+						{Name: "window", In: "query", Description: "Number of last values the model is fitted to; default to 28",
+							Schema: &Schema{Type: "integer"}},
+						acceptHeader(),
+					},
+					Responses: unfittable(notFound(objectResponses(forecastSchema))),
+					Security:  keyOptional(),
+				},
+			},
+			"/api/v1/time_series/{id}/estimates": {
+				"get": {
+					Summary: "Estimate the reproduction number (with a 95% credible interval) and doubling time of each day from confirmed cases",
+					Tags:    []string{"TimeSeries"},
+					Parameters: []Parameter{
+						{Name: "id", In: "path", Description: "ID of the TimeSeries", Required: true,
+							Schema: &Schema{Type: "integer"}},
+						{Name: "window", In: "query", Description: "Days Rt is assumed constant over; default to 7, at most 90",
+							Schema: &Schema{Type: "integer"}},
+						{Name: "doubling_window", In: "query", Description: "Days the growth rate is measured over; default to 7, at most 90",
+							Schema: &Schema{Type: "integer"}},
+						acceptHeader(),
+					},
+					Responses: notFound(objectResponses(estimatesSchema)),
+					Security:  keyOptional(),
+				},
+			},
+			"/api/v1/time_series/{id}/revisions": {
+				"get": {
+					Summary: "Every version of each value of a TimeSeries, oldest first",
+					Tags:    []string{"TimeSeries"},
+					Parameters: append([]Parameter{
+						{Name: "id", In: "path", Description: "ID of the TimeSeries", Required: true,
+							Schema: &Schema{Type: "integer"}},
+						{Name: "metric", In: "query", Description: "Only revisions of these metrics",
+							Schema: &Schema{Type: "string", Enum: []string{"confirmed", "death", "recovered"}}},
+					}, append(queryParams("date", "from", "to", "range", "month"), acceptHeader())...),
+					Responses: notFound(listResponses(revisionSchema)),
+					Security:  keyOptional(),
+				},
+			},
+			"/api/v1/daily_reports": {
+				"get": {
+					Summary:    "List DailyReports",
+					Tags:       []string{"DailyReports"},
+					Parameters: append(queryParams(without(utils.ParamNames(), "as_of")...), acceptHeader()),
+					Responses:  listResponses(drSchema),
+					Security:   keyOptional(),
+				},
+				"post": {
+					Summary: "Create/Update DailyReports",
+					Tags:    []string{"DailyReports"},
+					Parameters: []Parameter{{
+						Name:        "Date",
+						In:          "header",
+						Description: "Date of the report (yyyy-mm-dd, m/d/yy or m/d/yyyy)",
+						Required:    true,
+						Schema:      &Schema{Type: "string"},
+					}, asyncParam(), dryRunParam(), idempotencyHeader()},
+					RequestBody: csvBody("Daily report with one row per location"),
+					Responses:   dryRun(accepted(createResponses(), jobSchema), planSchema),
+					Security:    keyRequired(),
+				},
+			},
+			"/api/v1/latest": {
+				"get": {
+					Summary: "Most recent numbers of every location",
+					Tags:    []string{"Latest"},
+					Parameters: append(queryParams(
+						"admin2", "province", "state", "country", "region"), acceptHeader()),
+					Responses: listResponses(snapshotSchema),
+					Security:  keyOptional(),
+				},
+			},
+			"/api/v1/rankings": {
+				"get": {
+					Summary: "Top locations by latest value, change over a window or per capita",
+					Tags:    []string{"Rankings"},
+					Parameters: append([]Parameter{
+						{Name: "source", In: "query", Description: "Resource to rank from; default to time_series",
+							Schema: &Schema{Type: "string", Enum: []string{"time_series", "daily_reports"}}},
+						{Name: "metric", In: "query", Description: "Metric to rank by; default to confirmed",
+							Schema: &Schema{Type: "string", Enum: []string{"confirmed", "death", "recovered"}}},
+						{Name: "window", In: "query", Description: "Rank by the change over this many days (i.e. 7d) or weeks (i.e. 2w) instead of the latest value",
+							Schema: &Schema{Type: "string"}},
+						levelParam(),
+						{Name: "per_100k", In: "query", Description: "Rank per 100,000 inhabitants; locations without a known population are left out",
+							Schema: &Schema{Type: "boolean"}},
+						{Name: "limit", In: "query", Description: "Number of locations; default to 10",
+							Schema: &Schema{Type: "integer"}},
+					}, append(queryParams("admin2", "province", "state", "country", "region", "date"), acceptHeader())...),
+					Responses: objectResponses(rankingSchema),
+					Security:  keyOptional(),
+				},
+			},
+			"/api/v1/audit": {
+				"get": {
+					Summary: "Every value changed by an upload, newest first; needs a reader key",
+					Tags:    []string{"Audit"},
+					Parameters: append(queryParams(
+						"admin2", "province", "state", "country", "region",
+						"date", "from", "to", "range", "month"),
+						Parameter{Name: "metric", In: "query", Description: "Only changes to these metrics",
+							Schema: &Schema{Type: "string", Enum: []string{"confirmed", "death", "recovered", "active"}}},
+						Parameter{Name: "resource", In: "query", Description: "Only changes made through this resource",
+							Schema: &Schema{Type: "string", Enum: []string{"time_series", "daily_reports"}}},
+						Parameter{Name: "upload", In: "query", Description: "Only changes made by this upload",
+							Schema: &Schema{Type: "integer"}},
+						Parameter{Name: "key", In: "query", Description: "Only changes made with this API key",
+							Schema: &Schema{Type: "integer"}},
+						Parameter{Name: "limit", In: "query", Description: "Maximum number of entries; default to 1000",
+							Schema: &Schema{Type: "integer"}},
+						acceptHeader()),
+					Responses: keyResponses(listResponses(auditSchema)),
+					Security:  keyRequired(),
+				},
+			},
+			"/api/v1/quality": {
+				"get": {
+					Summary: "Anomalies the data quality rules find in stored time series, per location and date; needs a reader key",
+					Tags:    []string{"Quality"},
+					Parameters: append(queryParams(
+						"admin2", "province", "state", "country", "region",
+						"date", "from", "to", "range", "month"),
+						Parameter{Name: "metric", In: "query", Description: "Only anomalies in these metrics",
+							Schema: &Schema{Type: "string", Enum: []string{"confirmed", "death", "recovered"}}},
+						Parameter{Name: "rule", In: "query", Description: "Only anomalies found by these rules",
+							Schema: &Schema{Type: "string", Enum: quality.Rules}},
+						Parameter{Name: "limit", In: "query", Description: "Maximum number of anomalies; default to 1000",
+							Schema: &Schema{Type: "integer"}},
+						acceptHeader()),
+					Responses: keyResponses(listResponses(anomalySchema)),
+					Security:  keyRequired(),
+				},
+			},
+			"/api/v1/reconcile": {
+				"get": {
+					Summary:    "Values that DailyReports and TimeSeries disagree on, per location, date and metric; needs a reader key",
+					Tags:       []string{"Reconcile"},
+					Parameters: append(reconcileParams(), acceptHeader()),
+					Responses:  keyResponses(listResponses(discrepancySchema)),
+					Security:   keyRequired(),
+				},
+				"post": {
+					Summary: "Overwrite the disagreeing values of one resource with those of the authoritative one; needs write access to the overwritten resource",
+					Tags:    []string{"Reconcile"},
+					Parameters: append([]Parameter{{
+						Name: "authoritative", In: "query", Description: "Resource whose values win", Required: true,
+						Schema: &Schema{Type: "string", Enum: []string{reconcile.TimeSeries, reconcile.DailyReports}},
+					}, asyncParam()}, reconcileParams()...),
+					Responses: accepted(keyResponses(objectResponses(resultSchema)), jobSchema),
+					Security:  keyRequired(),
+				},
+			},
+			"/api/v1/watch": {
+				"get": {
+					Summary: "Status of the drop folder watcher and what became of the files of its last run; needs a reader key",
+					Tags:    []string{"Watch"},
+					Responses: keyResponses(map[string]Response{
+						"200": {
+							Description: "OK",
+							Content:     map[string]MediaType{"application/json": {Schema: watchSchema}},
+						},
+						"429": rateLimitResponse(),
+						"500": textResponse("Error status 500"),
+					}),
+					Security: keyRequired(),
+				},
+			},
+			"/api/v1/jobs/{id}": {
+				"get": {
+					Summary: "Progress and result of an upload sent with async=true; only admins see jobs of other keys",
+					Tags:    []string{"Jobs"},
+					Parameters: []Parameter{{
+						Name: "id", In: "path", Description: "ID of the job", Required: true,
+						Schema: &Schema{Type: "integer"},
+					}},
+					Responses: keyResponses(notFound(map[string]Response{
+						"200": {
+							Description: "OK",
+							Content:     map[string]MediaType{"application/json": {Schema: jobSchema}},
+						},
+						"400": textResponse("Error status 400"),
+						"429": rateLimitResponse(),
+						"500": textResponse("Error status 500"),
+					})),
+					Security: keyRequired(),
+				},
+			},
+		},
+		Components: Components{
+			Schemas: schemas,
+			SecuritySchemes: map[string]SecurityScheme{
+				"ApiKey": {
+					Type: "apiKey", Name: "X-API-Key", In: "header",
+					Description: "Required for writes; required for reads when the server sets AUTH_READS",
+				},
+				"Bearer": {
+					Type: "http", Scheme: "bearer",
+					Description: "Same API key, sent as Authorization: Bearer <key>",
+				},
+			},
+		},
+	}
+}
+
+// Serve writes the document as JSON
+func Serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(Spec()); err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+}
+
+// Helper functions
+
+func queryParams(names ...string) []Parameter {
+	result := []Parameter{}
+	for _, name := range names {
+		schema := &Schema{Type: "string"}
+		if name == "death" || name == "recovered" {
+			schema = &Schema{Type: "boolean"}
+		}
+		if name == "as_of" {
+			schema = &Schema{Type: "string", Format: "date-time"}
+		}
+		result = append(result, Parameter{
+			Name:        name,
+			In:          "query",
+			Description: paramDescriptions[name],
+			Schema:      schema,
+		})
+	}
+	return result
+}
+
+func without(names []string, drop string) []string {
+	result := []string{}
+	for _, name := range names {
+		if name != drop {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func asyncParam() Parameter {
+	return Parameter{
+		Name:        "async",
+		In:          "query",
+		Description: "Store the file in the background and respond 202 with a job to poll at /api/v1/jobs/{id}",
+		Schema:      &Schema{Type: "boolean"},
+	}
+}
+
+func levelParam() Parameter {
+	return Parameter{
+		Name:        "level",
+		In:          "query",
+		Description: "Sum the series of each province or country per date; a location reporting at that level is taken as the total of its children",
+		Schema:      &Schema{Type: "string", Enum: []string{"province", "country"}},
+	}
+}
+
+func per100kParam() Parameter {
+	return Parameter{
+		Name:        "per_100k",
+		In:          "query",
+		Description: "Also return values per 100,000 inhabitants (Per100k), for locations uploaded with a Population",
+		Schema:      &Schema{Type: "boolean"},
+	}
+}
+
+func dryRunParam() Parameter {
+	return Parameter{
+		Name:        "dry_run",
+		In:          "query",
+		Description: "Write nothing; respond with the values the upload would insert, update or reject. Dry runs are reads, so they need no API key",
+		Schema:      &Schema{Type: "boolean"},
+	}
+}
+
+func idempotencyHeader() Parameter {
+	return Parameter{
+		Name:        "Idempotency-Key",
+		In:          "header",
+		Description: "Any unique string, at most 255 characters; retries with the same key get the original response",
+		Schema:      &Schema{Type: "string"},
+	}
+}
+
+func acceptHeader() Parameter {
+	return Parameter{
+		Name:        "Accept",
+		In:          "header",
+		Description: "Default to application/json",
+		Schema:      &Schema{Type: "string", Enum: []string{"application/json", "text/csv"}},
+	}
+}
+
+func csvBody(description string) *RequestBody {
+	return &RequestBody{
+		Description: description,
+		Required:    true,
+		Content:     map[string]MediaType{"text/csv": {Schema: &Schema{Type: "string"}}},
+	}
+}
+
+func textResponse(description string) Response {
+	return Response{
+		Description: description,
+		Content:     map[string]MediaType{"text/plain": {Schema: &Schema{Type: "string"}}},
+	}
+}
+
+// Writes need an API key
+func keyRequired() []map[string][]string {
+	return []map[string][]string{{"ApiKey": {}}, {"Bearer": {}}}
+}
+
+// Reads are public unless the server sets AUTH_READS
+func keyOptional() []map[string][]string {
+	return append([]map[string][]string{{}}, keyRequired()...)
+}
+
+func errorResponses() map[string]Response {
+	return map[string]Response{
+		"400": textResponse("Error status 400"),
+		"429": rateLimitResponse(),
+		"500": textResponse("Error status 500"),
+	}
+}
+
+func rateLimitResponse() Response {
+	response := textResponse("Error status 429; retry after the number of seconds in Retry-After")
+	response.Headers = map[string]Header{
+		"Retry-After":           {Description: "Seconds until a request is allowed again", Schema: &Schema{Type: "integer"}},
+		"X-RateLimit-Limit":     {Description: "Requests allowed at once", Schema: &Schema{Type: "integer"}},
+		"X-RateLimit-Remaining": {Description: "Requests left right now", Schema: &Schema{Type: "integer"}},
+		"X-RateLimit-Reset":     {Description: "Seconds until the limit is fully restored", Schema: &Schema{Type: "integer"}},
+	}
+	return response
+}
+
+func listResponses(item *Schema) map[string]Response {
+	responses := errorResponses()
+	responses["200"] = Response{
+		Description: "OK",
+		Content: map[string]MediaType{
+			"application/json": {Schema: &Schema{Type: "array", Items: item}},
+			"text/csv":         {Schema: &Schema{Type: "string"}},
+		},
+	}
+	return responses
+}
+
+// Like listResponses, for a single JSON object
+func objectResponses(schema *Schema) map[string]Response {
+	responses := listResponses(nil)
+	responses["200"].Content["application/json"] = MediaType{Schema: schema}
+	return responses
+}
+
+func createResponses() map[string]Response {
+	responses := errorResponses()
+	responses["200"] = textResponse("Created/updated data to the system; a repeated upload gets the original response with Idempotent-Replayed: true")
+	responses["409"] = textResponse("Error status 409: the same upload is still being processed")
+	responses["422"] = textResponse("Error status 422: the Idempotency-Key was already used for a different upload")
+	return keyResponses(responses)
+}
+
+// Adds the response of uploads sent with async=true
+func accepted(responses map[string]Response, job *Schema) map[string]Response {
+	response := Response{
+		Description: "Accepted; the job is at the URL in Location",
+		Content:     map[string]MediaType{"application/json": {Schema: job}},
+	}
+	response.Headers = map[string]Header{
+		"Location": {Description: "URL of the job", Schema: &Schema{Type: "string"}},
+	}
+	responses["202"] = response
+	return responses
+}
+
+// Adds the plan uploads with dry_run=true respond with
+func dryRun(responses map[string]Response, plan *Schema) map[string]Response {
+	response := responses["200"]
+	response.Content["application/json"] = MediaType{Schema: plan}
+	response.Content["text/csv"] = MediaType{Schema: &Schema{Type: "string"}}
+	responses["200"] = response
+	return responses
+}
+
+// Filters of /api/v1/reconcile
+func reconcileParams() []Parameter {
+	return append(queryParams(
+		"admin2", "province", "state", "country", "region",
+		"date", "from", "to", "range", "month"),
+		Parameter{Name: "metric", In: "query", Description: "Only discrepancies in these metrics",
+			Schema: &Schema{Type: "string", Enum: []string{"confirmed", "death", "recovered"}}},
+		Parameter{Name: "limit", In: "query", Description: "Maximum number of discrepancies; default to 1000",
+			Schema: &Schema{Type: "integer"}})
+}
+
+// Adds what the data quality rules make an upload respond with
+func checked(responses map[string]Response, anomaly *Schema) map[string]Response {
+	response := responses["200"]
+	response.Headers = map[string]Header{
+		"X-Quality-Warnings": {Description: "Number of anomalies the upload was stored despite", Schema: &Schema{Type: "integer"}},
+	}
+	responses["200"] = response
+	responses["422"] = Response{
+		Description: "The Idempotency-Key was already used for a different upload (text), or a data quality rule rejected the upload (JSON)",
+		Content: map[string]MediaType{
+			"text/plain": {Schema: &Schema{Type: "string"}},
+			"application/json": {Schema: &Schema{
+				Type: "object",
+				Properties: map[string]*Schema{
+					"Error":     {Type: "string"},
+					"Anomalies": {Type: "array", Items: anomaly},
+				},
+			}},
+		},
+	}
+	return responses
+}
+
+// Adds the response of routes addressing a single object
+func notFound(responses map[string]Response) map[string]Response {
+	responses["404"] = textResponse("Error status 404")
+	return responses
+}
+
+// Adds the response of forecasts whose model cannot be fitted
+func unfittable(responses map[string]Response) map[string]Response {
+	responses["422"] = textResponse("Error status 422: followed by why the model cannot be fitted, i.e. too few values or a missing day")
+	return responses
+}
+
+// Adds the responses of routes that need an API key
+func keyResponses(responses map[string]Response) map[string]Response {
+	responses["401"] = textResponse("Error status 401; missing or invalid API key")
+	responses["403"] = textResponse("Error status 403: followed by the role or scope the API key lacks")
+	return responses
+}
+
+// Derives a schema from a Go type through its json tags. Named structs are
+// added to components and referenced, so the documented schema never drifts
+// from what encoding/json actually writes.
+func schemaOf(t reflect.Type, components map[string]*Schema) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), components)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), components)}
+	case reflect.Struct:
+		ref := &Schema{Ref: "#/components/schemas/" + t.Name()}
+		if existing, ok := components[t.Name()]; ok {
+			// Components are named after their type alone
+			if existing.goType != t {
+				panic(fmt.Sprintf("openapi: %s and %s share the component name %s",
+					existing.goType, t, t.Name()))
+			}
+			return ref
+		}
+		schema := &Schema{Type: "object", Properties: map[string]*Schema{}, goType: t}
+		components[t.Name()] = schema // registered first in case of recursion
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" { // unexported
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			schema.Properties[name] = schemaOf(field.Type, components)
+		}
+		return ref
+	}
+	return &Schema{}
+}
@@ -0,0 +1,122 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+func TestParamsDocumented(t *testing.T) {
+	for _, name := range utils.ParamNames() {
+		if paramDescriptions[name] == "" {
+			t.Fatalf("Test failed: param %s has no description", name)
+		}
+	}
+}
+
+func TestSchemasFollowJSONTags(t *testing.T) {
+	schemas := Spec().Components.Schemas
+
+	ts, ok := schemas["TimeSeries"]
+	if !ok {
+		t.Fatalf("Test failed: TimeSeries schema missing")
+	}
+	for _, name := range []string{"ID", "Admin2", "Province/State", "Country/Region", "Confirmed", "Death", "Recovered"} {
+		if _, ok := ts.Properties[name]; !ok {
+			t.Fatalf("Test failed: TimeSeries schema missing %s", name)
+		}
+	}
+	if ts.Properties["Confirmed"].AdditionalProperties.Type != "integer" {
+		t.Fatalf("Test failed: Confirmed should map dates to integers")
+	}
+
+	dr, ok := schemas["DailyReports"]
+	if !ok {
+		t.Fatalf("Test failed: DailyReports schema missing")
+	}
+	if dr.Properties["Date"].Format != "date-time" {
+		t.Fatalf("Test failed: Date should be a date-time")
+	}
+	if _, ok := dr.Properties["id"]; !ok {
+		t.Fatalf("Test failed: DailyReports schema missing id")
+	}
+
+	// Nested structs are referenced
+	snapshot := schemas["Snapshot"]
+	if snapshot.Properties["DailyReport"].Ref != "#/components/schemas/DailyReports" {
+		t.Fatalf("Test failed: expected a reference, got %v", snapshot.Properties["DailyReport"])
+	}
+}
+
+func TestServe(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/api/v1/openapi.json", nil)
+	w := httptest.NewRecorder()
+	Serve(w, r)
+
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Test failed: expected code 200, got %d", resp.StatusCode)
+	}
+	expected := "application/json"
+	if result := resp.Header.Get("Content-Type"); result != expected {
+		t.Fatalf("Test failed: expected %s, got %s", expected, result)
+	}
+
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("Error during converting JSON: %v", err)
+	}
+	if doc["openapi"] != "3.0.3" {
+		t.Fatalf("Test failed: expected openapi 3.0.3, got %v", doc["openapi"])
+	}
+}
+
+func TestRankingsSchema(t *testing.T) {
+	spec := Spec()
+	response := spec.Paths["/api/v1/rankings"]["get"].Responses["200"]
+	ref := response.Content["application/json"].Schema.Ref
+	schema := spec.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")]
+	if schema == nil {
+		t.Fatalf("Test failed: rankings response references unknown schema %q", ref)
+	}
+	for _, name := range []string{"Rankings", "Since"} {
+		if _, ok := schema.Properties[name]; !ok {
+			t.Fatalf("Test failed: rankings schema %q lacks %s", ref, name)
+		}
+	}
+}
+
+// Two distinct types named Result, like reconcile.Result and a type of
+// another package would be
+func firstResult() reflect.Type {
+	type Result struct{ A int }
+	return reflect.TypeOf(Result{})
+}
+
+func secondResult() reflect.Type {
+	type Result struct{ B int }
+	return reflect.TypeOf(Result{})
+}
+
+func TestSchemaNameCollision(t *testing.T) {
+	components := map[string]*Schema{}
+	schemaOf(firstResult(), components)
+	// The same type again is only referenced
+	if ref := schemaOf(firstResult(), components); ref.Ref != "#/components/schemas/Result" {
+		t.Fatalf("Test failed: expected a reference, got %+v", ref)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Test failed: expected two types named Result to be rejected")
+		}
+	}()
+	schemaOf(secondResult(), components)
+}
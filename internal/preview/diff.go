@@ -0,0 +1,184 @@
+package preview
+
+import (
+	// Built-ins
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Limits decide which revisions are unusually large: the value must change
+// by at least MinDelta and by at least Ratio of the stored value
+type Limits struct {
+	Ratio    float64
+	MinDelta int
+}
+
+// Unless the request asks for others with ratio and min_delta
+var DefaultLimits = Limits{Ratio: 0.1, MinDelta: 10}
+
+// Diff is how an upload differs from the stored data, by location. Values
+// the upload leaves unchanged are left out.
+type Diff struct {
+	Changed   int            `json:"Changed"`
+	Flagged   int            `json:"Flagged"`
+	Locations []LocationDiff `json:"Locations"`
+}
+
+type LocationDiff struct {
+	LocationID *int64     `json:"LocationID"`
+	Admin2     string     `json:"Admin2"`
+	Address1   string     `json:"Province/State"`
+	Address2   string     `json:"Country/Region"`
+	Metric     string     `json:"Metric"`
+	Changes    []DateDiff `json:"Changes"`
+}
+
+// DateDiff is the change to one value. Delta is null unless both values
+// exist; Flagged marks unusually large revisions.
+type DateDiff struct {
+	Date     time.Time `json:"Date"`
+	Action   string    `json:"Action"`
+	OldValue *int      `json:"OldValue"`
+	NewValue *int      `json:"NewValue"`
+	Delta    *int      `json:"Delta"`
+	Flagged  bool      `json:"Flagged"`
+	Reason   string    `json:"Reason,omitempty"`
+}
+
+// ParseLimits reads ratio and min_delta from the query, falling back to
+// DefaultLimits
+func ParseLimits(params map[string][]string) (Limits, error) {
+	limits := DefaultLimits
+	if v, ok := params["ratio"]; ok {
+		ratio, err := strconv.ParseFloat(v[0], 64)
+		if err != nil || ratio < 0 {
+			return limits, errors.New("ratio must be a non-negative number")
+		}
+		limits.Ratio = ratio
+	}
+	if v, ok := params["min_delta"]; ok {
+		minDelta, err := strconv.Atoi(v[0])
+		if err != nil || minDelta < 0 {
+			return limits, errors.New("min_delta must be a non-negative integer")
+		}
+		limits.MinDelta = minDelta
+	}
+	return limits, nil
+}
+
+// DiffOf groups the changes of plan by location, in the order they appear
+func DiffOf(plan *Plan, limits Limits) *Diff {
+	diff := &Diff{Locations: []LocationDiff{}}
+	index := map[string]int{}
+	for _, c := range plan.Changes {
+		if c.Action == Unchanged {
+			continue
+		}
+
+		key := c.Admin2 + "\x00" + c.Address1 + "\x00" + c.Address2 + "\x00" + c.Metric
+		i, ok := index[key]
+		if !ok {
+			i = len(diff.Locations)
+			index[key] = i
+			diff.Locations = append(diff.Locations, LocationDiff{
+				LocationID: c.LocationID,
+				Admin2:     c.Admin2,
+				Address1:   c.Address1,
+				Address2:   c.Address2,
+				Metric:     c.Metric,
+				Changes:    []DateDiff{},
+			})
+		}
+
+		d := DateDiff{
+			Date:     c.Date,
+			Action:   c.Action,
+			OldValue: c.OldValue,
+			NewValue: c.NewValue,
+			Reason:   c.Reason,
+		}
+		if c.OldValue != nil && c.NewValue != nil {
+			delta := *c.NewValue - *c.OldValue
+			d.Delta = &delta
+			d.Flagged = limits.Large(*c.OldValue, delta)
+		}
+		diff.Changed++
+		if d.Flagged {
+			diff.Flagged++
+		}
+		diff.Locations[i].Changes = append(diff.Locations[i].Changes, d)
+	}
+	return diff
+}
+
+// Large tells whether changing old by delta is an unusually large revision
+func (l Limits) Large(old int, delta int) bool {
+	change := math.Abs(float64(delta))
+	return change >= float64(l.MinDelta) && change >= l.Ratio*math.Abs(float64(old)) && delta != 0
+}
+
+// WriteDiff responds with diff as JSON, or one row per change as CSV if asked
+func WriteDiff(w http.ResponseWriter, r *http.Request, diff *Diff) {
+	if r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		b := new(bytes.Buffer)
+		writer := csv.NewWriter(b)
+		if err := writer.WriteAll(writeDiffCSV(diff)); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(diff); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+}
+
+func writeDiffCSV(diff *Diff) [][]string {
+	csvArr := [][]string{
+		{"LocationID", "Admin2", "Province/State", "Country/Region", "Metric",
+			"Date", "Action", "OldValue", "NewValue", "Delta", "Flagged", "Reason"},
+	}
+	for _, l := range diff.Locations {
+		location := ""
+		if l.LocationID != nil {
+			location = strconv.FormatInt(*l.LocationID, 10)
+		}
+		for _, d := range l.Changes {
+			csvArr = append(csvArr, []string{
+				location,
+				l.Admin2,
+				l.Address1,
+				l.Address2,
+				l.Metric,
+				dates.Format(d.Date),
+				d.Action,
+				Optional(d.OldValue),
+				Optional(d.NewValue),
+				Optional(d.Delta),
+				strconv.FormatBool(d.Flagged),
+				d.Reason,
+			})
+		}
+	}
+	return csvArr
+}
@@ -0,0 +1,85 @@
+package preview
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLimits(t *testing.T) {
+	r := httptest.NewRequest("POST", "/?ratio=0.5&min_delta=100", nil)
+	limits, err := ParseLimits(r.URL.Query())
+	if err != nil || limits.Ratio != 0.5 || limits.MinDelta != 100 {
+		t.Fatalf("Test failed: unexpected limits %+v %v", limits, err)
+	}
+
+	r = httptest.NewRequest("POST", "/", nil)
+	if limits, _ = ParseLimits(r.URL.Query()); limits != DefaultLimits {
+		t.Fatalf("Test failed: expected default limits, got %+v", limits)
+	}
+
+	for _, url := range []string{"/?ratio=abc", "/?ratio=-1", "/?min_delta=1.5", "/?min_delta=-3"} {
+		r = httptest.NewRequest("POST", url, nil)
+		if _, err := ParseLimits(r.URL.Query()); err == nil {
+			t.Fatalf("Test failed: expected an error for %s", url)
+		}
+	}
+}
+
+func TestLarge(t *testing.T) {
+	limits := Limits{Ratio: 0.1, MinDelta: 10}
+	cases := []struct {
+		old, delta int
+		expect     bool
+	}{
+		{1000, 100, true},  // 10% and 100 cases
+		{1000, -150, true}, // revisions down count too
+		{1000, 50, false},  // only 5%
+		{20, 5, false},     // 25% but only 5 cases
+		{0, 10, true},
+		{0, 0, false},
+	}
+	for _, c := range cases {
+		if res := limits.Large(c.old, c.delta); res != c.expect {
+			t.Fatalf("Test failed: %d by %d expected %v, got %v", c.old, c.delta, c.expect, res)
+		}
+	}
+}
+
+func TestDiffOf(t *testing.T) {
+	id := int64(2)
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	plan := New()
+	plan.Add(Change{LocationID: &id, Address1: "Ontario", Address2: "Canada", Date: day, Metric: "Confirmed",
+		OldValue: value(100), NewValue: value(100)})
+	plan.Add(Change{LocationID: &id, Address1: "Ontario", Address2: "Canada", Date: day.AddDate(0, 0, 1), Metric: "Confirmed",
+		OldValue: value(100), NewValue: value(300)})
+	plan.Add(Change{Address2: "France", Date: day, Metric: "Confirmed", NewValue: value(7)})
+	plan.Add(Change{LocationID: &id, Address1: "Ontario", Address2: "Canada", Date: day.AddDate(0, 0, 2), Metric: "Confirmed",
+		OldValue: value(300), NewValue: value(301)})
+
+	diff := DiffOf(plan, DefaultLimits)
+	if diff.Changed != 3 || diff.Flagged != 1 || len(diff.Locations) != 2 {
+		t.Fatalf("Test failed: unexpected diff %+v", diff)
+	}
+
+	ontario := diff.Locations[0]
+	if ontario.Address1 != "Ontario" || len(ontario.Changes) != 2 {
+		t.Fatalf("Test failed: unexpected location %+v", ontario)
+	}
+	if *ontario.Changes[0].Delta != 200 || !ontario.Changes[0].Flagged || ontario.Changes[1].Flagged {
+		t.Fatalf("Test failed: unexpected changes %+v", ontario.Changes)
+	}
+	if france := diff.Locations[1]; france.LocationID != nil || france.Changes[0].Delta != nil ||
+		france.Changes[0].Action != Insert {
+		t.Fatalf("Test failed: new values have no delta, got %+v", france)
+	}
+
+	csvArr := writeDiffCSV(diff)
+	expected := []string{"2", "", "Ontario", "Canada", "Confirmed", "2021-03-02", "update", "100", "300", "200", "true", ""}
+	for i, v := range expected {
+		if csvArr[1][i] != v {
+			t.Fatalf("Test failed: column %s expected %q, got %q", csvArr[0][i], v, csvArr[1][i])
+		}
+	}
+}
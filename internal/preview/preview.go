@@ -0,0 +1,145 @@
+package preview
+
+import (
+	// Built-ins
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// What an upload would do to a value
+const (
+	Insert    = "insert"
+	Update    = "update"
+	Unchanged = "unchanged"
+	Reject    = "reject"
+)
+
+// Change is what an upload would do to a single value. LocationID is null
+// for locations that do not exist yet; OldValue is null for new values and
+// NewValue for values that are not whole numbers.
+type Change struct {
+	Action     string    `json:"Action"`
+	LocationID *int64    `json:"LocationID"`
+	Admin2     string    `json:"Admin2"`
+	Address1   string    `json:"Province/State"`
+	Address2   string    `json:"Country/Region"`
+	Date       time.Time `json:"Date"`
+	Metric     string    `json:"Metric"`
+	OldValue   *int      `json:"OldValue"`
+	NewValue   *int      `json:"NewValue"`
+	Reason     string    `json:"Reason,omitempty"`
+}
+
+// Plan lists what an upload would do, without doing it
+type Plan struct {
+	Inserted  int      `json:"Inserted"`
+	Updated   int      `json:"Updated"`
+	Unchanged int      `json:"Unchanged"`
+	Rejected  int      `json:"Rejected"`
+	Changes   []Change `json:"Changes"`
+}
+
+func New() *Plan {
+	return &Plan{Changes: []Change{}}
+}
+
+// Add appends c, deciding its action from its values unless already set
+func (p *Plan) Add(c Change) {
+	if c.Action == "" {
+		c.Action = Classify(c.OldValue, c.NewValue)
+	}
+	switch c.Action {
+	case Insert:
+		p.Inserted++
+	case Update:
+		p.Updated++
+	case Unchanged:
+		p.Unchanged++
+	case Reject:
+		p.Rejected++
+	}
+	p.Changes = append(p.Changes, c)
+}
+
+// Classify tells what storing new over old would do
+func Classify(old *int, new *int) string {
+	switch {
+	case new == nil:
+		return Reject
+	case old == nil:
+		return Insert
+	case *old == *new:
+		return Unchanged
+	}
+	return Update
+}
+
+// Write responds with the plan as JSON, or its changes as CSV if asked
+func Write(w http.ResponseWriter, r *http.Request, plan *Plan) {
+	if r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		b := new(bytes.Buffer)
+		writer := csv.NewWriter(b)
+		if err := writer.WriteAll(writeCSV(plan)); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(plan); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+}
+
+// Helper functions
+
+func writeCSV(plan *Plan) [][]string {
+	csvArr := [][]string{
+		{"Action", "LocationID", "Admin2", "Province/State", "Country/Region",
+			"Date", "Metric", "OldValue", "NewValue", "Reason"},
+	}
+	for _, c := range plan.Changes {
+		location := ""
+		if c.LocationID != nil {
+			location = strconv.FormatInt(*c.LocationID, 10)
+		}
+		csvArr = append(csvArr, []string{
+			c.Action,
+			location,
+			c.Admin2,
+			c.Address1,
+			c.Address2,
+			dates.Format(c.Date),
+			c.Metric,
+			Optional(c.OldValue),
+			Optional(c.NewValue),
+			c.Reason,
+		})
+	}
+	return csvArr
+}
+
+// Optional formats v for CSV, empty if null
+func Optional(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
@@ -0,0 +1,78 @@
+package preview
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func value(v int) *int {
+	return &v
+}
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		old, new *int
+		expect   string
+	}{
+		{nil, value(1), Insert},
+		{value(1), value(2), Update},
+		{value(2), value(2), Unchanged},
+		{value(2), nil, Reject},
+		{nil, nil, Reject},
+	}
+	for _, c := range cases {
+		if res := Classify(c.old, c.new); res != c.expect {
+			t.Fatalf("Test failed: expect %s, got %s", c.expect, res)
+		}
+	}
+}
+
+func TestPlanAdd(t *testing.T) {
+	plan := New()
+	plan.Add(Change{NewValue: value(1)})
+	plan.Add(Change{OldValue: value(1), NewValue: value(3)})
+	plan.Add(Change{OldValue: value(3), NewValue: value(3)})
+	plan.Add(Change{Reason: "bad"})
+	plan.Add(Change{Action: Reject, NewValue: value(3)})
+
+	if plan.Inserted != 1 || plan.Updated != 1 || plan.Unchanged != 1 || plan.Rejected != 2 {
+		t.Fatalf("Test failed: unexpected counts %+v", plan)
+	}
+	if len(plan.Changes) != 5 || plan.Changes[1].Action != Update {
+		t.Fatalf("Test failed: unexpected changes %+v", plan.Changes)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	id := int64(2)
+	plan := New()
+	plan.Add(Change{
+		LocationID: &id,
+		Address1:   "Ontario",
+		Address2:   "Canada",
+		Date:       time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		Metric:     "Confirmed",
+		OldValue:   value(5),
+		NewValue:   value(7),
+	})
+
+	w := httptest.NewRecorder()
+	Write(w, httptest.NewRequest("POST", "/", nil), plan)
+	decoded := Plan{}
+	if err := json.NewDecoder(w.Body).Decode(&decoded); err != nil {
+		t.Fatalf("Test failed: %v", err)
+	}
+	if decoded.Updated != 1 || *decoded.Changes[0].NewValue != 7 {
+		t.Fatalf("Test failed: unexpected plan %+v", decoded)
+	}
+
+	csvArr := writeCSV(plan)
+	expected := []string{"update", "2", "", "Ontario", "Canada", "2021-03-01", "Confirmed", "5", "7", ""}
+	for i, v := range expected {
+		if csvArr[1][i] != v {
+			t.Fatalf("Test failed: column %s expected %q, got %q", csvArr[0][i], v, csvArr[1][i])
+		}
+	}
+}
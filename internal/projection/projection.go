@@ -0,0 +1,250 @@
+package projection
+
+import (
+	// Built-ins
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/dailyReports"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/timeSeries"
+)
+
+// Resources a projection writes to
+const (
+	TimeSeries   = "time_series"
+	DailyReports = "daily_reports"
+)
+
+const backfillUsage = `usage:
+  backfill time_series     derive time series from every stored daily report
+  backfill daily_reports   derive daily reports from every stored time series`
+
+// Writers of the projections; replaced in tests, which have no database
+var (
+	setValue    = timeSeries.SetValue
+	deriveCount = dailyReports.DeriveCount
+)
+
+// Enabled tells whether the projection into resource is turned on, i.e.
+// PROJECT_TIME_SERIES=true or PROJECT_DAILY_REPORTS=true
+func Enabled(resource string) bool {
+	return os.Getenv("PROJECT_"+strings.ToUpper(resource)) == "true"
+}
+
+// ToTimeSeries stores the counts of a daily report as the time series values
+// of its location on its date. Meant for dailyReports.OnStore.
+func ToTimeSeries(ctx context.Context, dr dailyReports.DailyReports) error {
+	return toTimeSeries(ctx, dr, counts(dr))
+}
+
+// ToDailyReports stores the values of a time series row as the metric of the
+// daily reports of its location, one per date, creating missing reports.
+// Active is recomputed from the other counts. Meant for timeSeries.OnStore.
+func ToDailyReports(ctx context.Context, ts timeSeries.TimeSeries, metric string, values map[time.Time]int) error {
+	for _, date := range sortedDates(values) {
+		dr := dailyReports.DailyReports{
+			Admin2: ts.Admin2, Address1: ts.Address1, Address2: ts.Address2, Date: date,
+		}
+		if err := deriveCount(ctx, dr, metric, values[date]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Command runs the "backfill" admin subcommand, i.e. ./a2 backfill time_series
+func Command(args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New(backfillUsage)
+	}
+
+	switch args[0] {
+	case TimeSeries:
+		return backfillTimeSeries(context.Background(), out)
+	case DailyReports:
+		return backfillDailyReports(context.Background(), out)
+	}
+	return errors.New(backfillUsage)
+}
+
+// Helper functions
+
+// Metrics held by both resources
+var metrics = []string{"Confirmed", "Death", "Recovered"}
+
+func counts(dr dailyReports.DailyReports) map[string]int {
+	return map[string]int{
+		"Confirmed": dr.Confirmed,
+		"Death":     dr.Death,
+		"Recovered": dr.Recovered,
+	}
+}
+
+// Stores values (by metric) as time series values of dr's location and date
+func toTimeSeries(ctx context.Context, dr dailyReports.DailyReports, values map[string]int) error {
+	ts := timeSeries.TimeSeries{Admin2: dr.Admin2, Address1: dr.Address1, Address2: dr.Address2}
+	for _, metric := range metrics {
+		value, ok := values[metric]
+		if !ok {
+			continue
+		}
+		if err := setValue(ctx, ts, metric, dr.Date, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A stored daily report; counts the report lacks are left out of values
+type report struct {
+	dr     dailyReports.DailyReports
+	values map[string]int
+}
+
+// The report dr with its stored counts, by metric
+func newReport(dr dailyReports.DailyReports, stored map[string]sql.NullInt64) report {
+	r := report{dr: dr, values: map[string]int{}}
+	for metric, count := range stored {
+		if count.Valid {
+			r.values[metric] = int(count.Int64)
+		}
+	}
+	return r
+}
+
+// Projects every stored daily report, oldest first. Reports are read before
+// any is written, so the projection never reads its own writes.
+func backfillTimeSeries(ctx context.Context, out io.Writer) error {
+	rows, err := db.Db.Query(`
+		SELECT Date, Admin2, Address1, Address2, Confirmed, Death, Recovered
+		FROM DailyReports ORDER BY Date, ID
+	`)
+	if err != nil {
+		return err
+	}
+	reports := []report{}
+	for rows.Next() {
+		var (
+			date                        time.Time
+			admin2, address1            sql.NullString
+			address2                    string
+			confirmed, death, recovered sql.NullInt64
+		)
+		err := rows.Scan(&date, &admin2, &address1, &address2, &confirmed, &death, &recovered)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		dr := dailyReports.DailyReports{
+			Date: date, Admin2: admin2.String, Address1: address1.String, Address2: address2,
+		}
+		reports = append(reports, newReport(dr, map[string]sql.NullInt64{
+			"Confirmed": confirmed, "Death": death, "Recovered": recovered,
+		}))
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for i, r := range reports {
+		if err := toTimeSeries(ctx, r.dr, r.values); err != nil {
+			return fmt.Errorf("daily report %d of %d: %v", i+1, len(reports), err)
+		}
+	}
+	fmt.Fprintf(out, "Projected %d daily reports into time series\n", len(reports))
+	return nil
+}
+
+// A stored time series row and its values of one metric
+type series struct {
+	ts     timeSeries.TimeSeries
+	metric string
+	values map[time.Time]int
+}
+
+// A stored time series value of a location
+type value struct {
+	id    int64
+	ts    timeSeries.TimeSeries
+	date  time.Time
+	value int
+}
+
+// Groups values of metric, ordered by location, into one series each
+func group(metric string, values []value) []series {
+	all := []series{}
+	lastID := int64(-1)
+	for _, v := range values {
+		if v.id != lastID {
+			all = append(all, series{ts: v.ts, metric: metric, values: map[time.Time]int{}})
+			lastID = v.id
+		}
+		all[len(all)-1].values[v.date] = v.value
+	}
+	return all
+}
+
+// Projects every stored time series value, one metric at a time so Active
+// ends up computed from all three
+func backfillDailyReports(ctx context.Context, out io.Writer) error {
+	all := []series{}
+	for _, metric := range metrics {
+		rows, err := db.Db.Query(fmt.Sprintf(`
+			SELECT t.ID, t.Admin2, t.Address1, t.Address2, v.Date, v.%[1]s
+			FROM TimeSeries t JOIN TimeSeries%[1]s v ON v.ID = t.ID
+			ORDER BY t.ID, v.Date
+		`, metric))
+		if err != nil {
+			return err
+		}
+		values := []value{}
+		for rows.Next() {
+			var (
+				v                value
+				admin2, address1 sql.NullString
+			)
+			if err := rows.Scan(&v.id, &admin2, &address1, &v.ts.Address2, &v.date, &v.value); err != nil {
+				rows.Close()
+				return err
+			}
+			v.ts.Admin2, v.ts.Address1 = admin2.String, address1.String
+			values = append(values, v)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		all = append(all, group(metric, values)...)
+	}
+
+	values := 0
+	for i, s := range all {
+		if err := ToDailyReports(ctx, s.ts, s.metric, s.values); err != nil {
+			return fmt.Errorf("time series %d of %d: %v", i+1, len(all), err)
+		}
+		values += len(s.values)
+	}
+	fmt.Fprintf(out, "Projected %d time series values into daily reports\n", values)
+	return nil
+}
+
+func sortedDates(values map[time.Time]int) []time.Time {
+	sorted := []time.Time{}
+	for date := range values {
+		sorted = append(sorted, date)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+	return sorted
+}
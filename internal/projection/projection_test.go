@@ -0,0 +1,171 @@
+package projection
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"gitlab.com/csc301-assignments/a2/internal/dailyReports"
+	"gitlab.com/csc301-assignments/a2/internal/timeSeries"
+)
+
+func TestEnabled(t *testing.T) {
+	t.Setenv("PROJECT_TIME_SERIES", "true")
+	t.Setenv("PROJECT_DAILY_REPORTS", "")
+	if !Enabled(TimeSeries) {
+		t.Fatalf("Test failed: expected the time series projection to be on")
+	}
+	if Enabled(DailyReports) {
+		t.Fatalf("Test failed: expected the daily reports projection to be off")
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"latest"},
+		{TimeSeries, "extra"},
+	} {
+		if err := Command(args, new(bytes.Buffer)); err == nil || err.Error() != backfillUsage {
+			t.Fatalf("Test failed: expected usage for %v, got %v", args, err)
+		}
+	}
+}
+
+func TestCounts(t *testing.T) {
+	values := counts(dailyReports.DailyReports{Confirmed: 10, Death: 2, Recovered: 5, Active: 3})
+	if len(values) != 3 || values["Confirmed"] != 10 || values["Death"] != 2 || values["Recovered"] != 5 {
+		t.Fatalf("Test failed: expected the three time series metrics, got %v", values)
+	}
+}
+
+func TestSortedDates(t *testing.T) {
+	first := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	sorted := sortedDates(map[time.Time]int{
+		first.AddDate(0, 0, 2): 3,
+		first:                  1,
+		first.AddDate(0, 0, 1): 2,
+	})
+	if len(sorted) != 3 || !sorted[0].Equal(first) || !sorted[2].Equal(first.AddDate(0, 0, 2)) {
+		t.Fatalf("Test failed: expected dates in order, got %v", sorted)
+	}
+}
+
+// A write made by a projection
+type write struct {
+	address string
+	date    time.Time
+	metric  string
+	value   int
+}
+
+// Records the writes of the projections instead of storing them
+func recordWrites(t *testing.T, fail int) *[]write {
+	writes := &[]write{}
+	record := func(address string, date time.Time, metric string, value int) error {
+		*writes = append(*writes, write{address, date, metric, value})
+		if len(*writes) == fail {
+			return errors.New("write failed")
+		}
+		return nil
+	}
+	oldSetValue, oldDeriveCount := setValue, deriveCount
+	t.Cleanup(func() { setValue, deriveCount = oldSetValue, oldDeriveCount })
+	setValue = func(ctx context.Context, ts timeSeries.TimeSeries, metric string, date time.Time, value int) error {
+		return record(ts.Admin2+"|"+ts.Address1+"|"+ts.Address2, date, metric, value)
+	}
+	deriveCount = func(ctx context.Context, dr dailyReports.DailyReports, metric string, value int) error {
+		return record(dr.Admin2+"|"+dr.Address1+"|"+dr.Address2, dr.Date, metric, value)
+	}
+	return writes
+}
+
+func TestToTimeSeries(t *testing.T) {
+	writes := recordWrites(t, 0)
+	date := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	dr := dailyReports.DailyReports{Address1: "Ontario", Address2: "Canada", Date: date,
+		Confirmed: 10, Death: 2, Recovered: 5, Active: 3}
+	if err := ToTimeSeries(context.Background(), dr); err != nil {
+		t.Fatalf("Test failed: expected no error, got %v", err)
+	}
+
+	// One value per metric, on the report's date; Active has no time series
+	expected := []write{
+		{"|Ontario|Canada", date, "Confirmed", 10},
+		{"|Ontario|Canada", date, "Death", 2},
+		{"|Ontario|Canada", date, "Recovered", 5},
+	}
+	if len(*writes) != len(expected) {
+		t.Fatalf("Test failed: expected %v, got %v", expected, *writes)
+	}
+	for i, w := range expected {
+		if (*writes)[i] != w {
+			t.Fatalf("Test failed: expected %v, got %v", expected, *writes)
+		}
+	}
+}
+
+func TestBackfilledReportSkipsMissingCounts(t *testing.T) {
+	writes := recordWrites(t, 0)
+	date := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	r := newReport(dailyReports.DailyReports{Address2: "Italy", Date: date}, map[string]sql.NullInt64{
+		"Confirmed": {Int64: 10, Valid: true},
+		"Death":     {},
+		"Recovered": {Int64: 0, Valid: true},
+	})
+	if len(r.values) != 2 || r.values["Confirmed"] != 10 || r.values["Recovered"] != 0 {
+		t.Fatalf("Test failed: expected Confirmed and Recovered only, got %v", r.values)
+	}
+
+	// A report lacking Death leaves the death time series alone
+	if err := toTimeSeries(context.Background(), r.dr, r.values); err != nil {
+		t.Fatalf("Test failed: expected no error, got %v", err)
+	}
+	if len(*writes) != 2 || (*writes)[0].metric != "Confirmed" || (*writes)[1].metric != "Recovered" {
+		t.Fatalf("Test failed: expected Confirmed and Recovered to be written, got %v", *writes)
+	}
+}
+
+func TestToDailyReports(t *testing.T) {
+	writes := recordWrites(t, 0)
+	first := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	ts := timeSeries.TimeSeries{Admin2: "Autauga", Address1: "Alabama", Address2: "US"}
+	values := map[time.Time]int{first.AddDate(0, 0, 1): 12, first: 10}
+	if err := ToDailyReports(context.Background(), ts, "Death", values); err != nil {
+		t.Fatalf("Test failed: expected no error, got %v", err)
+	}
+
+	// One report per date, oldest first
+	if len(*writes) != 2 || (*writes)[0] != (write{"Autauga|Alabama|US", first, "Death", 10}) ||
+		(*writes)[1] != (write{"Autauga|Alabama|US", first.AddDate(0, 0, 1), "Death", 12}) {
+		t.Fatalf("Test failed: unexpected writes %v", *writes)
+	}
+}
+
+func TestToDailyReportsStopsOnError(t *testing.T) {
+	writes := recordWrites(t, 1)
+	first := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	values := map[time.Time]int{first: 10, first.AddDate(0, 0, 1): 12}
+	err := ToDailyReports(context.Background(), timeSeries.TimeSeries{Address2: "US"}, "Confirmed", values)
+	if err == nil || len(*writes) != 1 {
+		t.Fatalf("Test failed: expected the error of the first write, got %v after %v", err, *writes)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	first := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	canada := timeSeries.TimeSeries{Address2: "Canada"}
+	italy := timeSeries.TimeSeries{Address2: "Italy"}
+	all := group("Confirmed", []value{
+		{id: 1, ts: canada, date: first, value: 1},
+		{id: 1, ts: canada, date: first.AddDate(0, 0, 1), value: 2},
+		{id: 2, ts: italy, date: first, value: 5},
+	})
+	if len(all) != 2 || all[0].ts.Address2 != "Canada" || len(all[0].values) != 2 ||
+		all[1].ts.Address2 != "Italy" || all[1].values[first] != 5 || all[1].metric != "Confirmed" {
+		t.Fatalf("Test failed: expected a series per location, got %+v", all)
+	}
+}
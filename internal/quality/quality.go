@@ -0,0 +1,245 @@
+package quality
+
+import (
+	// Built-ins
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+)
+
+// Rules checked over cumulative time series, in the order they run
+const (
+	// A negative count
+	Negative = "negative"
+	// A count lower than the day before
+	Monotonic = "monotonic"
+	// A daily increase far above the increases of the days before
+	Outlier = "outlier"
+)
+
+var Rules = []string{Negative, Monotonic, Outlier}
+
+// What to do with an anomaly during an upload
+const (
+	Off     = "off"
+	Warn    = "warn"
+	Reject  = "reject"
+	Correct = "correct"
+)
+
+// Config sets a mode per rule and tunes the outlier rule: a daily increase
+// is an outlier if it is at least OutlierMin and more than OutlierFactor
+// times the median increase of the OutlierWindow days before.
+type Config struct {
+	Modes         map[string]string
+	OutlierFactor float64
+	OutlierMin    int
+	OutlierWindow int
+}
+
+// Used for anything the environment does not set
+var DefaultConfig = Config{
+	Modes: map[string]string{
+		Negative:  Reject,
+		Monotonic: Warn,
+		Outlier:   Warn,
+	},
+	OutlierFactor: 10,
+	OutlierMin:    100,
+	OutlierWindow: 7,
+}
+
+// Fewest increases the outlier rule needs to judge a day
+const minHistory = 3
+
+// Point is the value of a series on a date
+type Point struct {
+	Date  time.Time
+	Value int
+}
+
+// Anomaly is a value a rule objects to. Expected is the value the rule
+// corrects it to; Action is the mode of the rule when it was found.
+type Anomaly struct {
+	LocationID int64     `json:"LocationID"`
+	Admin2     string    `json:"Admin2"`
+	Address1   string    `json:"Province/State"`
+	Address2   string    `json:"Country/Region"`
+	Metric     string    `json:"Metric"`
+	Date       time.Time `json:"Date"`
+	Rule       string    `json:"Rule"`
+	Action     string    `json:"Action"`
+	Value      int       `json:"Value"`
+	Expected   int       `json:"Expected"`
+	Detail     string    `json:"Detail"`
+}
+
+// ConfigFromEnv reads QUALITY_NEGATIVE, QUALITY_MONOTONIC and
+// QUALITY_OUTLIER (off, warn, reject or correct) along with
+// QUALITY_OUTLIER_FACTOR, QUALITY_OUTLIER_MIN and QUALITY_OUTLIER_WINDOW
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig
+	cfg.Modes = map[string]string{}
+	for rule, mode := range DefaultConfig.Modes {
+		cfg.Modes[rule] = mode
+	}
+
+	for _, rule := range Rules {
+		name := "QUALITY_" + strings.ToUpper(rule)
+		raw := strings.ToLower(os.Getenv(name))
+		if raw == "" {
+			continue
+		}
+		if raw != Off && raw != Warn && raw != Reject && raw != Correct {
+			return cfg, fmt.Errorf("%s: unknown mode %q; use off, warn, reject or correct", name, raw)
+		}
+		cfg.Modes[rule] = raw
+	}
+
+	if raw := os.Getenv("QUALITY_OUTLIER_FACTOR"); raw != "" {
+		factor, err := strconv.ParseFloat(raw, 64)
+		if err != nil || factor <= 0 {
+			return cfg, fmt.Errorf("QUALITY_OUTLIER_FACTOR: %q is not a positive number", raw)
+		}
+		cfg.OutlierFactor = factor
+	}
+	for name, field := range map[string]*int{
+		"QUALITY_OUTLIER_MIN":    &cfg.OutlierMin,
+		"QUALITY_OUTLIER_WINDOW": &cfg.OutlierWindow,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("%s: %q is not a non-negative integer", name, raw)
+			}
+			*field = n
+		}
+	}
+	return cfg, nil
+}
+
+// Check runs every rule over series, sorted by date. previous is the last
+// value before series, if known, so the first day can be judged too.
+// Corrections apply to the returned copy of series as they are found, so
+// later rules and days see corrected values, except for outliers.
+func Check(series []Point, previous *Point, cfg Config) ([]Anomaly, []Point) {
+	return run(series, previous, cfg, true)
+}
+
+// Rejected tells whether any anomaly must stop the upload
+func Rejected(anomalies []Anomaly) bool {
+	for _, a := range anomalies {
+		if a.Action == Reject {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper functions
+
+// Runs the rules over series; corrections are only applied if correct is
+// set, so the report can judge stored data as it is
+func run(series []Point, previous *Point, cfg Config, correct bool) ([]Anomaly, []Point) {
+	anomalies := []Anomaly{}
+	checked := make([]Point, len(series))
+	copy(checked, series)
+
+	history := []Point{}
+	if previous != nil {
+		history = append(history, *previous)
+	}
+
+	for i := range checked {
+		p := &checked[i]
+		// What later days are judged against. A corrected outlier keeps its
+		// reported value there: the cases were counted, just late, and
+		// judging the next days against the correction would flag them all.
+		judged := *p
+		for _, rule := range Rules {
+			mode := cfg.Modes[rule]
+			if mode == "" || mode == Off {
+				continue
+			}
+			expected, detail, found := check(rule, *p, history, cfg)
+			if !found {
+				continue
+			}
+			anomalies = append(anomalies, Anomaly{
+				Date:     p.Date,
+				Rule:     rule,
+				Action:   mode,
+				Value:    p.Value,
+				Expected: expected,
+				Detail:   detail,
+			})
+			if correct && mode == Correct {
+				p.Value = expected
+				if rule != Outlier {
+					judged.Value = expected
+				}
+			}
+		}
+		history = append(history, judged)
+	}
+	return anomalies, checked
+}
+
+// Judges p against the days before it. Returns the value p should have had
+// and why it does not.
+func check(rule string, p Point, history []Point, cfg Config) (int, string, bool) {
+	switch rule {
+	case Negative:
+		if p.Value < 0 {
+			return 0, "count is negative", true
+		}
+	case Monotonic:
+		if len(history) == 0 {
+			return 0, "", false
+		}
+		last := history[len(history)-1]
+		if p.Value < last.Value {
+			return last.Value, fmt.Sprintf("count went down from %d on %s", last.Value,
+				dates.Format(last.Date)), true
+		}
+	case Outlier:
+		increases := []int{}
+		for i := 1; i < len(history); i++ {
+			increases = append(increases, history[i].Value-history[i-1].Value)
+		}
+		if len(increases) > cfg.OutlierWindow {
+			increases = increases[len(increases)-cfg.OutlierWindow:]
+		}
+		if len(increases) < minHistory || len(history) == 0 {
+			return 0, "", false
+		}
+		// Drops are the monotonic rule's business
+		typical := median(increases)
+		if typical < 0 {
+			typical = 0
+		}
+		increase := p.Value - history[len(history)-1].Value
+		if increase >= cfg.OutlierMin && float64(increase) > cfg.OutlierFactor*float64(typical) {
+			return history[len(history)-1].Value + typical,
+				fmt.Sprintf("increase of %d against a typical %d", increase, typical), true
+		}
+	}
+	return 0, "", false
+}
+
+func median(values []int) int {
+	sorted := make([]int, len(values))
+	copy(sorted, values)
+	sort.Ints(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
@@ -0,0 +1,205 @@
+package quality
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func series(values ...int) []Point {
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	points := []Point{}
+	for i, v := range values {
+		points = append(points, Point{Date: start.AddDate(0, 0, i), Value: v})
+	}
+	return points
+}
+
+func withModes(modes map[string]string) Config {
+	cfg := DefaultConfig
+	cfg.Modes = modes
+	return cfg
+}
+
+func TestCheckClean(t *testing.T) {
+	anomalies, checked := Check(series(10, 20, 30, 40, 50), nil, DefaultConfig)
+	if len(anomalies) != 0 {
+		t.Fatalf("Test failed: expected no anomalies, got %v", anomalies)
+	}
+	if checked[4].Value != 50 {
+		t.Fatalf("Test failed: expected values untouched, got %v", checked)
+	}
+}
+
+func TestCheckNegative(t *testing.T) {
+	anomalies, _ := Check(series(5, -1), nil, DefaultConfig)
+	if len(anomalies) != 2 {
+		t.Fatalf("Test failed: expected negative and monotonic anomalies, got %v", anomalies)
+	}
+	if anomalies[0].Rule != Negative || anomalies[0].Action != Reject || anomalies[0].Expected != 0 {
+		t.Fatalf("Test failed: unexpected anomaly %v", anomalies[0])
+	}
+	if !Rejected(anomalies) {
+		t.Fatalf("Test failed: expected the upload to be rejected")
+	}
+}
+
+func TestCheckMonotonicPrevious(t *testing.T) {
+	previous := &Point{Date: time.Date(2021, 2, 28, 0, 0, 0, 0, time.UTC), Value: 100}
+	anomalies, _ := Check(series(90, 110), previous, DefaultConfig)
+	if len(anomalies) != 1 || anomalies[0].Rule != Monotonic || anomalies[0].Expected != 100 {
+		t.Fatalf("Test failed: expected a drop from the previous day, got %v", anomalies)
+	}
+	if Rejected(anomalies) {
+		t.Fatalf("Test failed: expected monotonic to only warn by default")
+	}
+	if !strings.Contains(anomalies[0].Detail, "2021-02-28") {
+		t.Fatalf("Test failed: expected the previous date in %q", anomalies[0].Detail)
+	}
+}
+
+func TestCheckCorrect(t *testing.T) {
+	cfg := withModes(map[string]string{Monotonic: Correct})
+	anomalies, checked := Check(series(10, 20, 15, 25), nil, cfg)
+	if len(anomalies) != 1 || anomalies[0].Value != 15 || anomalies[0].Action != Correct {
+		t.Fatalf("Test failed: expected one corrected drop, got %v", anomalies)
+	}
+	if checked[2].Value != 20 || checked[3].Value != 25 {
+		t.Fatalf("Test failed: expected the drop to be corrected to 20, got %v", checked)
+	}
+}
+
+func TestCheckOutlier(t *testing.T) {
+	anomalies, checked := Check(series(100, 110, 120, 130, 2000, 2010), nil,
+		withModes(map[string]string{Outlier: Correct}))
+	if len(anomalies) != 1 || anomalies[0].Rule != Outlier {
+		t.Fatalf("Test failed: expected one outlier, got %v", anomalies)
+	}
+	if anomalies[0].Value != 2000 || anomalies[0].Expected != 140 {
+		t.Fatalf("Test failed: expected 2000 corrected to 140, got %v", anomalies[0])
+	}
+	if checked[4].Value != 140 {
+		t.Fatalf("Test failed: expected the correction in the series, got %v", checked)
+	}
+
+	// Not enough history to judge
+	if anomalies, _ := Check(series(0, 10, 5000), nil, DefaultConfig); len(anomalies) != 0 {
+		t.Fatalf("Test failed: expected no outlier without history, got %v", anomalies)
+	}
+	// Below OutlierMin
+	if anomalies, _ := Check(series(0, 1, 2, 3, 90), nil, DefaultConfig); len(anomalies) != 0 {
+		t.Fatalf("Test failed: expected small increases to pass, got %v", anomalies)
+	}
+}
+
+func TestCheckOff(t *testing.T) {
+	cfg := withModes(map[string]string{Negative: Off, Monotonic: Off, Outlier: Off})
+	if anomalies, _ := Check(series(10, -5), nil, cfg); len(anomalies) != 0 {
+		t.Fatalf("Test failed: expected no anomalies with every rule off, got %v", anomalies)
+	}
+}
+
+func TestRunWithoutCorrections(t *testing.T) {
+	cfg := withModes(map[string]string{Monotonic: Correct})
+	anomalies, checked := run(series(10, 5, 7), nil, cfg, false)
+	if len(anomalies) != 1 || checked[1].Value != 5 {
+		t.Fatalf("Test failed: expected one drop reported and nothing corrected, got %v %v",
+			anomalies, checked)
+	}
+
+	// Corrected, 5 becomes 10 and 7 is a drop too
+	if anomalies, _ := Check(series(10, 5, 7), nil, cfg); len(anomalies) != 2 {
+		t.Fatalf("Test failed: expected both drops once corrected, got %v", anomalies)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("QUALITY_MONOTONIC", "Correct")
+	t.Setenv("QUALITY_OUTLIER_FACTOR", "2.5")
+	t.Setenv("QUALITY_OUTLIER_WINDOW", "14")
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("Test failed: %v", err)
+	}
+	if cfg.Modes[Monotonic] != Correct || cfg.Modes[Negative] != Reject {
+		t.Fatalf("Test failed: unexpected modes %v", cfg.Modes)
+	}
+	if cfg.OutlierFactor != 2.5 || cfg.OutlierWindow != 14 || cfg.OutlierMin != 100 {
+		t.Fatalf("Test failed: unexpected config %+v", cfg)
+	}
+	if DefaultConfig.Modes[Monotonic] != Warn {
+		t.Fatalf("Test failed: expected DefaultConfig to be left alone")
+	}
+}
+
+func TestConfigFromEnvInvalid(t *testing.T) {
+	for name, value := range map[string]string{
+		"QUALITY_NEGATIVE":       "ignore",
+		"QUALITY_OUTLIER_FACTOR": "0",
+		"QUALITY_OUTLIER_MIN":    "-1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := ConfigFromEnv(); err == nil {
+				t.Fatalf("Test failed: expected an error for %s=%s", name, value)
+			}
+		})
+	}
+}
+
+func TestMedian(t *testing.T) {
+	if m := median([]int{5, 1, 3}); m != 3 {
+		t.Fatalf("Test failed: expected 3, got %d", m)
+	}
+	if m := median([]int{4, 1, 3, 2}); m != 2 {
+		t.Fatalf("Test failed: expected 2, got %d", m)
+	}
+}
+
+func TestMakeQuery(t *testing.T) {
+	r := httptest.NewRequest("GET",
+		"http://example.com/foo?country=Canada,US&metric=death&rule=outlier&from=2021-03-01&limit=5", nil)
+	rep, status := makeQuery(r.URL.Query())
+	if status != 0 {
+		t.Fatalf("Test failed: expected status 0, got %d", status)
+	}
+	if len(rep.metrics) != 1 || rep.metrics[0] != "Death" || rep.limit != 5 {
+		t.Fatalf("Test failed: unexpected report %+v", rep)
+	}
+	query := rep.query("Death")
+	if !strings.Contains(query, "JOIN TimeSeriesDeath v") ||
+		!strings.Contains(query, "WHERE (t.address2=? OR t.address2=?)") {
+		t.Fatalf("Test failed: unexpected query %s", query)
+	}
+	if len(rep.args) != 2 || rep.args[0] != "Canada" || rep.args[1] != "US" {
+		t.Fatalf("Test failed: unexpected args %v", rep.args)
+	}
+
+	march := time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)
+	if !rep.keep(Anomaly{Rule: Outlier, Date: march}) {
+		t.Fatalf("Test failed: expected an outlier in March to be kept")
+	}
+	if rep.keep(Anomaly{Rule: Monotonic, Date: march}) {
+		t.Fatalf("Test failed: expected other rules to be dropped")
+	}
+	if rep.keep(Anomaly{Rule: Outlier, Date: march.AddDate(0, -1, 0)}) {
+		t.Fatalf("Test failed: expected earlier dates to be dropped")
+	}
+}
+
+func TestMakeQueryInvalidParams(t *testing.T) {
+	for _, url := range []string{
+		"http://example.com/foo?abc=def",
+		"http://example.com/foo?metric=active",
+		"http://example.com/foo?rule=spike",
+		"http://example.com/foo?limit=0",
+		"http://example.com/foo?date=2/30/21",
+		"http://example.com/foo?death",
+	} {
+		r := httptest.NewRequest("GET", url, nil)
+		if _, status := makeQuery(r.URL.Query()); status != 400 {
+			t.Fatalf("Test failed: expected 400 for %s, got %d", url, status)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package quality
+
+import (
+	// Built-ins
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// External imports
+	"github.com/go-chi/chi"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Results are capped unless the client asks for more with limit
+const defaultLimit = 1000
+
+// Metrics stored as time series, by query value
+var metrics = map[string]string{
+	"confirmed": "Confirmed",
+	"death":     "Death",
+	"recovered": "Recovered",
+}
+
+// What a report should list; see makeQuery
+type report struct {
+	conds   []string
+	args    []interface{}
+	metrics []string
+	rules   map[string]bool
+	dates   [][]dateCond
+	limit   int
+}
+
+// A date parameter, resolved
+type dateCond struct {
+	op  string
+	rng dates.Range
+}
+
+func Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/", List)
+
+	return r
+}
+
+// List godoc
+// @Summary Data quality report
+// @Description anomalies found by the quality rules in stored time series, per location and date
+// @Tags Quality
+// @Produce json text/csv
+// @Param metric query string false confirmed, death or recovered; Allow multiple inputs, separated by a comma ','
+// @Param rule query string false negative, monotonic or outlier; Allow multiple inputs, separated by a comma ','
+// @Param limit query int false Maximum number of anomalies (default 1000)
+// @Success 200 {array} Anomaly
+// @Failure 400 {string} string "Error status 400"
+// @Failure 500 {string} string "Error status 500"
+// @Router /quality [get]
+func List(w http.ResponseWriter, r *http.Request) {
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+	rep, status := makeQuery(r.URL.Query())
+	if status != 0 {
+		utils.HandleErr(w, 400, errors.New("Invalid input"))
+		return
+	}
+
+	anomalies := []Anomaly{}
+	for _, metric := range rep.metrics {
+		found, err := scan(metric, rep, cfg)
+		if err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		anomalies = append(anomalies, found...)
+	}
+	sort.SliceStable(anomalies, func(i, j int) bool {
+		a, b := anomalies[i], anomalies[j]
+		if a.LocationID != b.LocationID {
+			return a.LocationID < b.LocationID
+		}
+		if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+		return a.Metric < b.Metric
+	})
+	if len(anomalies) > rep.limit {
+		anomalies = anomalies[:rep.limit]
+	}
+
+	// Check 'Accept' type
+	if r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		b := new(bytes.Buffer)
+		writer := csv.NewWriter(b)
+		if err := writer.WriteAll(writeCSV(anomalies)); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(anomalies); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+}
+
+// Helper functions
+
+// Builds the report asked for. On top of the location and date parameters
+// of utils.ParamValidate it accepts:
+//   - metric: Confirmed, Death or Recovered
+//   - rule: negative, monotonic or outlier
+//   - limit: maximum number of anomalies (default 1000)
+//
+// Dates only narrow the anomalies listed; rules still see the whole series.
+func makeQuery(params map[string][]string) (report, int) {
+	rep := report{
+		conds:   []string{},
+		args:    []interface{}{},
+		metrics: []string{"Confirmed", "Death", "Recovered"},
+		dates:   [][]dateCond{},
+		limit:   defaultLimit,
+	}
+	latest := utils.LatestDate("TimeSeriesConfirmed")
+
+	// Sorted to make the query deterministic
+	keys := []string{}
+	for param := range params {
+		keys = append(keys, param)
+	}
+	sort.Strings(keys)
+
+	for _, param := range keys {
+		values := strings.Split(params[param][0], ",")
+		param = strings.ToLower(param)
+
+		switch param {
+		case "limit":
+			n, err := strconv.Atoi(values[0])
+			if err != nil || n <= 0 {
+				return rep, 400
+			}
+			rep.limit = n
+			continue
+		case "metric":
+			rep.metrics = []string{}
+			for _, v := range values {
+				metric, ok := metrics[strings.ToLower(v)]
+				if !ok {
+					return rep, 400
+				}
+				rep.metrics = append(rep.metrics, metric)
+			}
+			continue
+		case "rule":
+			rep.rules = map[string]bool{}
+			for _, v := range values {
+				rule := strings.ToLower(v)
+				if rule != Negative && rule != Monotonic && rule != Outlier {
+					return rep, 400
+				}
+				rep.rules[rule] = true
+			}
+			continue
+		}
+
+		column, valid := utils.ParamValidate(param)
+		if !valid {
+			return rep, 400
+		}
+		switch column {
+		case "admin2", "address1", "address2":
+			alternatives := []string{}
+			for _, v := range values {
+				alternatives = append(alternatives, "t."+column+"=?")
+				rep.args = append(rep.args, v)
+			}
+			rep.conds = append(rep.conds, "("+strings.Join(alternatives, " OR ")+")")
+		case "date", "from", "to", "range", "month":
+			op := map[string]string{"from": ">=", "to": "<="}[column]
+			if op == "" {
+				op = "="
+			}
+			alternatives := []dateCond{}
+			for _, v := range values {
+				rng, err := dates.Resolve(v, latest)
+				if err != nil {
+					return rep, 400
+				}
+				alternatives = append(alternatives, dateCond{op: op, rng: rng})
+			}
+			rep.dates = append(rep.dates, alternatives)
+		default:
+			return rep, 400
+		}
+	}
+	return rep, 0
+}
+
+// Query reading every series of metric in the locations of rep, in order
+func (rep report) query(metric string) string {
+	query := fmt.Sprintf(`
+		SELECT t.ID, t.Admin2, t.Address1, t.Address2, v.Date, v.%[1]s
+		FROM TimeSeries t JOIN TimeSeries%[1]s v ON v.ID = t.ID
+	`, metric)
+	if len(rep.conds) > 0 {
+		query += "WHERE " + strings.Join(rep.conds, " AND ") + "\n"
+	}
+	return query + "ORDER BY t.ID, v.Date"
+}
+
+// Whether a should be listed by the report
+func (rep report) keep(a Anomaly) bool {
+	if rep.rules != nil && !rep.rules[a.Rule] {
+		return false
+	}
+	for _, alternatives := range rep.dates {
+		matched := false
+		for _, c := range alternatives {
+			if c.matches(a.Date) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (c dateCond) matches(t time.Time) bool {
+	switch c.op {
+	case ">=":
+		return !t.Before(c.rng.From)
+	case "<=":
+		return !t.After(c.rng.To)
+	}
+	return !t.Before(c.rng.From) && !t.After(c.rng.To)
+}
+
+// Runs the rules over every stored series of metric
+func scan(metric string, rep report, cfg Config) ([]Anomaly, error) {
+	rows, err := db.Db.Query(rep.query(metric), rep.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	anomalies := []Anomaly{}
+	var (
+		location Anomaly
+		series   []Point
+	)
+	flush := func() {
+		if len(series) == 0 {
+			return
+		}
+		found, _ := run(series, nil, cfg, false)
+		for _, a := range found {
+			a.LocationID, a.Admin2, a.Address1, a.Address2 =
+				location.LocationID, location.Admin2, location.Address1, location.Address2
+			a.Metric = metric
+			if rep.keep(a) {
+				anomalies = append(anomalies, a)
+			}
+		}
+		series = nil
+	}
+
+	for rows.Next() {
+		var (
+			id               int64
+			admin2, address1 sql.NullString
+			address2         string
+			p                Point
+		)
+		if err := rows.Scan(&id, &admin2, &address1, &address2, &p.Date, &p.Value); err != nil {
+			return nil, err
+		}
+		if id != location.LocationID {
+			flush()
+			location = Anomaly{LocationID: id, Admin2: admin2.String,
+				Address1: address1.String, Address2: address2}
+		}
+		series = append(series, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return anomalies, nil
+}
+
+func writeCSV(anomalies []Anomaly) [][]string {
+	csvArr := [][]string{
+		{"LocationID", "Admin2", "Province/State", "Country/Region", "Metric",
+			"Date", "Rule", "Action", "Value", "Expected", "Detail"},
+	}
+	for _, a := range anomalies {
+		csvArr = append(csvArr, []string{
+			strconv.FormatInt(a.LocationID, 10),
+			a.Admin2,
+			a.Address1,
+			a.Address2,
+			a.Metric,
+			dates.Format(a.Date),
+			a.Rule,
+			a.Action,
+			strconv.Itoa(a.Value),
+			strconv.Itoa(a.Expected),
+			a.Detail,
+		})
+	}
+	return csvArr
+}
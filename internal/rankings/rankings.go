@@ -0,0 +1,455 @@
+package rankings
+
+import (
+	// Built-ins
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// External imports
+	"github.com/go-chi/chi"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/timeSeries"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Resources locations can be ranked from
+const (
+	TimeSeries   = "time_series"
+	DailyReports = "daily_reports"
+)
+
+// Number of locations returned unless limit says otherwise
+const defaultLimit = 10
+
+// Metrics that can be ranked, by their name in the metric parameter
+var metrics = map[string]string{
+	"confirmed": "Confirmed",
+	"death":     "Death",
+	"recovered": "Recovered",
+}
+
+// i.e. 7d or 2w
+var windowPattern = regexp.MustCompile(`^(\d+)([dw])$`)
+
+// Ranking is a location and the figure it is ranked by (Value): its Latest
+// value, less its value at the start of the window (Previous) if one was
+// given, per 100,000 inhabitants with per_100k.
+type Ranking struct {
+	Rank       int     `json:"Rank"`
+	Admin2     string  `json:"Admin2"`
+	Address1   string  `json:"Province/State"`
+	Address2   string  `json:"Country/Region"`
+	Value      float64 `json:"Value"`
+	Latest     int     `json:"Latest"`
+	Previous   *int    `json:"Previous,omitempty"`
+	Population *int64  `json:"Population,omitempty"`
+}
+
+// Ranked holds the top locations on Date, ranked by change since Since if
+// a window was given
+type Ranked struct {
+	Source   string     `json:"Source"`
+	Metric   string     `json:"Metric"`
+	Date     time.Time  `json:"Date"`
+	Since    *time.Time `json:"Since,omitempty"`
+	Per100k  bool       `json:"Per100k"`
+	Rankings []Ranking  `json:"Rankings"`
+}
+
+// A ranking request, as parsed by makeQuery
+type query struct {
+	source  string
+	metric  string
+	level   string
+	window  int // in days; 0 ranks by latest value
+	per100k bool
+	date    string
+	limit   int
+	conds   []string
+	args    []interface{}
+}
+
+func Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/", List)
+
+	return r
+}
+
+// List godoc
+// @Summary Rank locations
+// @Description top locations by latest value, change over a window or per capita
+// @Tags Rankings
+// @Produce json text/csv
+// @Param source query string false time_series (default) or daily_reports
+// @Param metric query string false confirmed (default), death or recovered
+// @Param window query string false Rank by change over this many days (i.e. 7d) or weeks (i.e. 2w)
+// @Param level query string false Rank the sums of each "province" or "country"
+// @Param per_100k query bool false Rank per 100,000 inhabitants
+// @Param date query string false Rank as of this date (default latest)
+// @Param limit query int false Number of locations (default 10)
+// @Success 200 {object} Ranked
+// @Failure 400 {string} string "Error status 400"
+// @Failure 500 {string} string "Error status 500"
+// @Router /rankings [get]
+func List(w http.ResponseWriter, r *http.Request) {
+	q, status := makeQuery(r.URL.Query())
+	if status != 0 {
+		utils.HandleErr(w, 400, errors.New("Invalid input"))
+		return
+	}
+	// Relative dates (e.g. "latest", "-7d") are resolved against the source
+	date, err := q.resolveDate()
+	if err != nil {
+		utils.HandleErr(w, 400, errors.New("Invalid input"))
+		return
+	}
+	result, err := q.run(date)
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+
+	// Check 'Accept' type
+	if r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		b := new(bytes.Buffer)
+		writer := csv.NewWriter(b)
+		if err := writer.WriteAll(writeCSV(result)); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+}
+
+// Helper functions
+
+// Parses the ranking parameters; location parameters (admin2, province,
+// country) filter the ranked locations
+func makeQuery(params map[string][]string) (query, int) {
+	q := query{
+		source: TimeSeries,
+		metric: "Confirmed",
+		date:   "latest",
+		limit:  defaultLimit,
+		conds:  []string{},
+		args:   []interface{}{},
+	}
+
+	// Sorted to make the query deterministic
+	keys := []string{}
+	for param := range params {
+		keys = append(keys, param)
+	}
+	sort.Strings(keys)
+
+	for _, param := range keys {
+		value := params[param][0]
+		switch strings.ToLower(param) {
+		case "source":
+			if value != TimeSeries && value != DailyReports {
+				return q, 400
+			}
+			q.source = value
+			continue
+		case "metric":
+			metric, ok := metrics[strings.ToLower(value)]
+			if !ok {
+				return q, 400
+			}
+			q.metric = metric
+			continue
+		case "window":
+			m := windowPattern.FindStringSubmatch(strings.ToLower(value))
+			if m == nil {
+				return q, 400
+			}
+			n, _ := strconv.Atoi(m[1])
+			if m[2] == "w" {
+				n *= 7
+			}
+			if n <= 0 {
+				return q, 400
+			}
+			q.window = n
+			continue
+		case "level":
+			level, ok := map[string]string{
+				"province": timeSeries.LevelProvince, "state": timeSeries.LevelProvince,
+				"country": timeSeries.LevelCountry, "region": timeSeries.LevelCountry,
+			}[strings.ToLower(value)]
+			if !ok {
+				return q, 400
+			}
+			q.level = level
+			continue
+		case "per_100k":
+			if value != "" && value != "true" && value != "false" {
+				return q, 400
+			}
+			q.per100k = value != "false"
+			continue
+		case "limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return q, 400
+			}
+			q.limit = n
+			continue
+		}
+
+		column, valid := utils.ParamValidate(param)
+		if !valid {
+			return q, 400
+		}
+		switch column {
+		case "admin2", "address1", "address2":
+			alternatives := []string{}
+			for _, v := range strings.Split(value, ",") {
+				alternatives = append(alternatives, "l."+column+"=?")
+				q.args = append(q.args, v)
+			}
+			q.conds = append(q.conds, "("+strings.Join(alternatives, " OR ")+")")
+		case "date":
+			q.date = value
+		default:
+			return q, 400
+		}
+	}
+	return q, 0
+}
+
+// The date ranked on: the last day of the date parameter, resolved against
+// the latest date of the source
+func (q query) resolveDate() (time.Time, error) {
+	table := "DailyReports"
+	if q.source == TimeSeries {
+		table = "TimeSeries" + q.metric
+	}
+	rng, err := dates.Resolve(q.date, utils.LatestDate(table))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return rng.To, nil
+}
+
+// Values of the metric on the given dates, per location. The population of
+// a daily report location is that of the time series of the same name.
+// Location columns are those of l, values those of v.
+func (q query) sql(on []time.Time) (string, []interface{}) {
+	where := []string{}
+	args := []interface{}{}
+	for _, date := range on {
+		where = append(where, "v.Date=?")
+		args = append(args, dates.Format(date))
+	}
+	conds := append([]string{"(" + strings.Join(where, " OR ") + ")"}, q.conds...)
+	args = append(args, q.args...)
+
+	if q.source == TimeSeries {
+		return fmt.Sprintf(`
+			SELECT l.Admin2, l.Address1, l.Address2, l.UID, l.Population, v.Date, v.%[1]s
+			FROM TimeSeries l JOIN TimeSeries%[1]s v ON v.ID = l.ID
+			WHERE %[2]s
+		`, q.metric, strings.Join(conds, " AND ")), args
+	}
+	return fmt.Sprintf(`
+		SELECT l.Admin2, l.Address1, l.Address2, t.UID, t.Population, v.Date, v.%[1]s
+		FROM DailyReports v JOIN DailyReports l ON l.ID = v.ID
+		LEFT JOIN TimeSeries t ON t.Admin2 <=> l.Admin2 AND t.Address1 <=> l.Address1
+		AND t.Address2 = l.Address2
+		WHERE v.%[1]s IS NOT NULL AND %[2]s
+	`, q.metric, strings.Join(conds, " AND ")), args
+}
+
+// Reads the values of every location on date (and at the start of the
+// window, if any) and ranks them
+func (q query) run(date time.Time) (Ranked, error) {
+	result := Ranked{Source: q.source, Metric: q.metric, Date: date, Per100k: q.per100k}
+	on := []time.Time{date}
+	if q.window > 0 {
+		since := date.AddDate(0, 0, -q.window)
+		result.Since = &since
+		on = append(on, since)
+	}
+
+	query, args := q.sql(on)
+	rows, err := db.Db.Query(query, args...)
+	if err != nil {
+		return Ranked{}, err
+	}
+	defer rows.Close()
+
+	// Kept as time series, so they roll up the way the time series API does
+	series := map[string]*timeSeries.TimeSeries{}
+	keys := []string{}
+	for rows.Next() {
+		var (
+			admin2, address1 sql.NullString
+			address2         string
+			uid, population  sql.NullInt64
+			day              time.Time
+			value            int
+		)
+		if err := rows.Scan(&admin2, &address1, &address2, &uid, &population, &day, &value); err != nil {
+			return Ranked{}, err
+		}
+		key := admin2.String + "\x00" + address1.String + "\x00" + address2
+		ts, ok := series[key]
+		if !ok {
+			ts = &timeSeries.TimeSeries{Admin2: admin2.String, Address1: address1.String, Address2: address2}
+			setValues(ts, q.metric, map[time.Time]int{})
+			// The UID tells the JHU US files apart when rolling up
+			if uid.Valid || population.Valid {
+				ts.Location = &timeSeries.Location{}
+			}
+			if uid.Valid {
+				ts.Location.UID = &uid.Int64
+			}
+			if population.Valid {
+				ts.Location.Population = &population.Int64
+			}
+			series[key] = ts
+			keys = append(keys, key)
+		}
+		valuesOf(*ts, q.metric)[day] = value
+	}
+	if err := rows.Err(); err != nil {
+		return Ranked{}, err
+	}
+
+	list := []timeSeries.TimeSeries{}
+	for _, key := range keys {
+		list = append(list, *series[key])
+	}
+	if q.level != "" {
+		list = timeSeries.RollUp(list, q.level, q.metric)
+	}
+	result.Rankings = rank(list, q.metric, date, result.Since, q.per100k, q.limit)
+	return result, nil
+}
+
+// Ranks series by their value on date, less their value on since if set,
+// highest first. Series lacking either value, or a population with
+// per100k, are left out.
+func rank(series []timeSeries.TimeSeries, metric string, date time.Time, since *time.Time, per100k bool, limit int) []Ranking {
+	rankings := []Ranking{}
+	for _, ts := range series {
+		values := valuesOf(ts, metric)
+		latest, ok := values[date]
+		if !ok {
+			continue
+		}
+		r := Ranking{
+			Admin2: ts.Admin2, Address1: ts.Address1, Address2: ts.Address2,
+			Latest: latest, Value: float64(latest),
+		}
+		if since != nil {
+			previous, ok := values[*since]
+			if !ok {
+				continue
+			}
+			r.Previous = &previous
+			r.Value = float64(latest - previous)
+		}
+		if ts.Location != nil {
+			r.Population = ts.Location.Population
+		}
+		if per100k {
+			if r.Population == nil || *r.Population <= 0 {
+				continue
+			}
+			r.Value = math.Round(r.Value*100000/float64(*r.Population)*100) / 100
+		}
+		rankings = append(rankings, r)
+	}
+
+	sort.SliceStable(rankings, func(i, j int) bool {
+		a, b := rankings[i], rankings[j]
+		if a.Value != b.Value {
+			return a.Value > b.Value
+		}
+		if a.Address2 != b.Address2 {
+			return a.Address2 < b.Address2
+		}
+		if a.Address1 != b.Address1 {
+			return a.Address1 < b.Address1
+		}
+		return a.Admin2 < b.Admin2
+	})
+	if len(rankings) > limit {
+		rankings = rankings[:limit]
+	}
+	for i := range rankings {
+		rankings[i].Rank = i + 1
+	}
+	return rankings
+}
+
+func valuesOf(ts timeSeries.TimeSeries, metric string) map[time.Time]int {
+	switch metric {
+	case "Confirmed":
+		return ts.Confirmed
+	case "Death":
+		return ts.Death
+	}
+	return ts.Recovered
+}
+
+func setValues(ts *timeSeries.TimeSeries, metric string, values map[time.Time]int) {
+	switch metric {
+	case "Confirmed":
+		ts.Confirmed = values
+	case "Death":
+		ts.Death = values
+	default:
+		ts.Recovered = values
+	}
+}
+
+func writeCSV(result Ranked) [][]string {
+	csvArr := [][]string{{"Rank", "Admin2", "Province/State", "Country/Region", "Value", "Latest", "Previous"}}
+	for _, r := range result.Rankings {
+		previous := ""
+		if r.Previous != nil {
+			previous = strconv.Itoa(*r.Previous)
+		}
+		csvArr = append(csvArr, []string{
+			strconv.Itoa(r.Rank),
+			r.Admin2,
+			r.Address1,
+			r.Address2,
+			strconv.FormatFloat(r.Value, 'f', -1, 64),
+			strconv.Itoa(r.Latest),
+			previous,
+		})
+	}
+	return csvArr
+}
@@ -0,0 +1,111 @@
+package rankings
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/csc301-assignments/a2/internal/timeSeries"
+)
+
+func TestMakeQueryNoParams(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	q, status := makeQuery(r.URL.Query())
+	if status != 0 || q.source != TimeSeries || q.metric != "Confirmed" || q.window != 0 ||
+		q.limit != defaultLimit || q.date != "latest" || q.per100k || q.level != "" {
+		t.Fatalf("Test failed: unexpected defaults %+v %d", q, status)
+	}
+}
+
+func TestMakeQueryParams(t *testing.T) {
+	r := httptest.NewRequest("GET",
+		"http://example.com/foo?metric=death&window=2w&level=country&limit=5&per_100k&country=US,Canada&source=daily_reports", nil)
+	q, status := makeQuery(r.URL.Query())
+	if status != 0 {
+		t.Fatalf("Test failed: expected status 0, got %d", status)
+	}
+	if q.source != DailyReports || q.metric != "Death" || q.window != 14 || q.level != timeSeries.LevelCountry ||
+		q.limit != 5 || !q.per100k {
+		t.Fatalf("Test failed: unexpected query %+v", q)
+	}
+	if len(q.conds) != 1 || q.conds[0] != "(l.address2=? OR l.address2=?)" || len(q.args) != 2 {
+		t.Fatalf("Test failed: unexpected conditions %v %v", q.conds, q.args)
+	}
+
+	query, args := q.sql([]time.Time{time.Date(2021, 3, 8, 0, 0, 0, 0, time.UTC)})
+	if !strings.Contains(query, "FROM DailyReports v") || !strings.Contains(query, "WHERE v.Death IS NOT NULL AND (v.Date=?) AND (l.address2=? OR l.address2=?)") {
+		t.Fatalf("Test failed: unexpected query %s", query)
+	}
+	if len(args) != 3 || args[0] != "2021-03-08" || args[1] != "US" {
+		t.Fatalf("Test failed: unexpected args %v", args)
+	}
+}
+
+func TestMakeQueryInvalidParams(t *testing.T) {
+	for _, url := range []string{
+		"http://example.com/foo?abc=def",
+		"http://example.com/foo?source=latest",
+		"http://example.com/foo?metric=active",
+		"http://example.com/foo?window=7",
+		"http://example.com/foo?window=0d",
+		"http://example.com/foo?level=county",
+		"http://example.com/foo?limit=0",
+		"http://example.com/foo?per_100k=yes",
+		"http://example.com/foo?as_of=2021-03-01",
+	} {
+		r := httptest.NewRequest("GET", url, nil)
+		if _, status := makeQuery(r.URL.Query()); status != 400 {
+			t.Fatalf("Test failed: expected 400 for %s, got %d", url, status)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	date := time.Date(2021, 3, 8, 0, 0, 0, 0, time.UTC)
+	since := date.AddDate(0, 0, -7)
+	population := func(n int64) *timeSeries.Location { return &timeSeries.Location{Population: &n} }
+	series := []timeSeries.TimeSeries{
+		{Address2: "Canada", Death: map[time.Time]int{since: 100, date: 150}, Location: population(1000000)},
+		{Address2: "Italy", Death: map[time.Time]int{since: 100, date: 400}, Location: population(6000000)},
+		{Address2: "Spain", Death: map[time.Time]int{since: 300, date: 500}},
+		// No value at the start of the window
+		{Address2: "France", Death: map[time.Time]int{date: 1000}},
+	}
+
+	latest := rank(series, "Death", date, nil, false, 10)
+	if len(latest) != 4 || latest[0].Address2 != "France" || latest[0].Rank != 1 || latest[3].Address2 != "Canada" {
+		t.Fatalf("Test failed: unexpected ranking by latest value %+v", latest)
+	}
+
+	change := rank(series, "Death", date, &since, false, 2)
+	if len(change) != 2 || change[0].Address2 != "Italy" || change[0].Value != 300 ||
+		change[1].Address2 != "Spain" || *change[1].Previous != 300 || change[1].Rank != 2 {
+		t.Fatalf("Test failed: unexpected ranking by change %+v", change)
+	}
+
+	perCapita := rank(series, "Death", date, &since, true, 10)
+	if len(perCapita) != 2 || perCapita[0].Address2 != "Canada" || perCapita[0].Value != 5 || perCapita[1].Value != 5 {
+		t.Fatalf("Test failed: unexpected ranking per capita %+v", perCapita)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	previous := 100
+	csvArr := writeCSV(Ranked{Rankings: []Ranking{
+		{Rank: 1, Address1: "Ontario", Address2: "Canada", Value: 12.5, Latest: 150, Previous: &previous},
+		{Rank: 2, Address2: "Italy", Value: 400, Latest: 400},
+	}})
+	if len(csvArr) != 3 {
+		t.Fatalf("Test failed: expected a header and two rows, got %v", csvArr)
+	}
+	for i, expected := range []string{
+		"Rank,Admin2,Province/State,Country/Region,Value,Latest,Previous",
+		"1,,Ontario,Canada,12.5,150,100",
+		"2,,,Italy,400,400,",
+	} {
+		if row := strings.Join(csvArr[i], ","); row != expected {
+			t.Fatalf("Test failed: expected %s, got %s", expected, row)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package rateLimit
+
+import (
+	// Built-ins
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/auth"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Default allows each client 120 requests per minute on every route
+const Default = "120/m"
+
+// Buckets are swept once there are this many clients
+const maxBuckets = 10000
+
+// Limit is a token bucket refilled at Rate tokens per second up to Burst
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter rate limits each client (API key or IP address) independently
+type Limiter struct {
+	limit   Limit
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func New(limit Limit) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// ParseLimit reads limits written as "<requests>/<unit>" where unit is s, m
+// or h, i.e. "120/m". Clients can spend the whole amount at once, after which
+// it refills evenly over the unit. "off" (or "0") disables limiting.
+func ParseLimit(s string) (Limit, bool, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "off" || s == "0" {
+		return Limit{}, false, nil
+	}
+
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return Limit{}, false, fmt.Errorf("invalid rate limit %q; expected i.e. 120/m", s)
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n <= 0 {
+		return Limit{}, false, fmt.Errorf("invalid rate limit %q; expected i.e. 120/m", s)
+	}
+	units := map[string]time.Duration{
+		"s": time.Second,
+		"m": time.Minute,
+		"h": time.Hour,
+	}
+	unit, ok := units[parts[1]]
+	if !ok {
+		return Limit{}, false, fmt.Errorf("invalid rate limit unit %q; expected s, m or h", parts[1])
+	}
+	return Limit{Rate: float64(n) / unit.Seconds(), Burst: n}, true, nil
+}
+
+// FromEnv returns the middleware for the route named name, configured by the
+// RATE_LIMIT_<NAME> env variable (i.e. RATE_LIMIT_TIME_SERIES=30/m), falling
+// back to RATE_LIMIT and then to Default.
+func FromEnv(name string) func(http.Handler) http.Handler {
+	raw := os.Getenv("RATE_LIMIT_" + strings.ToUpper(name))
+	if raw == "" {
+		raw = os.Getenv("RATE_LIMIT")
+	}
+	if raw == "" {
+		raw = Default
+	}
+
+	limit, enabled, err := ParseLimit(raw)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !enabled {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return New(limit).Handler
+}
+
+// Handler answers 429 once the client has spent its bucket
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, remaining, retryAfter, reset := l.take(clientKey(r))
+
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(l.limit.Burst))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(seconds(reset)))
+
+		if !ok {
+			h.Set("Retry-After", strconv.Itoa(seconds(retryAfter)))
+			utils.HandleErr(w, 429, errors.New("Rate limit exceeded"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Takes a token from the client's bucket. Returns whether the request is
+// allowed, the tokens left, how long until the next token and how long until
+// the bucket is full again.
+func (l *Limiter) take(client string) (bool, int, time.Duration, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.buckets) >= maxBuckets {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[client] = b
+	}
+
+	// Refill since the last request
+	b.tokens = math.Min(float64(l.limit.Burst),
+		b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	retryAfter := time.Duration(0)
+	if b.tokens < 1 {
+		retryAfter = l.duration(1 - b.tokens)
+	}
+	reset := l.duration(float64(l.limit.Burst) - b.tokens)
+	return allowed, int(b.tokens), retryAfter, reset
+}
+
+// Forgets clients whose bucket has refilled; they start full anyway
+func (l *Limiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate >= float64(l.limit.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// Time needed to refill tokens
+func (l *Limiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.limit.Rate * float64(time.Second))
+}
+
+// Helper functions
+
+// Requests are counted per API key if there is one, per IP address otherwise.
+// Limiters run before keys are looked up, so unchecked keys count by digest.
+func clientKey(r *http.Request) string {
+	if key, ok := auth.FromContext(r.Context()); ok {
+		return fmt.Sprintf("key:%d", key.ID)
+	}
+	if digest := auth.KeyDigest(r); digest != "" {
+		return "key:" + digest
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Rounds up so clients never retry too early
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
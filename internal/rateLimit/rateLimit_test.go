@@ -0,0 +1,146 @@
+package rateLimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/csc301-assignments/a2/internal/auth"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, enabled, err := ParseLimit("120/m")
+	if err != nil || !enabled {
+		t.Fatalf("Error while parsing limit: %v", err)
+	}
+	if limit.Burst != 120 || limit.Rate != 2 {
+		t.Fatalf("Test failed: expected 2/s with burst 120, got %v", limit)
+	}
+
+	limit, _, _ = ParseLimit("10/S")
+	if limit.Burst != 10 || limit.Rate != 10 {
+		t.Fatalf("Test failed: expected 10/s with burst 10, got %v", limit)
+	}
+
+	for _, raw := range []string{"off", "0"} {
+		if _, enabled, err := ParseLimit(raw); enabled || err != nil {
+			t.Fatalf("Test failed: %s should disable limiting", raw)
+		}
+	}
+
+	for _, raw := range []string{"", "120", "-1/m", "a/m", "10/d"} {
+		if _, _, err := ParseLimit(raw); err == nil {
+			t.Fatalf("Test failed: expected an error for %q", raw)
+		}
+	}
+}
+
+func TestTakeRefills(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(Limit{Rate: 1, Burst: 2})
+	l.now = func() time.Time { return now }
+
+	ok, remaining, _, _ := l.take("a")
+	if !ok || remaining != 1 {
+		t.Fatalf("Test failed: expected allowed with 1 left, got %v %d", ok, remaining)
+	}
+	ok, remaining, retryAfter, reset := l.take("a")
+	if !ok || remaining != 0 {
+		t.Fatalf("Test failed: expected allowed with 0 left, got %v %d", ok, remaining)
+	}
+	if retryAfter != time.Second || reset != 2*time.Second {
+		t.Fatalf("Test failed: expected 1s and 2s, got %v and %v", retryAfter, reset)
+	}
+
+	ok, _, _, _ = l.take("a")
+	if ok {
+		t.Fatalf("Test failed: bucket should be empty")
+	}
+
+	// Other clients have their own bucket
+	if ok, _, _, _ := l.take("b"); !ok {
+		t.Fatalf("Test failed: client b should be allowed")
+	}
+
+	// Half a second is not enough for a whole token
+	now = now.Add(500 * time.Millisecond)
+	if ok, _, _, _ := l.take("a"); ok {
+		t.Fatalf("Test failed: bucket should still be empty")
+	}
+	now = now.Add(time.Second)
+	if ok, _, _, _ := l.take("a"); !ok {
+		t.Fatalf("Test failed: bucket should have refilled")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	l := New(Limit{Rate: 1.0 / 60, Burst: 1})
+	handler := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	resp := w.Result()
+	if resp.StatusCode != 200 {
+		t.Fatalf("Test failed: expected 200, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("X-RateLimit-Limit") != "1" || resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("Test failed: unexpected headers %v", resp.Header)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	resp = w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 429 || string(body) != "Error status 429" {
+		t.Fatalf("Test failed: expected 429, got %d %s", resp.StatusCode, string(body))
+	}
+	if retry := resp.Header.Get("Retry-After"); retry != "60" {
+		t.Fatalf("Test failed: expected Retry-After 60, got %s", retry)
+	}
+
+	// Keyed requests from the same IP have their own bucket
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r.WithContext(auth.WithKey(r.Context(), auth.Key{ID: 7}, 0)))
+	if w.Result().StatusCode != 200 {
+		t.Fatalf("Test failed: expected 200 for a keyed request, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	if key := clientKey(r); key != "ip:10.0.0.1" {
+		t.Fatalf("Test failed: expected ip:10.0.0.1, got %s", key)
+	}
+
+	r = r.WithContext(auth.WithKey(r.Context(), auth.Key{ID: 3}, 0))
+	if key := clientKey(r); key != "key:3" {
+		t.Fatalf("Test failed: expected key:3, got %s", key)
+	}
+}
+
+func TestClientKeyBeforeLookup(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://example.com/foo", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-API-Key", "a2_secret")
+	key := clientKey(r)
+	if !strings.HasPrefix(key, "key:") || key == "key:" {
+		t.Fatalf("Test failed: expected a bucket for the key, got %s", key)
+	}
+
+	// The same key from elsewhere shares it, another key does not
+	other := httptest.NewRequest("POST", "http://example.com/foo", nil)
+	other.RemoteAddr = "10.0.0.2:1234"
+	other.Header.Set("Authorization", "Bearer a2_secret")
+	if clientKey(other) != key {
+		t.Fatalf("Test failed: expected %s, got %s", key, clientKey(other))
+	}
+	other.Header.Set("Authorization", "Bearer a2_other")
+	if clientKey(other) == key {
+		t.Fatalf("Test failed: different keys share a bucket")
+	}
+}
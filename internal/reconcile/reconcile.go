@@ -0,0 +1,559 @@
+package reconcile
+
+import (
+	// Built-ins
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// External imports
+	"github.com/go-chi/chi"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/auth"
+	"gitlab.com/csc301-assignments/a2/internal/dailyReports"
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/jobs"
+	"gitlab.com/csc301-assignments/a2/internal/preview"
+	"gitlab.com/csc301-assignments/a2/internal/timeSeries"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Resources that can be treated as authoritative
+const (
+	TimeSeries   = "time_series"
+	DailyReports = "daily_reports"
+)
+
+// Discrepancy is a location, date and metric for which the two resources
+// disagree. DailyReports is null when the daily report lacks the metric;
+// Delta is DailyReports - TimeSeries. Missing names the resource without a
+// value at all for the location and date, whose ID and value are then 0
+// (or null) and Delta null.
+type Discrepancy struct {
+	TimeSeriesID  int64     `json:"TimeSeriesID"`
+	DailyReportID int64     `json:"DailyReportID"`
+	Admin2        string    `json:"Admin2"`
+	Address1      string    `json:"Province/State"`
+	Address2      string    `json:"Country/Region"`
+	Date          time.Time `json:"Date"`
+	Metric        string    `json:"Metric"`
+	TimeSeries    int       `json:"TimeSeries"`
+	DailyReports  *int      `json:"DailyReports"`
+	Delta         *int      `json:"Delta"`
+	Missing       string    `json:"Missing,omitempty"`
+}
+
+// Result is what resolving discrepancies did
+type Result struct {
+	Authoritative string        `json:"Authoritative"`
+	Found         int           `json:"Found"`
+	Resolved      int           `json:"Resolved"`
+	Discrepancies []Discrepancy `json:"Discrepancies"`
+}
+
+// Results are capped unless the client asks for more with limit
+const defaultLimit = 1000
+
+// Metrics held by both resources, by query value
+var metrics = map[string]string{
+	"confirmed": "Confirmed",
+	"death":     "Death",
+	"recovered": "Recovered",
+}
+
+var errPolicy = errors.New("authoritative must be time_series or daily_reports")
+
+// Which discrepancies to look for; see makeQuery
+type filter struct {
+	conds   []string
+	args    []interface{}
+	metrics []string
+	limit   int
+}
+
+func Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/", List)
+	r.Post("/", Resolve)
+
+	return r
+}
+
+// List godoc
+// @Summary Discrepancies between DailyReports and TimeSeries
+// @Description per location, date and metric, the values the two resources disagree on
+// @Tags Reconcile
+// @Produce json text/csv
+// @Param metric query string false confirmed, death or recovered; Allow multiple inputs, separated by a comma ','
+// @Param limit query int false Maximum number of discrepancies (default 1000)
+// @Success 200 {array} Discrepancy
+// @Failure 400 {string} string "Error status 400"
+// @Failure 500 {string} string "Error status 500"
+// @Router /reconcile [get]
+func List(w http.ResponseWriter, r *http.Request) {
+	f, status := makeQuery(r.URL.Query())
+	if status != 0 {
+		utils.HandleErr(w, 400, errors.New("Invalid input"))
+		return
+	}
+	discrepancies, err := find(f)
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+
+	// Check 'Accept' type
+	if r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		b := new(bytes.Buffer)
+		writer := csv.NewWriter(b)
+		if err := writer.WriteAll(writeCSV(discrepancies)); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(discrepancies); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+}
+
+// Resolve godoc
+// @Summary Resolve discrepancies between DailyReports and TimeSeries
+// @Description overwrites the values of one resource with those of the authoritative one
+// @Tags Reconcile
+// @Produce json
+// @Param authoritative query string true time_series or daily_reports
+// @Param async query bool false Resolve in the background and respond 202 with a job
+// @Success 200 {object} Result
+// @Success 202 {object} jobs.Job
+// @Failure 400 {string} string "Error status 400"
+// @Failure 403 {string} string "Error status 403"
+// @Failure 500 {string} string "Error status 500"
+// @Router /reconcile [post]
+func Resolve(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	authoritative := params.Get("authoritative")
+	target, ok := targetOf(authoritative)
+	if !ok {
+		utils.HandleErrDetail(w, 400, errPolicy)
+		return
+	}
+	async := params.Get("async") == "true"
+	params.Del("authoritative")
+	params.Del("async")
+
+	// Only keys that may write the overwritten resource can resolve
+	if err := auth.CheckWrite(r.Context(), target); err != nil {
+		auth.Forbidden(w, err)
+		return
+	}
+
+	f, status := makeQuery(params)
+	if status != 0 {
+		utils.HandleErr(w, 400, errors.New("Invalid input"))
+		return
+	}
+	discrepancies, err := find(f)
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+	if err := authorize(r.Context(), target, discrepancies); err != nil {
+		auth.Forbidden(w, err)
+		return
+	}
+
+	if async {
+		jobs.Submit(w, r, "reconcile", authoritative, []byte(params.Encode()))
+		return
+	}
+
+	resolved, err := resolve(r.Context(), authoritative, discrepancies, func(err error) error { return err })
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	result := Result{
+		Authoritative: authoritative,
+		Found:         len(discrepancies),
+		Resolved:      resolved,
+		Discrepancies: discrepancies,
+	}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+}
+
+// Process resolves discrepancies submitted with async=true; registered with
+// jobs by main. The payload is the query of the request, so discrepancies
+// are found again and the key checked against them as Resolve does.
+func Process(ctx context.Context, authoritative string, payload []byte, tracker *jobs.Tracker) (string, error) {
+	target, ok := targetOf(authoritative)
+	if !ok {
+		return "", errPolicy
+	}
+	params, err := url.ParseQuery(string(payload))
+	if err != nil {
+		return "", err
+	}
+	f, status := makeQuery(params)
+	if status != 0 {
+		return "", errors.New("Invalid input")
+	}
+	discrepancies, err := find(f)
+	if err != nil {
+		return "", err
+	}
+	// i.e. discrepancies in other countries that appeared since
+	if err := authorize(ctx, target, discrepancies); err != nil {
+		return "", err
+	}
+	tracker.SetTotal(len(discrepancies))
+	resolved, err := resolve(ctx, authoritative, discrepancies, func(err error) error {
+		tracker.Row(err)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("resolved %d of %d discrepancies in favour of %s",
+		resolved, len(discrepancies), authoritative), nil
+}
+
+// Helper functions
+
+// The resource overwritten when authoritative wins
+func targetOf(authoritative string) (string, bool) {
+	switch authoritative {
+	case TimeSeries:
+		return DailyReports, true
+	case DailyReports:
+		return TimeSeries, true
+	}
+	return "", false
+}
+
+// Checks that the key attached to ctx may overwrite target for the
+// locations of every discrepancy
+func authorize(ctx context.Context, target string, discrepancies []Discrepancy) error {
+	if err := auth.CheckWrite(ctx, target); err != nil {
+		return err
+	}
+	countries := []string{}
+	for _, d := range discrepancies {
+		countries = append(countries, d.Address2)
+	}
+	return auth.CheckCountries(ctx, countries)
+}
+
+// Builds the filter asked for. On top of the location and date parameters
+// of utils.ParamValidate it accepts:
+//   - metric: Confirmed, Death or Recovered
+//   - limit: maximum number of discrepancies (default 1000)
+func makeQuery(params map[string][]string) (filter, int) {
+	f := filter{
+		conds:   []string{},
+		args:    []interface{}{},
+		metrics: []string{"Confirmed", "Death", "Recovered"},
+		limit:   defaultLimit,
+	}
+	latest := utils.LatestDate("DailyReports")
+
+	// Sorted to make the query deterministic
+	keys := []string{}
+	for param := range params {
+		keys = append(keys, param)
+	}
+	sort.Strings(keys)
+
+	for _, param := range keys {
+		values := strings.Split(params[param][0], ",")
+		param = strings.ToLower(param)
+
+		switch param {
+		case "limit":
+			n, err := strconv.Atoi(values[0])
+			if err != nil || n <= 0 {
+				return f, 400
+			}
+			f.limit = n
+			continue
+		case "metric":
+			f.metrics = []string{}
+			for _, v := range values {
+				metric, ok := metrics[strings.ToLower(v)]
+				if !ok {
+					return f, 400
+				}
+				f.metrics = append(f.metrics, metric)
+			}
+			continue
+		}
+
+		alternatives := []string{}
+		column, valid := utils.ParamValidate(param)
+		if !valid {
+			return f, 400
+		}
+		switch column {
+		case "admin2", "address1", "address2":
+			for _, v := range values {
+				alternatives = append(alternatives, "d."+column+"=?")
+				f.args = append(f.args, v)
+			}
+		case "date", "from", "to", "range", "month":
+			op := map[string]string{"from": ">=", "to": "<="}[column]
+			if op == "" {
+				op = "="
+			}
+			for _, v := range values {
+				rng, err := dates.Resolve(v, latest)
+				if err != nil {
+					return f, 400
+				}
+				alternatives = append(alternatives, utils.DateCondition("d.Date", op, rng))
+			}
+		default:
+			return f, 400
+		}
+		f.conds = append(f.conds, "("+strings.Join(alternatives, " OR ")+")")
+	}
+	return f, 0
+}
+
+// Query pairing the daily reports and time series values of metric that
+// differ. Locations match as injectTimeSeries and injectDailyReport store
+// them, with missing Admin2 and Province/State as NULL.
+func (f filter) query(metric string) string {
+	query := fmt.Sprintf(`
+		SELECT t.ID, d.ID, IFNULL(d.Admin2, ''), IFNULL(d.Address1, ''), d.Address2,
+		d.Date, v.%[1]s, d.%[1]s
+		FROM DailyReports d
+		JOIN TimeSeries t ON IFNULL(t.Admin2, '') = IFNULL(d.Admin2, '')
+		AND IFNULL(t.Address1, '') = IFNULL(d.Address1, '') AND t.Address2 = d.Address2
+		JOIN TimeSeries%[1]s v ON v.ID = t.ID AND v.Date = d.Date
+		WHERE NOT (d.%[1]s <=> v.%[1]s)
+	`, metric)
+	for _, cond := range f.conds {
+		query += "AND " + cond + "\n"
+	}
+	return query + "ORDER BY d.Date, t.ID LIMIT " + strconv.Itoa(f.limit)
+}
+
+// Query of the daily reports values of metric that time series lack: the
+// location has no time series, or no value on a date time series hold for
+// other locations. The time series ID is 0 if the location has none.
+func (f filter) missingTimeSeries(metric string) string {
+	query := fmt.Sprintf(`
+		SELECT IFNULL(t.ID, 0), d.ID, IFNULL(d.Admin2, ''), IFNULL(d.Address1, ''), d.Address2,
+		d.Date, 0, d.%[1]s
+		FROM DailyReports d
+		LEFT JOIN TimeSeries t ON IFNULL(t.Admin2, '') = IFNULL(d.Admin2, '')
+		AND IFNULL(t.Address1, '') = IFNULL(d.Address1, '') AND t.Address2 = d.Address2
+		LEFT JOIN TimeSeries%[1]s v ON v.ID = t.ID AND v.Date = d.Date
+		WHERE v.ID IS NULL AND d.%[1]s IS NOT NULL
+		AND EXISTS (SELECT 1 FROM TimeSeries%[1]s o WHERE o.Date = d.Date)
+	`, metric)
+	for _, cond := range f.conds {
+		query += "AND " + cond + "\n"
+	}
+	return query + "ORDER BY d.Date, d.ID LIMIT " + strconv.Itoa(f.limit)
+}
+
+// Query of the time series values of metric that daily reports lack: the
+// location has no report on a date daily reports are held for. The time
+// series values are aliased d so the filters apply to them.
+func (f filter) missingDailyReports(metric string) string {
+	query := fmt.Sprintf(`
+		SELECT d.ID, 0, d.Admin2, d.Address1, d.Address2, d.Date, d.%[1]s, NULL
+		FROM (
+			SELECT t.ID, IFNULL(t.Admin2, '') AS Admin2, IFNULL(t.Address1, '') AS Address1,
+			t.Address2, v.Date, v.%[1]s
+			FROM TimeSeries t
+			JOIN TimeSeries%[1]s v ON v.ID = t.ID
+			WHERE v.Date IN (SELECT Date FROM DailyReports)
+		) d
+		LEFT JOIN DailyReports r ON IFNULL(r.Admin2, '') = d.Admin2
+		AND IFNULL(r.Address1, '') = d.Address1 AND r.Address2 = d.Address2 AND r.Date = d.Date
+		WHERE r.ID IS NULL
+	`, metric)
+	for _, cond := range f.conds {
+		query += "AND " + cond + "\n"
+	}
+	return query + "ORDER BY d.Date, d.ID LIMIT " + strconv.Itoa(f.limit)
+}
+
+// Finds the discrepancies of every metric, by date then location
+func find(f filter) ([]Discrepancy, error) {
+	discrepancies := []Discrepancy{}
+	for _, metric := range f.metrics {
+		for _, q := range []struct {
+			query   string
+			missing string
+		}{
+			{f.query(metric), ""},
+			{f.missingTimeSeries(metric), TimeSeries},
+			{f.missingDailyReports(metric), DailyReports},
+		} {
+			found, err := scan(q.query, f.args, metric, q.missing)
+			if err != nil {
+				return nil, err
+			}
+			discrepancies = append(discrepancies, found...)
+		}
+	}
+
+	sort.SliceStable(discrepancies, func(i, j int) bool {
+		a, b := discrepancies[i], discrepancies[j]
+		if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+		return a.TimeSeriesID < b.TimeSeriesID
+	})
+	if len(discrepancies) > f.limit {
+		discrepancies = discrepancies[:f.limit]
+	}
+	return discrepancies, nil
+}
+
+// Reads the discrepancies of metric found by query; missing is the resource
+// they are missing from, if any
+func scan(query string, args []interface{}, metric string, missing string) ([]Discrepancy, error) {
+	rows, err := db.Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	discrepancies := []Discrepancy{}
+	for rows.Next() {
+		d := Discrepancy{Metric: metric, Missing: missing}
+		var daily sql.NullInt64
+		err := rows.Scan(&d.TimeSeriesID, &d.DailyReportID, &d.Admin2, &d.Address1,
+			&d.Address2, &d.Date, &d.TimeSeries, &daily)
+		if err != nil {
+			return nil, err
+		}
+		discrepancies = append(discrepancies, withDelta(d, daily))
+	}
+	return discrepancies, rows.Err()
+}
+
+func withDelta(d Discrepancy, daily sql.NullInt64) Discrepancy {
+	if daily.Valid {
+		value, delta := int(daily.Int64), int(daily.Int64)-d.TimeSeries
+		d.DailyReports, d.Delta = &value, &delta
+		// Nothing to subtract from
+		if d.Missing != "" {
+			d.Delta = nil
+		}
+	}
+	return d
+}
+
+// Overwrites the other resource with the authoritative one. onRow is called
+// after each discrepancy with the error that kept it from being resolved, if
+// any; returning an error stops. Values missing from the authoritative
+// resource (or daily reports lacking the metric) have nothing to copy, and
+// time series values missing from daily reports cannot make a report without
+// its other counts; both are skipped.
+func resolve(ctx context.Context, authoritative string, discrepancies []Discrepancy, onRow func(error) error) (int, error) {
+	resolved := 0
+	for _, d := range discrepancies {
+		if d.Missing == authoritative || d.Missing == DailyReports {
+			if err := onRow(nil); err != nil {
+				return resolved, err
+			}
+			continue
+		}
+
+		var err error
+		switch authoritative {
+		case TimeSeries:
+			err = dailyReports.SetCount(ctx, dailyReports.DailyReports{
+				Admin2:   d.Admin2,
+				Address1: d.Address1,
+				Address2: d.Address2,
+				Date:     d.Date,
+			}, d.Metric, d.TimeSeries)
+		case DailyReports:
+			if d.DailyReports == nil {
+				if err := onRow(nil); err != nil {
+					return resolved, err
+				}
+				continue
+			}
+			err = timeSeries.SetValue(ctx, timeSeries.TimeSeries{
+				Admin2:   d.Admin2,
+				Address1: d.Address1,
+				Address2: d.Address2,
+			}, d.Metric, d.Date, *d.DailyReports)
+		}
+		if err == nil {
+			resolved++
+		}
+		if err := onRow(err); err != nil {
+			return resolved, err
+		}
+	}
+	return resolved, nil
+}
+
+// Values and IDs of the resource a discrepancy is missing from are empty
+func writeCSV(discrepancies []Discrepancy) [][]string {
+	csvArr := [][]string{
+		{"TimeSeriesID", "DailyReportID", "Admin2", "Province/State", "Country/Region",
+			"Date", "Metric", "TimeSeries", "DailyReports", "Delta"},
+	}
+	for _, d := range discrepancies {
+		timeSeriesID, dailyReportID := strconv.FormatInt(d.TimeSeriesID, 10), strconv.FormatInt(d.DailyReportID, 10)
+		value := strconv.Itoa(d.TimeSeries)
+		switch d.Missing {
+		case TimeSeries:
+			value = ""
+			if d.TimeSeriesID == 0 {
+				timeSeriesID = ""
+			}
+		case DailyReports:
+			dailyReportID = ""
+		}
+		csvArr = append(csvArr, []string{
+			timeSeriesID,
+			dailyReportID,
+			d.Admin2,
+			d.Address1,
+			d.Address2,
+			dates.Format(d.Date),
+			d.Metric,
+			value,
+			preview.Optional(d.DailyReports),
+			preview.Optional(d.Delta),
+		})
+	}
+	return csvArr
+}
@@ -0,0 +1,203 @@
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/csc301-assignments/a2/internal/auth"
+)
+
+func TestMakeQueryNoParams(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	f, status := makeQuery(r.URL.Query())
+	if status != 0 || len(f.args) != 0 || len(f.metrics) != 3 {
+		t.Fatalf("Test failed: expected every metric and no args, got %+v %d", f, status)
+	}
+	query := f.query("Confirmed")
+	if !strings.Contains(query, "JOIN TimeSeriesConfirmed v ON v.ID = t.ID AND v.Date = d.Date") ||
+		!strings.Contains(query, "WHERE NOT (d.Confirmed <=> v.Confirmed)") {
+		t.Fatalf("Test failed: unexpected query %s", query)
+	}
+	if !strings.HasSuffix(query, "ORDER BY d.Date, t.ID LIMIT 1000") {
+		t.Fatalf("Test failed: expected default limit, got %s", query)
+	}
+}
+
+func TestMakeQueryFilters(t *testing.T) {
+	r := httptest.NewRequest("GET",
+		"http://example.com/foo?country=Canada&province=Ontario,Quebec&metric=death&from=2021-03-01&limit=5", nil)
+	f, status := makeQuery(r.URL.Query())
+	if status != 0 {
+		t.Fatalf("Test failed: expected status 0, got %d", status)
+	}
+	if len(f.metrics) != 1 || f.metrics[0] != "Death" {
+		t.Fatalf("Test failed: expected only Death, got %v", f.metrics)
+	}
+
+	query := f.query("Death")
+	for _, expected := range []string{
+		"AND (d.address2=?)",
+		`AND (d.Date>="2021-03-01")`,
+		"AND (d.address1=? OR d.address1=?)",
+		"LIMIT 5",
+	} {
+		if !strings.Contains(query, expected) {
+			t.Fatalf("Test failed: expected %s in %s", expected, query)
+		}
+	}
+	if len(f.args) != 3 || f.args[0] != "Canada" || f.args[1] != "Ontario" || f.args[2] != "Quebec" {
+		t.Fatalf("Test failed: unexpected args %v", f.args)
+	}
+}
+
+func TestMakeQueryInvalidParams(t *testing.T) {
+	for _, url := range []string{
+		"http://example.com/foo?abc=def",
+		"http://example.com/foo?metric=active",
+		"http://example.com/foo?limit=-1",
+		"http://example.com/foo?date=2/30/21",
+		"http://example.com/foo?recovered",
+		"http://example.com/foo?as_of=2021-03-01",
+	} {
+		r := httptest.NewRequest("GET", url, nil)
+		if _, status := makeQuery(r.URL.Query()); status != 400 {
+			t.Fatalf("Test failed: expected 400 for %s, got %d", url, status)
+		}
+	}
+}
+
+func TestTargetOf(t *testing.T) {
+	if target, ok := targetOf(TimeSeries); !ok || target != DailyReports {
+		t.Fatalf("Test failed: expected daily_reports to be overwritten, got %s", target)
+	}
+	if target, ok := targetOf(DailyReports); !ok || target != TimeSeries {
+		t.Fatalf("Test failed: expected time_series to be overwritten, got %s", target)
+	}
+	if _, ok := targetOf("latest"); ok {
+		t.Fatalf("Test failed: expected latest to be rejected")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	discrepancies := []Discrepancy{{Address2: "Canada"}, {Address2: "US"}}
+	if err := authorize(context.Background(), TimeSeries, discrepancies); err != nil {
+		t.Fatalf("Test failed: expected no error without a key, got %v", err)
+	}
+
+	// i.e. US discrepancies found when a job of a Canadian key runs
+	key := auth.Key{Role: auth.RoleUploader, Scopes: []string{"country:Canada"}}
+	ctx := auth.WithKey(context.Background(), key, 0)
+	if err := authorize(ctx, TimeSeries, discrepancies[:1]); err != nil {
+		t.Fatalf("Test failed: expected no error, got %v", err)
+	}
+	if err := authorize(ctx, TimeSeries, discrepancies); err == nil {
+		t.Fatalf("Test failed: expected an error for US")
+	}
+
+	key.Scopes = []string{"daily_reports"}
+	ctx = auth.WithKey(context.Background(), key, 0)
+	if err := authorize(ctx, TimeSeries, discrepancies[:1]); err == nil {
+		t.Fatalf("Test failed: expected an error without the time_series scope")
+	}
+}
+
+func TestWithDelta(t *testing.T) {
+	d := withDelta(Discrepancy{TimeSeries: 10}, sql.NullInt64{Int64: 7, Valid: true})
+	if d.DailyReports == nil || *d.DailyReports != 7 || d.Delta == nil || *d.Delta != -3 {
+		t.Fatalf("Test failed: expected 7 and a delta of -3, got %+v", d)
+	}
+	d = withDelta(Discrepancy{TimeSeries: 10}, sql.NullInt64{})
+	if d.DailyReports != nil || d.Delta != nil {
+		t.Fatalf("Test failed: expected no value nor delta, got %+v", d)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	d := withDelta(Discrepancy{
+		TimeSeriesID:  1,
+		DailyReportID: 2,
+		Address1:      "Ontario",
+		Address2:      "Canada",
+		Date:          time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		Metric:        "Confirmed",
+		TimeSeries:    10,
+	}, sql.NullInt64{})
+	csvArr := writeCSV([]Discrepancy{d})
+	if len(csvArr) != 2 {
+		t.Fatalf("Test failed: expected a header and a row, got %v", csvArr)
+	}
+	expected := "1,2,,Ontario,Canada,2021-03-01,Confirmed,10,,"
+	if row := strings.Join(csvArr[1], ","); row != expected {
+		t.Fatalf("Test failed: expected %s, got %s", expected, row)
+	}
+}
+
+func TestMissingQueries(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo?country=Canada&from=2021-03-01", nil)
+	f, status := makeQuery(r.URL.Query())
+	if status != 0 {
+		t.Fatalf("Test failed: expected status 0, got %d", status)
+	}
+
+	query := f.missingTimeSeries("Death")
+	for _, expected := range []string{
+		"LEFT JOIN TimeSeriesDeath v ON v.ID = t.ID AND v.Date = d.Date",
+		"WHERE v.ID IS NULL AND d.Death IS NOT NULL",
+		"AND (d.address2=?)",
+		`AND (d.Date>="2021-03-01")`,
+	} {
+		if !strings.Contains(query, expected) {
+			t.Fatalf("Test failed: expected %s in %s", expected, query)
+		}
+	}
+
+	// Filters apply to the time series values, aliased d
+	query = f.missingDailyReports("Death")
+	for _, expected := range []string{
+		"JOIN TimeSeriesDeath v ON v.ID = t.ID",
+		"AND r.Address2 = d.Address2 AND r.Date = d.Date",
+		"WHERE r.ID IS NULL",
+		"AND (d.address2=?)",
+		`AND (d.Date>="2021-03-01")`,
+		"LIMIT 1000",
+	} {
+		if !strings.Contains(query, expected) {
+			t.Fatalf("Test failed: expected %s in %s", expected, query)
+		}
+	}
+}
+
+func TestMissing(t *testing.T) {
+	date := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	noSeries := withDelta(Discrepancy{DailyReportID: 2, Address2: "Canada", Date: date, Metric: "Confirmed",
+		Missing: TimeSeries}, sql.NullInt64{Int64: 7, Valid: true})
+	if noSeries.DailyReports == nil || *noSeries.DailyReports != 7 || noSeries.Delta != nil {
+		t.Fatalf("Test failed: expected 7 without a delta, got %+v", noSeries)
+	}
+	noReport := withDelta(Discrepancy{TimeSeriesID: 1, Address2: "Italy", Date: date, Metric: "Confirmed",
+		TimeSeries: 10, Missing: DailyReports}, sql.NullInt64{})
+
+	csvArr := writeCSV([]Discrepancy{noSeries, noReport})
+	for i, expected := range []string{
+		",2,,,Canada,2021-03-01,Confirmed,,7,",
+		"1,,,,Italy,2021-03-01,Confirmed,10,,",
+	} {
+		if row := strings.Join(csvArr[i+1], ","); row != expected {
+			t.Fatalf("Test failed: expected %s, got %s", expected, row)
+		}
+	}
+
+	// Time series have nothing to copy for either
+	calls := 0
+	resolved, err := resolve(context.Background(), TimeSeries, []Discrepancy{noSeries, noReport}, func(err error) error {
+		calls++
+		return err
+	})
+	if err != nil || resolved != 0 || calls != 2 {
+		t.Fatalf("Test failed: expected both to be skipped, got %d resolved, %d rows, %v", resolved, calls, err)
+	}
+}
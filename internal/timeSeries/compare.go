@@ -0,0 +1,213 @@
+package timeSeries
+
+import (
+	// Built-ins
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Threshold used by Compare unless one is given, i.e. "days since the 100th case"
+const defaultThreshold = 100
+
+// Aligned is a series re-indexed by days since it reached the threshold:
+// day 0 (Start) is the first date its value was at least the threshold.
+// Start is null and Values empty for series that never reached it.
+type Aligned struct {
+	ID       string      `json:"ID"`
+	Admin2   string      `json:"Admin2"`
+	Address1 string      `json:"Province/State"`
+	Address2 string      `json:"Country/Region"`
+	Start    *time.Time  `json:"Start"`
+	Values   map[int]int `json:"Values"`
+}
+
+// Comparison holds series of one metric aligned on the same threshold
+type Comparison struct {
+	Metric    string    `json:"Metric"`
+	Threshold int       `json:"Threshold"`
+	Series    []Aligned `json:"Series"`
+}
+
+// Compare godoc
+// @Summary Compare TimeSeries aligned by outbreak day
+// @Description the metric of every matching location, re-indexed by days since it reached the threshold
+// @Tags TimeSeries
+// @Produce json text/csv
+// @Param threshold query int false Day 0 is the first date with at least this value (default 100)
+// @Param country 	query string false Allow multiple inputs, separated by a comma ',' (with no space)
+// @Param level query string false Compare the sums of each "province" or "country"
+// @Param death 	query bool false Is mutually exclusive with recovered
+// @Param recovered query bool false Is mutually exclusive with death
+// @Success 200 {object} Comparison
+// @Failure 400 {string} string "Error status 400"
+// @Failure 500 {string} string "Error status 500"
+// @Router /time_series/compare [get]
+func Compare(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	threshold, err := parseThreshold(params)
+	if err != nil {
+		utils.HandleErrDetail(w, 400, err)
+		return
+	}
+	// Day 0 is when the whole series reached the threshold, so date filters
+	// only cut the values returned
+	dateless := withoutDates(params)
+	l, status, err := load(params)
+	if err != nil {
+		utils.HandleErr(w, status, err)
+		return
+	}
+	// Per 100k values are not aligned
+	if l.perPopulation {
+		utils.HandleErrDetail(w, 400, errors.New("per_100k is not supported by compare"))
+		return
+	}
+	whole := l
+	if len(dateless) < len(params) {
+		if whole, status, err = load(dateless); err != nil {
+			utils.HandleErr(w, status, err)
+			return
+		}
+	}
+	starts := map[string]*time.Time{}
+	for _, ts := range whole.series {
+		starts[addressKey(ts)] = reached(metricValues(ts, l.typeStr), threshold)
+	}
+
+	c := Comparison{Metric: l.typeStr, Threshold: threshold, Series: []Aligned{}}
+	for _, ts := range l.series {
+		c.Series = append(c.Series, alignAt(ts, metricValues(ts, l.typeStr), starts[addressKey(ts)]))
+	}
+
+	if r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		b := new(bytes.Buffer)
+		if err := csv.NewWriter(b).WriteAll(writeComparison(c)); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+}
+
+// Helper functions
+
+// Parses the threshold parameter and removes it from params, as makeQuery
+// does not know it
+func parseThreshold(params map[string][]string) (int, error) {
+	threshold := defaultThreshold
+	for param, v := range params {
+		if strings.ToLower(param) != "threshold" {
+			continue
+		}
+		delete(params, param)
+		n, err := strconv.Atoi(v[0])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("threshold must be a non negative integer, got %q", v[0])
+		}
+		threshold = n
+	}
+	return threshold, nil
+}
+
+// Copies params without the date filters (date, from, to, range, month)
+func withoutDates(params url.Values) url.Values {
+	result := url.Values{}
+	for param, v := range params {
+		switch strings.ToLower(param) {
+		case "date", "from", "to", "range", "month":
+			continue
+		}
+		result[param] = v
+	}
+	return result
+}
+
+// Identifies a series, or a sum of series (which has no ID)
+func addressKey(ts TimeSeries) string {
+	return ts.Admin2 + "\x00" + ts.Address1 + "\x00" + ts.Address2
+}
+
+// Returns the first date values reached threshold, or nil
+func reached(values map[time.Time]int, threshold int) *time.Time {
+	var first *time.Time
+	for date, v := range values {
+		if v >= threshold && (first == nil || date.Before(*first)) {
+			date := date
+			first = &date
+		}
+	}
+	return first
+}
+
+// Re-indexes values by days since the first date they reached threshold.
+// Dates before it are left out.
+func align(ts TimeSeries, values map[time.Time]int, threshold int) Aligned {
+	return alignAt(ts, values, reached(values, threshold))
+}
+
+// Re-indexes values by days since start (day 0), i.e. found in more values
+// than those returned; nil for series that never reached the threshold
+func alignAt(ts TimeSeries, values map[time.Time]int, start *time.Time) Aligned {
+	a := Aligned{
+		ID: ts.ID, Admin2: ts.Admin2, Address1: ts.Address1, Address2: ts.Address2,
+		Values: map[int]int{}, Start: start,
+	}
+	if a.Start == nil {
+		return a
+	}
+	for date, v := range values {
+		if date.Before(*a.Start) {
+			continue
+		}
+		day := int(date.Sub(*a.Start).Hours() / 24)
+		a.Values[day] = v
+	}
+	return a
+}
+
+// One row per series and day, ordered by series then day
+func writeComparison(c Comparison) [][]string {
+	csvArr := [][]string{{"ID", "Address", "Day", "Date", c.Metric}}
+	for _, a := range c.Series {
+		days := []int{}
+		for day := range a.Values {
+			days = append(days, day)
+		}
+		sort.Ints(days)
+		address := writeAddress(TimeSeries{Admin2: a.Admin2, Address1: a.Address1, Address2: a.Address2})
+		for _, day := range days {
+			csvArr = append(csvArr, []string{
+				a.ID,
+				address,
+				strconv.Itoa(day),
+				dates.Format(a.Start.AddDate(0, 0, day)),
+				strconv.Itoa(a.Values[day]),
+			})
+		}
+	}
+	return csvArr
+}
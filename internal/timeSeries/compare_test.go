@@ -0,0 +1,98 @@
+package timeSeries
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseThreshold(t *testing.T) {
+	params := httptest.NewRequest("GET", "http://example.com/foo?country=US", nil).URL.Query()
+	if threshold, err := parseThreshold(params); err != nil || threshold != defaultThreshold {
+		t.Fatalf("Test failed: expected the default threshold, got %d %v", threshold, err)
+	}
+
+	params = httptest.NewRequest("GET", "http://example.com/foo?Threshold=10&country=US", nil).URL.Query()
+	threshold, err := parseThreshold(params)
+	if err != nil || threshold != 10 {
+		t.Fatalf("Test failed: expected 10, got %d %v", threshold, err)
+	}
+	if len(params) != 1 {
+		t.Fatalf("Test failed: expected threshold to be removed, got %v", params)
+	}
+
+	for _, url := range []string{"http://example.com/foo?threshold=-1", "http://example.com/foo?threshold=many"} {
+		params := httptest.NewRequest("GET", url, nil).URL.Query()
+		if _, err := parseThreshold(params); err == nil {
+			t.Fatalf("Test failed: expected an error for %s", url)
+		}
+	}
+}
+
+func TestAlign(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 3, d, 0, 0, 0, 0, time.UTC) }
+	values := map[time.Time]int{day(1): 50, day(2): 100, day(3): 90, day(4): 180, day(6): 400}
+	a := align(TimeSeries{ID: "1", Address2: "Italy"}, values, 100)
+
+	if a.Start == nil || !a.Start.Equal(day(2)) {
+		t.Fatalf("Test failed: expected day 0 to be %v, got %v", day(2), a.Start)
+	}
+	expected := map[int]int{0: 100, 1: 90, 2: 180, 4: 400}
+	if len(a.Values) != len(expected) {
+		t.Fatalf("Test failed: expected %v, got %v", expected, a.Values)
+	}
+	for d, v := range expected {
+		if a.Values[d] != v {
+			t.Fatalf("Test failed: expected %d on day %d, got %d", v, d, a.Values[d])
+		}
+	}
+
+	never := align(TimeSeries{ID: "2", Address2: "Canada"}, values, 1000)
+	if never.Start != nil || len(never.Values) != 0 {
+		t.Fatalf("Test failed: expected no start nor values, got %+v", never)
+	}
+}
+
+func TestAlignAt(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 3, d, 0, 0, 0, 0, time.UTC) }
+	// i.e. from=2020-03-04, after the threshold was reached on March 2
+	values := map[time.Time]int{day(4): 180, day(6): 400}
+	start := day(2)
+	a := alignAt(TimeSeries{ID: "1", Address2: "Italy"}, values, &start)
+	if !a.Start.Equal(day(2)) || len(a.Values) != 2 || a.Values[2] != 180 || a.Values[4] != 400 {
+		t.Fatalf("Test failed: expected days 2 and 4 since March 2, got %v %v", a.Start, a.Values)
+	}
+}
+
+func TestWithoutDates(t *testing.T) {
+	params := httptest.NewRequest("GET",
+		"http://example.com/foo?country=Italy&From=2020-06-01&to=2020-07-01&date=latest&range=2020-W12&month=2020-06&level=country", nil).URL.Query()
+	result := withoutDates(params)
+	if len(result) != 2 || result.Get("country") != "Italy" || result.Get("level") != "country" {
+		t.Fatalf("Test failed: expected country and level only, got %v", result)
+	}
+	if len(params) != 7 {
+		t.Fatalf("Test failed: params should be left as they are, got %v", params)
+	}
+}
+
+func TestWriteComparison(t *testing.T) {
+	start := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	c := Comparison{Metric: "Confirmed", Threshold: 100, Series: []Aligned{
+		{ID: "1", Address1: "Ontario", Address2: "Canada", Start: &start, Values: map[int]int{2: 180, 0: 100}},
+		{ID: "2", Address2: "Italy", Values: map[int]int{}},
+	}}
+	csvArr := writeComparison(c)
+	if len(csvArr) != 3 || strings.Join(csvArr[0], ",") != "ID,Address,Day,Date,Confirmed" {
+		t.Fatalf("Test failed: expected a header and two rows, got %v", csvArr)
+	}
+	expected := "1,Ontario, Canada,0,2020-03-02,100"
+	if row := strings.Join(csvArr[1], ","); row != expected {
+		t.Fatalf("Test failed: expected %s, got %s", expected, row)
+	}
+	expected = "1,Ontario, Canada,2,2020-03-04,180"
+	if row := strings.Join(csvArr[2], ","); row != expected {
+		t.Fatalf("Test failed: expected %s, got %s", expected, row)
+	}
+}
@@ -0,0 +1,204 @@
+package timeSeries
+
+import (
+	// Built-ins
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// External imports
+	"github.com/go-chi/chi"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	"gitlab.com/csc301-assignments/a2/internal/epi"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Defaults and bounds of the estimate windows, in days
+const (
+	defaultRtWindow       = 7
+	defaultDoublingWindow = 7
+	maxEstimateWindow     = 90
+)
+
+// Estimates holds the epidemiological estimates of a location computed
+// from its confirmed cases, keyed by date like the maps of TimeSeries.
+// Days without an estimate are left out.
+type Estimates struct {
+	ID           string                `json:"ID"`
+	Admin2       string                `json:"Admin2"`
+	Address1     string                `json:"Province/State"`
+	Address2     string                `json:"Country/Region"`
+	Rt           map[time.Time]float64 `json:"Rt"`
+	RtLower      map[time.Time]float64 `json:"RtLower"`
+	RtUpper      map[time.Time]float64 `json:"RtUpper"`
+	DoublingTime map[time.Time]float64 `json:"DoublingTime"`
+}
+
+// EstimateSeries godoc
+// @Summary Estimate Rt and doubling time of a TimeSeries
+// @Description estimates the effective reproduction number (with a 95% credible interval) and the doubling time of each day from the confirmed cases of a location
+// @Tags TimeSeries
+// @Produce  json text/csv
+// @Param id 				path string true ID of the TimeSeries
+// @Param window 			query int false Days Rt is assumed constant over (default 7, at most 90)
+// @Param doubling_window 	query int false Days the growth rate is measured over (default 7, at most 90)
+// @Success 200 {object} Estimates
+// @Failure 400 {string} string "Error status 400"
+// @Failure 404 {string} string "Error status 404"
+// @Failure 500 {string} string "Error status 500"
+// @Router /time_series/{id}/estimates [get]
+func EstimateSeries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.HandleErr(w, 400, errors.New("Invalid input"))
+		return
+	}
+	rtWindow, doublingWindow, status := parseEstimateWindows(r.URL.Query())
+	if status != 0 {
+		utils.HandleErr(w, status, errors.New("Invalid input"))
+		return
+	}
+
+	stored, status, err := readSeries(id, "Confirmed")
+	if err != nil {
+		utils.HandleErr(w, status, err)
+		return
+	}
+	e := Estimates{
+		ID:       strconv.FormatInt(id, 10),
+		Admin2:   stored.admin2,
+		Address1: stored.address1,
+		Address2: stored.address2,
+	}
+	estimate(&e, stored.days, stored.values, rtWindow, doublingWindow)
+
+	// Check 'Accept' type
+	if r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		b := new(bytes.Buffer)
+		writer := csv.NewWriter(b)
+		if err := writer.WriteAll(writeEstimates(e)); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(e); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+}
+
+// Helper functions
+
+// Returns the Rt and doubling time windows of params, or 400
+func parseEstimateWindows(params map[string][]string) (int, int, int) {
+	rtWindow, doublingWindow := defaultRtWindow, defaultDoublingWindow
+	for param, v := range params {
+		n, err := strconv.Atoi(v[0])
+		if err != nil || n <= 0 || n > maxEstimateWindow {
+			return 0, 0, 400
+		}
+		switch strings.ToLower(param) {
+		case "window":
+			rtWindow = n
+		case "doubling_window":
+			doublingWindow = n
+		default:
+			return 0, 0, 400
+		}
+	}
+	return rtWindow, doublingWindow, 0
+}
+
+// Fills e with the estimates of the cumulative confirmed cases values of
+// days (oldest first), rounded to 2 decimals. The methods index days by
+// position, so each run of consecutive days is estimated on its own: days
+// whose window would cross a missing day get no estimate, like those at the
+// start of the series.
+func estimate(e *Estimates, days []time.Time, values []int, rtWindow int, doublingWindow int) {
+	e.Rt, e.RtLower, e.RtUpper = map[time.Time]float64{}, map[time.Time]float64{}, map[time.Time]float64{}
+	e.DoublingTime = map[time.Time]float64{}
+
+	start := 0
+	for i := 1; i <= len(days); i++ {
+		if i == len(days) || !days[i].Equal(days[i-1].AddDate(0, 0, 1)) {
+			estimateRun(e, days[start:i], values[start:i], rtWindow, doublingWindow)
+			start = i
+		}
+	}
+}
+
+// Adds the estimates of values of consecutive days to e
+func estimateRun(e *Estimates, days []time.Time, values []int, rtWindow int, doublingWindow int) {
+	cumulative := make([]float64, len(values))
+	for i, v := range values {
+		cumulative[i] = float64(v)
+	}
+	round := func(x float64) float64 { return math.Round(x*100) / 100 }
+
+	for i, rt := range epi.Rt(epi.Incidence(cumulative), rtWindow) {
+		if !math.IsNaN(rt.Value) {
+			e.Rt[days[i]], e.RtLower[days[i]], e.RtUpper[days[i]] = round(rt.Value), round(rt.Lower), round(rt.Upper)
+		}
+	}
+	for i, d := range epi.DoublingTime(cumulative, doublingWindow) {
+		if !math.IsNaN(d) {
+			e.DoublingTime[days[i]] = round(d)
+		}
+	}
+}
+
+// One row per date with an estimate, oldest first; missing estimates are
+// empty
+func writeEstimates(e Estimates) [][]string {
+	csvArr := [][]string{{"ID", "Address", "Date", "Rt", "RtLower", "RtUpper", "DoublingTime"}}
+	seen := map[time.Time]bool{}
+	estimated := []time.Time{}
+	for _, m := range []map[time.Time]float64{e.Rt, e.DoublingTime} {
+		for date := range m {
+			if !seen[date] {
+				seen[date] = true
+				estimated = append(estimated, date)
+			}
+		}
+	}
+	sort.Slice(estimated, func(i, j int) bool { return estimated[i].Before(estimated[j]) })
+
+	format := func(m map[time.Time]float64, date time.Time) string {
+		if v, ok := m[date]; ok {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return ""
+	}
+	address := writeAddress(TimeSeries{Admin2: e.Admin2, Address1: e.Address1, Address2: e.Address2})
+	for _, date := range estimated {
+		csvArr = append(csvArr, []string{
+			e.ID,
+			address,
+			dates.Format(date),
+			format(e.Rt, date),
+			format(e.RtLower, date),
+			format(e.RtUpper, date),
+			format(e.DoublingTime, date),
+		})
+	}
+	return csvArr
+}
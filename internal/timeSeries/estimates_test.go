@@ -0,0 +1,97 @@
+package timeSeries
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEstimateWindows(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	rtWindow, doublingWindow, status := parseEstimateWindows(r.URL.Query())
+	if status != 0 || rtWindow != defaultRtWindow || doublingWindow != defaultDoublingWindow {
+		t.Fatalf("Test failed: unexpected defaults %d %d %d", rtWindow, doublingWindow, status)
+	}
+
+	r = httptest.NewRequest("GET", "http://example.com/foo?window=14&doubling_window=3", nil)
+	rtWindow, doublingWindow, status = parseEstimateWindows(r.URL.Query())
+	if status != 0 || rtWindow != 14 || doublingWindow != 3 {
+		t.Fatalf("Test failed: unexpected windows %d %d %d", rtWindow, doublingWindow, status)
+	}
+
+	for _, url := range []string{
+		"http://example.com/foo?window=0",
+		"http://example.com/foo?window=week",
+		"http://example.com/foo?doubling_window=91",
+		"http://example.com/foo?metric=death",
+	} {
+		r := httptest.NewRequest("GET", url, nil)
+		if _, _, status := parseEstimateWindows(r.URL.Query()); status != 400 {
+			t.Fatalf("Test failed: expected 400 for %s, got %d", url, status)
+		}
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	// Cases doubling every 5 days
+	days, values := []time.Time{}, []int{}
+	for i := 0; i < 30; i++ {
+		days = append(days, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i))
+		values = append(values, int(math.Round(1000*math.Pow(2, float64(i)/5))))
+	}
+
+	e := Estimates{ID: "1", Address1: "Ontario", Address2: "Canada"}
+	estimate(&e, days, values, 7, 5)
+	if len(e.DoublingTime) != 25 || math.Abs(e.DoublingTime[days[29]]-5) > 0.01 {
+		t.Fatalf("Test failed: expected a doubling time of 5 from day 5 on, got %v", e.DoublingTime)
+	}
+	if _, ok := e.Rt[days[6]]; ok || len(e.Rt) != 23 {
+		t.Fatalf("Test failed: expected Rt from day 7 on, got %v", e.Rt)
+	}
+	last := days[29]
+	if !(e.Rt[last] > 1 && e.RtLower[last] < e.Rt[last] && e.Rt[last] < e.RtUpper[last]) {
+		t.Fatalf("Test failed: expected growth, got %v [%v, %v]", e.Rt[last], e.RtLower[last], e.RtUpper[last])
+	}
+
+	csvArr := writeEstimates(e)
+	if len(csvArr) != 26 || strings.Join(csvArr[0], ",") != "ID,Address,Date,Rt,RtLower,RtUpper,DoublingTime" {
+		t.Fatalf("Test failed: expected a header and 25 rows, got %v", csvArr)
+	}
+	// Doubling time alone, before Rt can be estimated
+	if row := strings.Join(csvArr[1], ","); row != "1,Ontario, Canada,2021-03-06,,,,5" {
+		t.Fatalf("Test failed: unexpected first row %s", row)
+	}
+}
+
+func TestEstimateGap(t *testing.T) {
+	days, values := []time.Time{}, []int{}
+	for i := 0; i < 30; i++ {
+		// No value on March 16
+		if i != 15 {
+			days = append(days, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i))
+			values = append(values, int(math.Round(1000*math.Pow(2, float64(i)/5))))
+		}
+	}
+
+	e := Estimates{ID: "1"}
+	estimate(&e, days, values, 7, 5)
+	// Windows of 5 days fit in March 1-15 from the 6th, and in March 17-30
+	// from the 22nd
+	if len(e.DoublingTime) != 10+9 || math.Abs(e.DoublingTime[days[28]]-5) > 0.01 {
+		t.Fatalf("Test failed: expected doubling times on both sides of the gap, got %v", e.DoublingTime)
+	}
+	for _, day := range []int{16, 17, 18, 19, 20, 21} {
+		date := time.Date(2021, 3, day, 0, 0, 0, 0, time.UTC)
+		if _, ok := e.DoublingTime[date]; ok {
+			t.Fatalf("Test failed: expected no doubling time across the gap on %v", date)
+		}
+		if _, ok := e.Rt[date]; ok {
+			t.Fatalf("Test failed: expected no Rt across the gap on %v", date)
+		}
+	}
+	if _, ok := e.Rt[days[len(days)-1]]; !ok {
+		t.Fatalf("Test failed: expected Rt after the gap, got %v", e.Rt)
+	}
+}
@@ -0,0 +1,319 @@
+package timeSeries
+
+import (
+	// Built-ins
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// External imports
+	"github.com/go-chi/chi"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/forecast"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Series a forecast can be made of
+const (
+	Cumulative = "cumulative"
+	Daily      = "daily"
+)
+
+// Defaults and bounds of the forecast parameters
+const (
+	defaultHorizon = 14
+	maxHorizon     = 365
+	defaultWindow  = 28
+)
+
+// Forecast holds the values a model predicts for the days after the last
+// stored one and their 95% confidence band, keyed by date like the maps of
+// TimeSeries. Observed holds the values the model was fitted to.
+type Forecast struct {
+	ID        string            `json:"ID"`
+	Admin2    string            `json:"Admin2"`
+	Address1  string            `json:"Province/State"`
+	Address2  string            `json:"Country/Region"`
+	Metric    string            `json:"Metric"`
+	Model     string            `json:"Model"`
+	Series    string            `json:"Series"`
+	Observed  map[time.Time]int `json:"Observed"`
+	Predicted map[time.Time]int `json:"Predicted"`
+	Lower     map[time.Time]int `json:"Lower"`
+	Upper     map[time.Time]int `json:"Upper"`
+}
+
+// A forecast request, as parsed by makeForecastQuery
+type forecastQuery struct {
+	metric  string
+	model   string
+	series  string
+	horizon int
+	window  int
+}
+
+// ForecastSeries godoc
+// @Summary Forecast a TimeSeries
+// @Description fits a model to the last values of a location and predicts the next ones, with a 95% confidence band
+// @Tags TimeSeries
+// @Produce  json text/csv
+// @Param id 		path string true ID of the TimeSeries
+// @Param metric 	query string false confirmed (default), death or recovered
+// @Param model 	query string false linear (default), exp or holt
+// @Param series 	query string false cumulative (default) or daily (new cases per day)
+// @Param horizon 	query int false Number of days to predict (default 14, at most 365)
+// @Param window 	query int false Number of last values to fit the model to (default 28)
+// @Success 200 {object} Forecast
+// @Failure 400 {string} string "Error status 400"
+// @Failure 404 {string} string "Error status 404"
+// @Failure 422 {string} string "Error status 422"
+// @Failure 500 {string} string "Error status 500"
+// @Router /time_series/{id}/forecast [get]
+func ForecastSeries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.HandleErr(w, 400, errors.New("Invalid input"))
+		return
+	}
+	q, status := makeForecastQuery(r.URL.Query())
+	if status != 0 {
+		utils.HandleErr(w, status, errors.New("Invalid input"))
+		return
+	}
+
+	stored, status, err := readSeries(id, q.metric)
+	if err != nil {
+		utils.HandleErr(w, status, err)
+		return
+	}
+	f := Forecast{
+		ID:       strconv.FormatInt(id, 10),
+		Admin2:   stored.admin2,
+		Address1: stored.address1,
+		Address2: stored.address2,
+	}
+	days, values := stored.days, stored.values
+
+	if err := q.predict(&f, days, values); err != nil {
+		utils.HandleErrDetail(w, 422, err)
+		return
+	}
+
+	// Check 'Accept' type
+	if r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		b := new(bytes.Buffer)
+		writer := csv.NewWriter(b)
+		if err := writer.WriteAll(writeForecast(f)); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(f); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+}
+
+// Helper functions
+
+// A location and its stored values of a metric, oldest first
+type storedSeries struct {
+	admin2   string
+	address1 string
+	address2 string
+	days     []time.Time
+	values   []int
+}
+
+// Reads the location id and its values of metric (Confirmed, Death or
+// Recovered). Returns the status to respond with on error.
+func readSeries(id int64, metric string) (storedSeries, int, error) {
+	s := storedSeries{}
+	var admin2, address1 sql.NullString
+	err := db.Db.QueryRow("SELECT Admin2, Address1, Address2 FROM TimeSeries WHERE ID = ?", id).
+		Scan(&admin2, &address1, &s.address2)
+	if err == sql.ErrNoRows {
+		return s, 404, errors.New("Not found")
+	}
+	if err != nil {
+		return s, 500, err
+	}
+	s.admin2, s.address1 = admin2.String, address1.String
+
+	rows, err := db.Db.Query(fmt.Sprintf(
+		"SELECT Date, %[1]s FROM TimeSeries%[1]s WHERE ID = ? ORDER BY Date", metric), id)
+	if err != nil {
+		return s, 500, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tsd := TimeSeriesDate{}
+		if err := rows.Scan(&tsd.date, &tsd.cases); err != nil {
+			return s, 500, err
+		}
+		s.days = append(s.days, tsd.date)
+		s.values = append(s.values, tsd.cases)
+	}
+	if err := rows.Err(); err != nil {
+		return s, 500, err
+	}
+	return s, 0, nil
+}
+
+func makeForecastQuery(params map[string][]string) (forecastQuery, int) {
+	q := forecastQuery{
+		metric:  "Confirmed",
+		model:   forecast.Linear,
+		series:  Cumulative,
+		horizon: defaultHorizon,
+		window:  defaultWindow,
+	}
+	for param, v := range params {
+		value := strings.ToLower(v[0])
+		switch strings.ToLower(param) {
+		case "metric":
+			res, ok := utils.HeaderValidate(value)
+			if !ok {
+				return q, 400
+			}
+			q.metric = strings.Title(res)
+		case "model":
+			known := false
+			for _, model := range forecast.Models {
+				known = known || model == value
+			}
+			if !known {
+				return q, 400
+			}
+			q.model = value
+		case "series":
+			if value != Cumulative && value != Daily {
+				return q, 400
+			}
+			q.series = value
+		case "horizon":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 || n > maxHorizon {
+				return q, 400
+			}
+			q.horizon = n
+		case "window":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < forecast.MinPoints {
+				return q, 400
+			}
+			q.window = n
+		default:
+			return q, 400
+		}
+	}
+	return q, 0
+}
+
+// Fits the model to the last values of a cumulative series (stored values,
+// oldest first) and fills f. Daily series are the differences between
+// consecutive days. The values fitted must be of consecutive days, as uploads
+// of partial ranges can leave gaps. Counts cannot go below 0, nor a
+// cumulative count below the last observed one, so bands are cut there.
+func (q forecastQuery) predict(f *Forecast, days []time.Time, values []int) error {
+	f.Metric, f.Model, f.Series = q.metric, q.model, q.series
+	f.Observed = map[time.Time]int{}
+	f.Predicted, f.Lower, f.Upper = map[time.Time]int{}, map[time.Time]int{}, map[time.Time]int{}
+
+	// Daily values need the day before the window too
+	needed := q.window
+	if q.series == Daily {
+		needed++
+	}
+	if len(values) > needed {
+		days, values = days[len(days)-needed:], values[len(values)-needed:]
+	}
+	if err := consecutive(days); err != nil {
+		return err
+	}
+	if q.series == Daily && len(values) > 0 {
+		daily := []int{}
+		for i := 1; i < len(values); i++ {
+			daily = append(daily, values[i]-values[i-1])
+		}
+		days, values = days[1:], daily
+	}
+
+	observed := make([]float64, len(values))
+	for i, v := range values {
+		observed[i] = float64(v)
+		f.Observed[days[i]] = v
+	}
+	points, err := forecast.Fit(q.model, observed, q.horizon)
+	if err != nil {
+		return err
+	}
+
+	floor := 0.0
+	if q.series == Cumulative {
+		floor = observed[len(observed)-1]
+	}
+	last := days[len(days)-1]
+	for h, p := range points {
+		date := last.AddDate(0, 0, h+1)
+		f.Predicted[date] = int(math.Round(math.Max(p.Value, floor)))
+		f.Lower[date] = int(math.Round(math.Max(p.Lower, floor)))
+		f.Upper[date] = int(math.Round(math.Max(p.Upper, floor)))
+	}
+	return nil
+}
+
+// Checks that days (oldest first) follow each other without a gap
+func consecutive(days []time.Time) error {
+	for i := 1; i < len(days); i++ {
+		if !days[i].Equal(days[i-1].AddDate(0, 0, 1)) {
+			return fmt.Errorf("no values between %s and %s", dates.Format(days[i-1]), dates.Format(days[i]))
+		}
+	}
+	return nil
+}
+
+// One row per predicted date, oldest first
+func writeForecast(f Forecast) [][]string {
+	csvArr := [][]string{{"ID", "Address", "Date", "Predicted", "Lower", "Upper"}}
+	predicted := []time.Time{}
+	for date := range f.Predicted {
+		predicted = append(predicted, date)
+	}
+	sort.Slice(predicted, func(i, j int) bool { return predicted[i].Before(predicted[j]) })
+
+	address := writeAddress(TimeSeries{Admin2: f.Admin2, Address1: f.Address1, Address2: f.Address2})
+	for _, date := range predicted {
+		csvArr = append(csvArr, []string{
+			f.ID,
+			address,
+			dates.Format(date),
+			strconv.Itoa(f.Predicted[date]),
+			strconv.Itoa(f.Lower[date]),
+			strconv.Itoa(f.Upper[date]),
+		})
+	}
+	return csvArr
+}
@@ -0,0 +1,129 @@
+package timeSeries
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMakeForecastQuery(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	q, status := makeForecastQuery(r.URL.Query())
+	if status != 0 || q.metric != "Confirmed" || q.model != "linear" || q.series != Cumulative ||
+		q.horizon != defaultHorizon || q.window != defaultWindow {
+		t.Fatalf("Test failed: unexpected defaults %+v %d", q, status)
+	}
+
+	r = httptest.NewRequest("GET", "http://example.com/foo?metric=Death&model=holt&series=daily&horizon=7&window=10", nil)
+	q, status = makeForecastQuery(r.URL.Query())
+	if status != 0 || q.metric != "Death" || q.model != "holt" || q.series != Daily || q.horizon != 7 || q.window != 10 {
+		t.Fatalf("Test failed: unexpected query %+v %d", q, status)
+	}
+
+	for _, url := range []string{
+		"http://example.com/foo?metric=active",
+		"http://example.com/foo?model=arima",
+		"http://example.com/foo?series=weekly",
+		"http://example.com/foo?horizon=0",
+		"http://example.com/foo?horizon=1000",
+		"http://example.com/foo?window=2",
+		"http://example.com/foo?country=Canada",
+	} {
+		r := httptest.NewRequest("GET", url, nil)
+		if _, status := makeForecastQuery(r.URL.Query()); status != 400 {
+			t.Fatalf("Test failed: expected 400 for %s, got %d", url, status)
+		}
+	}
+}
+
+func forecastFixture() ([]time.Time, []int) {
+	days, values := []time.Time{}, []int{}
+	for i := 0; i < 10; i++ {
+		days = append(days, time.Date(2021, 3, 1+i, 0, 0, 0, 0, time.UTC))
+		values = append(values, 100+10*i)
+	}
+	return days, values
+}
+
+func TestPredictCumulative(t *testing.T) {
+	days, values := forecastFixture()
+	q := forecastQuery{metric: "Confirmed", model: "linear", series: Cumulative, horizon: 3, window: 5}
+	f := Forecast{}
+	if err := q.predict(&f, days, values); err != nil {
+		t.Fatalf("Test failed: expected no error, got %v", err)
+	}
+	if len(f.Observed) != 5 || f.Observed[days[5]] != 150 {
+		t.Fatalf("Test failed: expected the last 5 values to be fitted, got %v", f.Observed)
+	}
+	next := time.Date(2021, 3, 11, 0, 0, 0, 0, time.UTC)
+	if len(f.Predicted) != 3 || f.Predicted[next] != 200 || f.Lower[next] != 200 || f.Upper[next] != 200 {
+		t.Fatalf("Test failed: expected 200 on %v, got %v %v %v", next, f.Predicted, f.Lower, f.Upper)
+	}
+}
+
+func TestPredictDaily(t *testing.T) {
+	days, values := forecastFixture()
+	// A drop (i.e. a correction) would make cumulative values decrease
+	values[9] = 50
+	q := forecastQuery{metric: "Death", model: "linear", series: Daily, horizon: 1, window: 28}
+	f := Forecast{}
+	if err := q.predict(&f, days, values); err != nil {
+		t.Fatalf("Test failed: expected no error, got %v", err)
+	}
+	if len(f.Observed) != 9 || f.Observed[days[1]] != 10 || f.Observed[days[9]] != -130 {
+		t.Fatalf("Test failed: expected daily differences, got %v", f.Observed)
+	}
+	for date, v := range f.Lower {
+		if v < 0 || f.Predicted[date] < 0 {
+			t.Fatalf("Test failed: expected no negative prediction, got %v %v", f.Predicted, f.Lower)
+		}
+	}
+
+	if err := q.predict(&f, days[:3], values[:3]); err == nil {
+		t.Fatalf("Test failed: expected an error for 2 daily values")
+	}
+}
+
+func TestPredictGap(t *testing.T) {
+	days, values := forecastFixture()
+	// No value on March 6
+	days, values = append(days[:5:5], days[6:]...), append(values[:5:5], values[6:]...)
+	q := forecastQuery{metric: "Confirmed", model: "linear", series: Cumulative, horizon: 1, window: 28}
+	f := Forecast{}
+	err := q.predict(&f, days, values)
+	if err == nil || err.Error() != "no values between 2021-03-05 and 2021-03-07" {
+		t.Fatalf("Test failed: expected an error for the gap, got %v", err)
+	}
+
+	// A gap before the window is not fitted
+	q.window = 4
+	if err := q.predict(&f, days, values); err != nil {
+		t.Fatalf("Test failed: expected no error, got %v", err)
+	}
+	// Unless daily values need the day before it
+	q.series = Daily
+	if err := q.predict(&f, days, values); err == nil {
+		t.Fatalf("Test failed: expected an error for the gap before the daily window")
+	}
+}
+
+func TestWriteForecast(t *testing.T) {
+	first := time.Date(2021, 3, 11, 0, 0, 0, 0, time.UTC)
+	f := Forecast{
+		ID: "2", Address1: "Ontario", Address2: "Canada",
+		Predicted: map[time.Time]int{first.AddDate(0, 0, 1): 210, first: 200},
+		Lower:     map[time.Time]int{first.AddDate(0, 0, 1): 205, first: 198},
+		Upper:     map[time.Time]int{first.AddDate(0, 0, 1): 215, first: 202},
+	}
+	csvArr := writeForecast(f)
+	for i, expected := range []string{
+		"ID,Address,Date,Predicted,Lower,Upper",
+		"2,Ontario, Canada,2021-03-11,200,198,202",
+		"2,Ontario, Canada,2021-03-12,210,205,215",
+	} {
+		if row := strings.Join(csvArr[i], ","); row != expected {
+			t.Fatalf("Test failed: expected %s, got %s", expected, row)
+		}
+	}
+}
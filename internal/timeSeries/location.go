@@ -0,0 +1,170 @@
+package timeSeries
+
+import (
+	// Built-ins
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Internal imports
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+)
+
+// Location holds what the JHU US files (and, for Lat and Long_, the global
+// ones) tell about a location besides its name. Attributes an upload did
+// not give are left out.
+type Location struct {
+	UID         *int64   `json:"UID,omitempty"`
+	Iso2        string   `json:"iso2,omitempty"`
+	Iso3        string   `json:"iso3,omitempty"`
+	Code3       *int64   `json:"code3,omitempty"`
+	FIPS        *int64   `json:"FIPS,omitempty"`
+	Lat         *float64 `json:"Lat,omitempty"`
+	Long        *float64 `json:"Long_,omitempty"`
+	CombinedKey string   `json:"Combined_Key,omitempty"`
+	Population  *int64   `json:"Population,omitempty"`
+}
+
+// Maps the (lowercase) columns of a time series file to the TimeSeries
+// column they are stored in
+var locationHeaders = map[string]string{
+	"uid":          "UID",
+	"iso2":         "Iso2",
+	"iso3":         "Iso3",
+	"code3":        "Code3",
+	"fips":         "FIPS",
+	"lat":          "Lat",
+	"long":         "Long_",
+	"long_":        "Long_",
+	"combined_key": "CombinedKey",
+	"population":   "Population",
+}
+
+// Columns holding whole numbers. FIPS codes are written as decimals in the
+// JHU files, i.e. 1001.0
+var integerColumns = map[string]bool{"UID": true, "Code3": true, "FIPS": true, "Population": true}
+
+// Returns the location attributes of a row of the file as the columns to
+// set and their values. Empty cells are skipped so that a file lacking an
+// attribute (i.e. Population, only in the deaths file) does not erase it.
+func (u *upload) location(record []string) ([]string, []interface{}, error) {
+	columns := []string{}
+	for column := range u.locationIndex {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	set, args := []string{}, []interface{}{}
+	for _, column := range columns {
+		v := strings.TrimSpace(record[u.locationIndex[column]])
+		if v == "" {
+			continue
+		}
+		var arg interface{} = v
+		if integerColumns[column] || column == "Lat" || column == "Long_" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid %s %q", column, v)
+			}
+			arg = f
+			if integerColumns[column] {
+				arg = int64(f)
+			}
+		}
+		set = append(set, column)
+		args = append(args, arg)
+	}
+	return set, args, nil
+}
+
+// Stores the location attributes of a row against the TimeSeries row id
+func setLocation(id int64, columns []string, args []interface{}) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	assignments := []string{}
+	for _, column := range columns {
+		assignments = append(assignments, column+" = ?")
+	}
+	_, err := db.Db.Exec(fmt.Sprintf("UPDATE TimeSeries SET %s WHERE ID = ?",
+		strings.Join(assignments, ", ")), append(args, id)...)
+	return err
+}
+
+// Reads the stored attributes of location id; nil if it has none
+func locationOf(id string) (*Location, error) {
+	var (
+		uid, code3, fips, population sql.NullInt64
+		iso2, iso3, combinedKey      sql.NullString
+		lat, long                    sql.NullFloat64
+	)
+	err := db.Db.QueryRow(`
+		SELECT UID, Iso2, Iso3, Code3, FIPS, Lat, Long_, CombinedKey, Population
+		FROM TimeSeries WHERE ID = ?
+	`, id).Scan(&uid, &iso2, &iso3, &code3, &fips, &lat, &long, &combinedKey, &population)
+	if err != nil {
+		return nil, err
+	}
+
+	l := Location{
+		UID: intOrNil(uid), Iso2: iso2.String, Iso3: iso3.String, Code3: intOrNil(code3),
+		FIPS: intOrNil(fips), Lat: floatOrNil(lat), Long: floatOrNil(long),
+		CombinedKey: combinedKey.String, Population: intOrNil(population),
+	}
+	if l == (Location{}) {
+		return nil, nil
+	}
+	return &l, nil
+}
+
+func intOrNil(n sql.NullInt64) *int64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int64
+}
+
+func floatOrNil(n sql.NullFloat64) *float64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Float64
+}
+
+// Parses the per_100k parameter and removes it from params, as makeQuery
+// does not know it. Like death, "?per_100k" alone turns it on.
+func parsePer100k(params map[string][]string) (bool, error) {
+	on := false
+	for param, v := range params {
+		if strings.ToLower(param) != "per_100k" {
+			continue
+		}
+		delete(params, param)
+		switch v[0] {
+		case "", "true":
+			on = true
+		case "false":
+			on = false
+		default:
+			return false, fmt.Errorf("invalid per_100k %q", v[0])
+		}
+	}
+	return on, nil
+}
+
+// Values per 100,000 inhabitants, rounded to two decimals. Nil without a
+// known population.
+func per100k(values map[time.Time]int, l *Location) map[time.Time]float64 {
+	if l == nil || l.Population == nil || *l.Population <= 0 {
+		return nil
+	}
+	result := map[time.Time]float64{}
+	for date, v := range values {
+		result[date] = math.Round(float64(v)*100000/float64(*l.Population)*100) / 100
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+package timeSeries
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUploadUSColumns(t *testing.T) {
+	csvFile := "UID,iso2,iso3,code3,FIPS,Admin2,Province_State,Country_Region,Lat,Long_,Combined_Key,Population,1/22/20,1/23/20\n" +
+		`84001001,US,USA,840,1001.0,Autauga,Alabama,US,32.53952745,-86.64408227,"Autauga, Alabama, US",55869,0,1` + "\n" +
+		`84080001,US,USA,840,,Out of AL,Alabama,US,0,0,"Out of AL, Alabama, US",,0,0` + "\n"
+	u, status, err := parseUpload(context.Background(), "death", strings.NewReader(csvFile))
+	if err != nil || status != 0 {
+		t.Fatalf("Test failed: expected no error, got %d %v", status, err)
+	}
+	if u.beginDateIndex != 12 || u.admin2Index != 5 || u.address1Index != 6 || u.address2Index != 7 {
+		t.Fatalf("Test failed: unexpected upload %+v", u)
+	}
+
+	columns, args, err := u.location(u.records[0])
+	if err != nil {
+		t.Fatalf("Test failed: expected no error, got %v", err)
+	}
+	expected := "Code3,CombinedKey,FIPS,Iso2,Iso3,Lat,Long_,Population,UID"
+	if strings.Join(columns, ",") != expected {
+		t.Fatalf("Test failed: expected %s, got %v", expected, columns)
+	}
+	if args[1] != "Autauga, Alabama, US" || args[2] != int64(1001) || args[5] != 32.53952745 || args[7] != int64(55869) {
+		t.Fatalf("Test failed: unexpected values %v", args)
+	}
+
+	// Empty cells leave stored attributes alone
+	columns, _, err = u.location(u.records[1])
+	if err != nil || strings.Contains(strings.Join(columns, ","), "FIPS") || strings.Contains(strings.Join(columns, ","), "Population") {
+		t.Fatalf("Test failed: expected FIPS and Population to be skipped, got %v %v", columns, err)
+	}
+}
+
+func TestLocationInvalidValue(t *testing.T) {
+	u := &upload{locationIndex: map[string]int{"Population": 0}}
+	if _, _, err := u.location([]string{"many"}); err == nil {
+		t.Fatalf("Test failed: expected an error for a non numeric population")
+	}
+}
+
+func TestParsePer100k(t *testing.T) {
+	for url, expected := range map[string]bool{
+		"http://example.com/foo":                false,
+		"http://example.com/foo?per_100k":       true,
+		"http://example.com/foo?Per_100k=true":  true,
+		"http://example.com/foo?per_100k=false": false,
+	} {
+		params := httptest.NewRequest("GET", url, nil).URL.Query()
+		on, err := parsePer100k(params)
+		if err != nil || on != expected {
+			t.Fatalf("Test failed: %s: expected %v, got %v %v", url, expected, on, err)
+		}
+		if len(params) != 0 {
+			t.Fatalf("Test failed: %s: expected per_100k to be removed, got %v", url, params)
+		}
+	}
+
+	params := httptest.NewRequest("GET", "http://example.com/foo?per_100k=yes", nil).URL.Query()
+	if _, err := parsePer100k(params); err == nil {
+		t.Fatalf("Test failed: expected an error for per_100k=yes")
+	}
+}
+
+func TestPer100k(t *testing.T) {
+	date := time.Date(2020, 1, 22, 0, 0, 0, 0, time.UTC)
+	values := map[time.Time]int{date: 25}
+
+	population := int64(55869)
+	result := per100k(values, &Location{Population: &population})
+	if result[date] != 44.75 {
+		t.Fatalf("Test failed: expected 44.75, got %v", result[date])
+	}
+
+	if result := per100k(values, nil); result != nil {
+		t.Fatalf("Test failed: expected nothing without a location, got %v", result)
+	}
+	if result := per100k(values, &Location{}); result != nil {
+		t.Fatalf("Test failed: expected nothing without a population, got %v", result)
+	}
+}
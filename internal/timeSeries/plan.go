@@ -0,0 +1,137 @@
+package timeSeries
+
+import (
+	// Built-ins
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/audit"
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/preview"
+	"gitlab.com/csc301-assignments/a2/internal/quality"
+)
+
+// Works out what store would do to every value of the file, without
+// writing anything. The quality rules run first, as they do before store:
+// corrected values are planned as corrected, and values a rule rejects
+// (which would stop the whole upload with 422) are rejected.
+func (u *upload) plan(cfg quality.Config) (*preview.Plan, error) {
+	anomalies, err := u.check(cfg)
+	if err != nil {
+		return nil, err
+	}
+	rejected := rejections(anomalies)
+
+	plan := preview.New()
+	for _, result := range u.records {
+		ts := TimeSeries{Address2: result[u.address2Index]}
+		if u.admin2Index >= 0 {
+			ts.Admin2 = result[u.admin2Index]
+		}
+		if u.address1Index > 0 {
+			ts.Address1 = result[u.address1Index]
+		}
+
+		var location *int64
+		existing := map[string]int{}
+		id, found, err := findLocation(ts)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			location = &id
+			existing, err = existingValues(id, u.filetype, u.beginDate, u.endDate)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		dateIndex := u.beginDateIndex
+		for date := u.beginDate; !date.After(u.endDate); date = date.AddDate(0, 0, 1) {
+			c := preview.Change{
+				LocationID: location,
+				Admin2:     ts.Admin2,
+				Address1:   ts.Address1,
+				Address2:   ts.Address2,
+				Date:       date,
+				Metric:     u.filetype,
+			}
+			if v, ok := existing[dates.Format(date)]; ok {
+				c.OldValue = audit.Value(v)
+			}
+			if val, err := strconv.Atoi(result[dateIndex]); err != nil {
+				c.Reason = fmt.Sprintf("%q is not a whole number", result[dateIndex])
+			} else {
+				c.NewValue = audit.Value(val)
+			}
+			if reason, ok := rejected[valueKey(ts, date)]; ok {
+				c.Action, c.Reason = preview.Reject, reason
+			}
+			plan.Add(c)
+			dateIndex++
+		}
+	}
+	return plan, nil
+}
+
+// Why the quality rules reject values, by valueKey
+func rejections(anomalies []quality.Anomaly) map[string]string {
+	rejected := map[string]string{}
+	for _, a := range anomalies {
+		if a.Action == quality.Reject {
+			ts := TimeSeries{Admin2: a.Admin2, Address1: a.Address1, Address2: a.Address2}
+			rejected[valueKey(ts, a.Date)] = a.Rule + ": " + a.Detail
+		}
+	}
+	return rejected
+}
+
+func valueKey(ts TimeSeries, date time.Time) string {
+	return writeAddress(ts) + "|" + dates.Format(date)
+}
+
+// Finds the location injectTimeSeries would store ts under. Missing Admin2
+// and Province/State match NULL, as they do there.
+func findLocation(ts TimeSeries) (int64, bool, error) {
+	var id int64
+	err := db.Db.QueryRow(`
+		SELECT ID FROM TimeSeries
+		WHERE IFNULL(Admin2, '') = ? AND IFNULL(Address1, '') = ? AND Address2 = ?
+	`, ts.Admin2, ts.Address1, ts.Address2).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// Stored values of a location between from and to, by date
+func existingValues(id int64, filetype string, from time.Time, to time.Time) (map[string]int, error) {
+	rows, err := db.Db.Query(fmt.Sprintf(`
+		SELECT Date, %[1]s FROM TimeSeries%[1]s
+		WHERE ID = ? AND Date >= ? AND Date <= ?
+	`, filetype), id, dates.Format(from), dates.Format(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[string]int{}
+	for rows.Next() {
+		var (
+			date  time.Time
+			value int
+		)
+		if err := rows.Scan(&date, &value); err != nil {
+			return nil, err
+		}
+		values[dates.Format(date)] = value
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,29 @@
+package timeSeries
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.com/csc301-assignments/a2/internal/quality"
+)
+
+func TestRejections(t *testing.T) {
+	date := time.Date(2021, 3, 8, 0, 0, 0, 0, time.UTC)
+	rejected := rejections([]quality.Anomaly{
+		{Address1: "Ontario", Address2: "Canada", Date: date, Rule: quality.Negative, Action: quality.Reject,
+			Detail: "count is negative"},
+		// Warnings and corrections are stored, so not rejected
+		{Address2: "Italy", Date: date, Rule: quality.Monotonic, Action: quality.Warn},
+		{Address2: "Spain", Date: date, Rule: quality.Outlier, Action: quality.Correct},
+	})
+	if len(rejected) != 1 {
+		t.Fatalf("Test failed: expected a single rejection, got %v", rejected)
+	}
+	reason, ok := rejected[valueKey(TimeSeries{Address1: "Ontario", Address2: "Canada"}, date)]
+	if !ok || reason != "negative: count is negative" {
+		t.Fatalf("Test failed: unexpected rejections %v", rejected)
+	}
+	if _, ok := rejected[valueKey(TimeSeries{Address2: "Canada"}, date)]; ok {
+		t.Fatalf("Test failed: rejection matched another location")
+	}
+}
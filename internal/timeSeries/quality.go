@@ -0,0 +1,93 @@
+package timeSeries
+
+import (
+	// Built-ins
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	"gitlab.com/csc301-assignments/a2/internal/quality"
+)
+
+// Set on uploads stored despite anomalies, with how many were found
+const warningsHeader = "X-Quality-Warnings"
+
+var errRejected = errors.New("Upload rejected by data quality rules")
+
+// Body of a 422 response to a rejected upload
+type rejection struct {
+	Error     string            `json:"Error"`
+	Anomalies []quality.Anomaly `json:"Anomalies"`
+}
+
+// Runs the quality rules over every row of the file. Values corrected by a
+// rule are written back into the records, so store saves them instead.
+// Values that are not whole numbers are left to store to reject.
+func (u *upload) check(cfg quality.Config) ([]quality.Anomaly, error) {
+	anomalies := []quality.Anomaly{}
+	for _, result := range u.records {
+		ts := TimeSeries{Address2: result[u.address2Index]}
+		if u.admin2Index >= 0 {
+			ts.Admin2 = result[u.admin2Index]
+		}
+		if u.address1Index > 0 {
+			ts.Address1 = result[u.address1Index]
+		}
+
+		// The stored day before the file, so its first day is judged too
+		var previous *quality.Point
+		id, found, err := findLocation(ts)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			before := u.beginDate.AddDate(0, 0, -1)
+			existing, err := existingValues(id, u.filetype, before, before)
+			if err != nil {
+				return nil, err
+			}
+			if v, ok := existing[dates.Format(before)]; ok {
+				previous = &quality.Point{Date: before, Value: v}
+			}
+		}
+
+		series := []quality.Point{}
+		indices := map[string]int{}
+		dateIndex := u.beginDateIndex
+		for date := u.beginDate; !date.After(u.endDate); date = date.AddDate(0, 0, 1) {
+			if val, err := strconv.Atoi(result[dateIndex]); err == nil {
+				series = append(series, quality.Point{Date: date, Value: val})
+				indices[dates.Format(date)] = dateIndex
+			}
+			dateIndex++
+		}
+
+		flagged, checked := quality.Check(series, previous, cfg)
+		for _, a := range flagged {
+			if found {
+				a.LocationID = id
+			}
+			a.Admin2, a.Address1, a.Address2 = ts.Admin2, ts.Address1, ts.Address2
+			a.Metric = u.filetype
+			anomalies = append(anomalies, a)
+		}
+		for _, p := range checked {
+			result[indices[dates.Format(p.Date)]] = strconv.Itoa(p.Value)
+		}
+	}
+	return anomalies, nil
+}
+
+// Responds 422 with the anomalies that stopped an upload
+func rejectUpload(w http.ResponseWriter, anomalies []quality.Anomaly) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(422)
+	body := rejection{Error: errRejected.Error(), Anomalies: anomalies}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("Error: ", err)
+	}
+}
@@ -0,0 +1,284 @@
+package timeSeries
+
+import (
+	// Built-ins
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// External imports
+	"github.com/go-chi/chi"
+
+	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/auth"
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/utils"
+)
+
+// Revision is one version of a value, as stored by an upload. Previous is
+// null for the first version of a value.
+type Revision struct {
+	ID         int64     `json:"ID"`
+	Metric     string    `json:"Metric"`
+	Date       time.Time `json:"Date"`
+	Value      int       `json:"Value"`
+	Previous   *int      `json:"Previous"`
+	UploadID   *int64    `json:"UploadID"`
+	RecordedAt time.Time `json:"RecordedAt"`
+}
+
+// Layout of RecordedAt in SQL; stored in UTC
+const timestampLayout = "2006-01-02 15:04:05.000000"
+
+// Revisions godoc
+// @Summary List the revisions of a TimeSeries
+// @Description every version of each value of a location, oldest first
+// @Tags TimeSeries
+// @Produce  json text/csv
+// @Param id 		path string true ID of the TimeSeries
+// @Param metric 	query string false confirmed, death or recovered; Allow multiple inputs, separated by a comma ',' (with no space)
+// @Param date 		query string false Date of the revised values; Allow multiple inputs, separated by a comma ',' (with no space)
+// @Success 200 {array} Revision
+// @Failure 400 {string} string "Error status 400"
+// @Failure 404 {string} string "Error status 404"
+// @Failure 500 {string} string "Error status 500"
+// @Router /time_series/{id}/revisions [get]
+func Revisions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.HandleErr(w, 400, errors.New("Invalid input"))
+		return
+	}
+
+	query, args, status := makeRevisionQuery(id, r.URL.Query())
+	if status != 0 {
+		utils.HandleErr(w, status, errors.New("Invalid input"))
+		return
+	}
+
+	var exists bool
+	err = db.Db.QueryRow("SELECT EXISTS(SELECT 1 FROM TimeSeries WHERE ID = ?)", id).Scan(&exists)
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+	if !exists {
+		utils.HandleErr(w, 404, errors.New("Not found"))
+		return
+	}
+
+	rows, err := db.Db.Query(query, args...)
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		rev := Revision{}
+		var uploadID sql.NullInt64
+		err := rows.Scan(&rev.ID, &rev.Metric, &rev.Date, &rev.Value, &uploadID, &rev.RecordedAt)
+		if err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if uploadID.Valid {
+			rev.UploadID = &uploadID.Int64
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+	linkPrevious(revisions)
+
+	// Check 'Accept' type
+	if r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		b := new(bytes.Buffer)
+		writer := csv.NewWriter(b)
+		if err := writer.WriteAll(writeRevisions(revisions)); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(revisions); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+}
+
+// Stores a new version of a value. Called for every value an upload
+// changes, so the latest revision always matches TimeSeries<Type>.
+func recordRevision(ctx context.Context, id int64, filetype string, date time.Time, value int) error {
+	var uploadID interface{}
+	if upload := auth.UploadID(ctx); upload != 0 {
+		uploadID = upload
+	}
+	_, err := db.Db.Exec(`
+		INSERT INTO TimeSeriesRevisions(ID, Type, Date, Value, UploadID, RecordedAt)
+		VALUES(?,?,?,?,?,?)
+	`, id, filetype, date, value, uploadID, time.Now().UTC().Format(timestampLayout))
+	return err
+}
+
+// Parses the as_of parameter: an RFC 3339 timestamp, or a date meaning
+// midnight UTC of that day. Returns nil if absent.
+func parseAsOf(params map[string][]string) (*time.Time, error) {
+	var value string
+	for param, v := range params {
+		if strings.ToLower(param) == "as_of" {
+			value = v[0]
+		}
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if t, err = dates.Parse(value); err != nil {
+			return nil, err
+		}
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// Returns the table to read values of typeStr from. Given asOf, it is a
+// derived table of the last revision of each value recorded by then, with
+// the same columns as TimeSeries<Type>.
+func valueSource(typeStr string, asOf *time.Time) string {
+	if asOf == nil {
+		return "TimeSeries" + typeStr
+	}
+	at := asOf.Format(timestampLayout)
+	return fmt.Sprintf(`(
+			SELECT r.ID, r.Date, r.Value AS %[1]s FROM TimeSeriesRevisions r
+			WHERE r.Type = '%[1]s' AND r.RevisionID = (
+				SELECT MAX(r2.RevisionID) FROM TimeSeriesRevisions r2
+				WHERE r2.ID = r.ID AND r2.Type = r.Type AND r2.Date = r.Date
+				AND r2.RecordedAt <= '%[2]s'
+			)
+		) AS TimeSeries%[1]s`, typeStr, at)
+}
+
+// Builds the query listing the revisions of a location, oldest first.
+// Accepts metric (confirmed, death, recovered) and the date parameters.
+func makeRevisionQuery(id int64, params map[string][]string) (string, []interface{}, int) {
+	query := `
+		SELECT RevisionID, Type, Date, Value, UploadID, RecordedAt
+		FROM TimeSeriesRevisions
+		WHERE ID = ?`
+	args := []interface{}{id}
+	latest := utils.LatestDate("TimeSeriesRevisions")
+
+	// Sorted to make the query deterministic
+	keys := []string{}
+	for param := range params {
+		keys = append(keys, param)
+	}
+	sort.Strings(keys)
+
+	for _, param := range keys {
+		values := strings.Split(params[param][0], ",")
+		param = strings.ToLower(param)
+		alternatives := []string{}
+
+		if param == "metric" {
+			for _, v := range values {
+				metric, ok := utils.HeaderValidate(strings.ToLower(v))
+				if !ok {
+					return "", nil, 400
+				}
+				alternatives = append(alternatives, "Type=?")
+				args = append(args, strings.Title(metric))
+			}
+			query += " AND (" + strings.Join(alternatives, " OR ") + ")"
+			continue
+		}
+
+		column, valid := utils.ParamValidate(param)
+		if !valid {
+			return "", nil, 400
+		}
+		op := "="
+		switch column {
+		case "from":
+			op = ">="
+		case "to":
+			op = "<="
+		case "date", "range", "month":
+		default:
+			return "", nil, 400
+		}
+		for _, v := range values {
+			rng, err := dates.Resolve(v, latest)
+			if err != nil {
+				return "", nil, 400
+			}
+			alternatives = append(alternatives, utils.DateCondition("Date", op, rng))
+		}
+		query += " AND (" + strings.Join(alternatives, " OR ") + ")"
+	}
+
+	query += "\n\t\tORDER BY RevisionID"
+	return query, args, 0
+}
+
+// Fills Previous from the revision before each one of the same value
+func linkPrevious(revisions []Revision) {
+	last := map[string]int{}
+	for i, rev := range revisions {
+		key := rev.Metric + dates.Format(rev.Date)
+		if v, ok := last[key]; ok {
+			previous := v
+			revisions[i].Previous = &previous
+		}
+		last[key] = rev.Value
+	}
+}
+
+func writeRevisions(revisions []Revision) [][]string {
+	csvArr := [][]string{{"ID", "Metric", "Date", "Value", "Previous", "UploadID", "RecordedAt"}}
+	for _, rev := range revisions {
+		previous, upload := "", ""
+		if rev.Previous != nil {
+			previous = strconv.Itoa(*rev.Previous)
+		}
+		if rev.UploadID != nil {
+			upload = strconv.FormatInt(*rev.UploadID, 10)
+		}
+		csvArr = append(csvArr, []string{
+			strconv.FormatInt(rev.ID, 10),
+			rev.Metric,
+			dates.Format(rev.Date),
+			strconv.Itoa(rev.Value),
+			previous,
+			upload,
+			rev.RecordedAt.Format(time.RFC3339),
+		})
+	}
+	return csvArr
+}
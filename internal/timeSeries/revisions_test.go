@@ -0,0 +1,126 @@
+package timeSeries
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAsOf(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo?as_of=2021-03-01T05:30:00%2B05:00", nil)
+	asOf, err := parseAsOf(r.URL.Query())
+	if err != nil || asOf == nil {
+		t.Fatalf("Test failed: expected a timestamp, got %v %v", asOf, err)
+	}
+	expected := time.Date(2021, 3, 1, 0, 30, 0, 0, time.UTC)
+	if !asOf.Equal(expected) || asOf.Location() != time.UTC {
+		t.Fatalf("Test failed: expected %v, got %v", expected, asOf)
+	}
+
+	// A plain date is midnight UTC
+	r = httptest.NewRequest("GET", "http://example.com/foo?as_of=2021-03-01", nil)
+	asOf, err = parseAsOf(r.URL.Query())
+	if err != nil || !asOf.Equal(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Test failed: expected midnight, got %v %v", asOf, err)
+	}
+
+	// Absent
+	r = httptest.NewRequest("GET", "http://example.com/foo?country=Canada", nil)
+	if asOf, err = parseAsOf(r.URL.Query()); asOf != nil || err != nil {
+		t.Fatalf("Test failed: expected nil, got %v %v", asOf, err)
+	}
+
+	r = httptest.NewRequest("GET", "http://example.com/foo?as_of=yesterday", nil)
+	if _, err = parseAsOf(r.URL.Query()); err == nil {
+		t.Fatalf("Test failed: expected an error")
+	}
+}
+
+func TestMakeQueryIgnoresAsOf(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo?as_of=2021-03-01&country=Canada", nil)
+	query, _, _, _, status := makeQuery(r.URL.Query())
+	if status != 0 {
+		t.Fatalf("Test failed: expected status 0, got %d", status)
+	}
+	if strings.Contains(query, "as_of") || !strings.Contains(query, "address2='Canada'") {
+		t.Fatalf("Test failed: unexpected query %s", query)
+	}
+
+	r = httptest.NewRequest("GET", "http://example.com/foo?as_of=yesterday&date=latest", nil)
+	if _, _, _, _, status := makeQuery(r.URL.Query()); status != 400 {
+		t.Fatalf("Test failed: expected 400 for an invalid as_of, got %d", status)
+	}
+}
+
+func TestValueSource(t *testing.T) {
+	if res := valueSource("Death", nil); res != "TimeSeriesDeath" {
+		t.Fatalf("Test failed: expected TimeSeriesDeath, got %s", res)
+	}
+
+	asOf := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	res := valueSource("Death", &asOf)
+	for _, checker := range []string{
+		"r.Value AS Death",
+		"r.Type = 'Death'",
+		"r2.RecordedAt <= '2021-03-01 12:00:00.000000'",
+		"AS TimeSeriesDeath",
+	} {
+		if !strings.Contains(res, checker) {
+			t.Fatalf("Test failed: %s does not contain %s", res, checker)
+		}
+	}
+}
+
+func TestMakeRevisionQuery(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo?metric=death,Recovered&from=2021-03-01", nil)
+	query, args, status := makeRevisionQuery(2, r.URL.Query())
+	if status != 0 {
+		t.Fatalf("Test failed: expected status 0, got %d", status)
+	}
+	checker := `WHERE ID = ? AND (Date>="2021-03-01") AND (Type=? OR Type=?)`
+	if !strings.Contains(query, checker) {
+		t.Fatalf("Test failed: %s does not contain %s", query, checker)
+	}
+	if !strings.HasSuffix(query, "ORDER BY RevisionID") {
+		t.Fatalf("Test failed: revisions should be oldest first, got %s", query)
+	}
+	if len(args) != 3 || args[0] != int64(2) || args[1] != "Death" || args[2] != "Recovered" {
+		t.Fatalf("Test failed: unexpected args %v", args)
+	}
+
+	for _, url := range []string{
+		"http://example.com/foo?metric=active",
+		"http://example.com/foo?country=Canada",
+		"http://example.com/foo?date=2/30/21",
+		"http://example.com/foo?abc=def",
+	} {
+		r := httptest.NewRequest("GET", url, nil)
+		if _, _, status := makeRevisionQuery(2, r.URL.Query()); status != 400 {
+			t.Fatalf("Test failed: expected 400 for %s, got %d", url, status)
+		}
+	}
+}
+
+func TestLinkPrevious(t *testing.T) {
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	revisions := []Revision{
+		{ID: 1, Metric: "Confirmed", Date: day, Value: 10},
+		{ID: 2, Metric: "Death", Date: day, Value: 1},
+		{ID: 3, Metric: "Confirmed", Date: day, Value: 12},
+		{ID: 4, Metric: "Confirmed", Date: day.AddDate(0, 0, 1), Value: 15},
+	}
+	linkPrevious(revisions)
+
+	if revisions[0].Previous != nil || revisions[1].Previous != nil || revisions[3].Previous != nil {
+		t.Fatalf("Test failed: first versions should have no previous value")
+	}
+	if revisions[2].Previous == nil || *revisions[2].Previous != 10 {
+		t.Fatalf("Test failed: expected previous value 10, got %v", revisions[2].Previous)
+	}
+
+	csvArr := writeRevisions(revisions)
+	if len(csvArr) != 5 || csvArr[3][4] != "10" || csvArr[1][4] != "" {
+		t.Fatalf("Test failed: unexpected CSV %v", csvArr)
+	}
+}
@@ -0,0 +1,145 @@
+package timeSeries
+
+import (
+	// Built-ins
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Levels time series can be rolled up to
+const (
+	LevelProvince = "province"
+	LevelCountry  = "country"
+)
+
+// Parses the level parameter and removes it from params, as makeQuery does
+// not know it. Returns "" if absent.
+func parseLevel(params map[string][]string) (string, error) {
+	level := ""
+	for param, v := range params {
+		if strings.ToLower(param) != "level" {
+			continue
+		}
+		delete(params, param)
+		switch strings.ToLower(v[0]) {
+		case LevelProvince, "state":
+			level = LevelProvince
+		case LevelCountry, "region":
+			level = LevelCountry
+		default:
+			return "", fmt.Errorf("invalid level %q", v[0])
+		}
+	}
+	return level, nil
+}
+
+// Tells whether ts is the total of a province, i.e. a province row (no
+// Admin2) next to its counties
+func provinceTotal(ts TimeSeries) bool {
+	return ts.Admin2 == "" && ts.Address1 != ""
+}
+
+// Tells whether ts comes from the JHU US files, the only ones with a UID
+func fromUSFiles(ts TimeSeries) bool {
+	return ts.Location != nil && ts.Location.UID != nil
+}
+
+// Leaves out the series from the JHU US files of countries that also have a
+// country row from the global files (i.e. US and its counties, when both
+// layouts are loaded), as that row is their total
+func withoutBreakdowns(series []TimeSeries) []TimeSeries {
+	totals := map[string]bool{}
+	for _, ts := range series {
+		if ts.Admin2 == "" && ts.Address1 == "" && !fromUSFiles(ts) {
+			totals[ts.Address2] = true
+		}
+	}
+	result := []TimeSeries{}
+	for _, ts := range series {
+		if totals[ts.Address2] && fromUSFiles(ts) {
+			continue
+		}
+		result = append(result, ts)
+	}
+	return result
+}
+
+// RollUp sums the values of typeStr of the series under each province or
+// country, per date. At the province level, a province row is taken as the
+// total of its counties, which are then left out so nothing is counted
+// twice; counties are only summed when there is no such total. Countries are
+// summed from their provinces, rolled up the same way first, along with the
+// country row if there is one: in the JHU global files, a country row next to
+// provinces (i.e. the United Kingdom and its overseas territories) holds the
+// rest of the country, not its total. The series of the JHU US files are a
+// breakdown of the global US row though, so they are left out next to it
+// (see withoutBreakdowns). Locations that report above the level (i.e. a
+// country without provinces, at the province level) are kept as they are.
+// Aggregates have no ID; they have a Population when all of their parts do.
+func RollUp(series []TimeSeries, level string, typeStr string) []TimeSeries {
+	if level == LevelCountry {
+		series = RollUp(withoutBreakdowns(series), LevelProvince, typeStr)
+	}
+
+	groups := map[string][]TimeSeries{}
+	keys := []string{}
+	for _, ts := range series {
+		key := ts.Address2
+		if level == LevelProvince {
+			key += "\x00" + ts.Address1
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], ts)
+	}
+	sort.Strings(keys)
+
+	result := []TimeSeries{}
+	for _, key := range keys {
+		members := groups[key]
+		if level == LevelProvince {
+			totals := []TimeSeries{}
+			for _, ts := range members {
+				if provinceTotal(ts) {
+					totals = append(totals, ts)
+				}
+			}
+			if len(totals) > 0 {
+				members = totals
+			}
+		}
+
+		agg := TimeSeries{Address2: members[0].Address2}
+		if level == LevelProvince {
+			agg.Address1 = members[0].Address1
+		}
+		values := map[time.Time]int{}
+		population, known := int64(0), true
+		for _, ts := range members {
+			for date, v := range metricValues(ts, typeStr) {
+				values[date] += v
+			}
+			if ts.Location == nil || ts.Location.Population == nil {
+				known = false
+			} else {
+				population += *ts.Location.Population
+			}
+		}
+		if known {
+			agg.Location = &Location{Population: &population}
+		}
+		switch typeStr {
+		case "Confirmed":
+			agg.Confirmed = values
+		case "Death":
+			agg.Death = values
+		default:
+			agg.Recovered = values
+		}
+		result = append(result, agg)
+	}
+	return result
+}
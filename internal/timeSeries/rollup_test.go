@@ -0,0 +1,165 @@
+package timeSeries
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+	for url, expected := range map[string]string{
+		"http://example.com/foo":                "",
+		"http://example.com/foo?level=province": LevelProvince,
+		"http://example.com/foo?level=State":    LevelProvince,
+		"http://example.com/foo?Level=country":  LevelCountry,
+		"http://example.com/foo?level=region":   LevelCountry,
+	} {
+		params := httptest.NewRequest("GET", url, nil).URL.Query()
+		level, err := parseLevel(params)
+		if err != nil || level != expected {
+			t.Fatalf("Test failed: %s: expected %q, got %q %v", url, expected, level, err)
+		}
+		if len(params) != 0 {
+			t.Fatalf("Test failed: %s: expected level to be removed, got %v", url, params)
+		}
+	}
+
+	params := httptest.NewRequest("GET", "http://example.com/foo?level=county", nil).URL.Query()
+	if _, err := parseLevel(params); err == nil {
+		t.Fatalf("Test failed: expected an error for level=county")
+	}
+}
+
+func rollUpFixture() []TimeSeries {
+	day1 := time.Date(2020, 1, 22, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	population := func(n int64) *Location { return &Location{Population: &n} }
+	return []TimeSeries{
+		{ID: "1", Admin2: "Autauga", Address1: "Alabama", Address2: "US",
+			Confirmed: map[time.Time]int{day1: 1, day2: 2}, Location: population(100)},
+		{ID: "2", Admin2: "Baldwin", Address1: "Alabama", Address2: "US",
+			Confirmed: map[time.Time]int{day1: 10, day2: 20}, Location: population(200)},
+		{ID: "3", Admin2: "Kings", Address1: "New York", Address2: "US",
+			Confirmed: map[time.Time]int{day1: 5, day2: 6}},
+		// A province total next to one of its counties
+		{ID: "4", Address1: "Ontario", Address2: "Canada",
+			Confirmed: map[time.Time]int{day1: 100, day2: 110}},
+		{ID: "5", Admin2: "Toronto", Address1: "Ontario", Address2: "Canada",
+			Confirmed: map[time.Time]int{day1: 40, day2: 45}},
+		{ID: "6", Address1: "Quebec", Address2: "Canada",
+			Confirmed: map[time.Time]int{day1: 50, day2: 55}},
+		// Only reported at country level
+		{ID: "7", Address2: "Italy",
+			Confirmed: map[time.Time]int{day1: 7, day2: 8}},
+	}
+}
+
+func TestRollUpProvince(t *testing.T) {
+	day1 := time.Date(2020, 1, 22, 0, 0, 0, 0, time.UTC)
+	result := RollUp(rollUpFixture(), LevelProvince, "Confirmed")
+
+	expected := map[string]int{"Alabama": 11, "New York": 5, "Ontario": 100, "Quebec": 50, "": 7}
+	if len(result) != len(expected) {
+		t.Fatalf("Test failed: expected %d provinces, got %+v", len(expected), result)
+	}
+	for _, ts := range result {
+		if ts.ID != "" || ts.Admin2 != "" {
+			t.Fatalf("Test failed: expected an aggregate without ID nor Admin2, got %+v", ts)
+		}
+		if ts.Confirmed[day1] != expected[ts.Address1] {
+			t.Fatalf("Test failed: %s: expected %d, got %d", ts.Address1, expected[ts.Address1], ts.Confirmed[day1])
+		}
+	}
+
+	// Ordered by country then province
+	if result[0].Address1 != "Ontario" || result[2].Address2 != "Italy" || result[3].Address1 != "Alabama" {
+		t.Fatalf("Test failed: unexpected order %+v", result)
+	}
+
+	// Populations add up only when all parts have one
+	alabama, newYork := result[3], result[4]
+	if alabama.Location == nil || *alabama.Location.Population != 300 || newYork.Location != nil {
+		t.Fatalf("Test failed: unexpected populations %+v %+v", alabama.Location, newYork.Location)
+	}
+}
+
+func TestRollUpCountry(t *testing.T) {
+	day2 := time.Date(2020, 1, 23, 0, 0, 0, 0, time.UTC)
+	result := RollUp(rollUpFixture(), LevelCountry, "Confirmed")
+
+	expected := map[string]int{"Canada": 165, "Italy": 8, "US": 28}
+	if len(result) != len(expected) {
+		t.Fatalf("Test failed: expected %d countries, got %+v", len(expected), result)
+	}
+	for _, ts := range result {
+		if ts.Address1 != "" || ts.Confirmed[day2] != expected[ts.Address2] {
+			t.Fatalf("Test failed: %s: expected %d, got %+v", ts.Address2, expected[ts.Address2], ts)
+		}
+	}
+}
+
+// Like the United Kingdom in JHU data, a country row next to provinces holds
+// the rest of the country, so both are summed
+func TestRollUpCountryRowWithProvinces(t *testing.T) {
+	day1 := time.Date(2020, 1, 22, 0, 0, 0, 0, time.UTC)
+	series := append(rollUpFixture(),
+		TimeSeries{ID: "8", Address2: "United Kingdom", Confirmed: map[time.Time]int{day1: 1000}},
+		TimeSeries{ID: "9", Address1: "Bermuda", Address2: "United Kingdom", Confirmed: map[time.Time]int{day1: 10}},
+		TimeSeries{ID: "10", Address1: "Cayman Islands", Address2: "United Kingdom", Confirmed: map[time.Time]int{day1: 5}},
+	)
+
+	found := false
+	for _, ts := range RollUp(series, LevelCountry, "Confirmed") {
+		if ts.Address2 == "United Kingdom" {
+			found = true
+			if ts.Confirmed[day1] != 1015 {
+				t.Fatalf("Test failed: expected the country row and its provinces, got %d", ts.Confirmed[day1])
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("Test failed: expected the United Kingdom to be rolled up")
+	}
+
+	// At the province level, the country row is kept as it is
+	provinces := RollUp(series, LevelProvince, "Confirmed")
+	rows := map[string]int{}
+	for _, ts := range provinces {
+		if ts.Address2 == "United Kingdom" {
+			rows[ts.Address1] = ts.Confirmed[day1]
+		}
+	}
+	if len(rows) != 3 || rows[""] != 1000 || rows["Bermuda"] != 10 {
+		t.Fatalf("Test failed: unexpected provinces %v", rows)
+	}
+}
+
+// With both JHU layouts loaded, the US row of the global files is the total
+// of the counties of the US files, so they are not added to it
+func TestRollUpCountryRowWithUSCounties(t *testing.T) {
+	day1 := time.Date(2020, 1, 22, 0, 0, 0, 0, time.UTC)
+	fromUSFiles := func(uid int64) *Location { return &Location{UID: &uid} }
+	series := []TimeSeries{
+		{ID: "1", Address2: "US", Confirmed: map[time.Time]int{day1: 120}},
+		{ID: "2", Admin2: "Autauga", Address1: "Alabama", Address2: "US",
+			Confirmed: map[time.Time]int{day1: 100}, Location: fromUSFiles(84001001)},
+		{ID: "3", Admin2: "Kings", Address1: "New York", Address2: "US",
+			Confirmed: map[time.Time]int{day1: 20}, Location: fromUSFiles(84036047)},
+	}
+
+	result := RollUp(series, LevelCountry, "Confirmed")
+	if len(result) != 1 || result[0].Confirmed[day1] != 120 {
+		t.Fatalf("Test failed: expected the US row alone, got %+v", result)
+	}
+
+	// Without the global row, the counties are summed
+	result = RollUp(series[1:], LevelCountry, "Confirmed")
+	if len(result) != 1 || result[0].Confirmed[day1] != 120 {
+		t.Fatalf("Test failed: expected the sum of the counties, got %+v", result)
+	}
+
+	// At the province level, both are kept
+	if result := RollUp(series, LevelProvince, "Confirmed"); len(result) != 3 {
+		t.Fatalf("Test failed: expected the US row and two states, got %+v", result)
+	}
+}
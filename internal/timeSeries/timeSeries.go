@@ -4,6 +4,7 @@ import (
 	// Built-ins
 
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
@@ -11,6 +12,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -19,7 +21,14 @@ import (
 	"github.com/go-chi/chi"
 
 	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/audit"
+	"gitlab.com/csc301-assignments/a2/internal/auth"
+	"gitlab.com/csc301-assignments/a2/internal/dates"
 	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/idempotency"
+	"gitlab.com/csc301-assignments/a2/internal/jobs"
+	"gitlab.com/csc301-assignments/a2/internal/preview"
+	"gitlab.com/csc301-assignments/a2/internal/quality"
 	"gitlab.com/csc301-assignments/a2/internal/utils"
 )
 
@@ -32,6 +41,11 @@ type TimeSeries struct {
 	Confirmed map[time.Time]int `json:"Confirmed"`
 	Death     map[time.Time]int `json:"Death"`
 	Recovered map[time.Time]int `json:"Recovered"`
+
+	// Set if the location was uploaded with attributes, i.e. from a US file
+	Location *Location `json:"Location,omitempty"`
+	// The listed metric per 100,000 inhabitants, with per_100k=true
+	Per100k map[time.Time]float64 `json:"Per100k,omitempty"`
 }
 
 type TimeSeriesDate struct {
@@ -42,7 +56,11 @@ type TimeSeriesDate struct {
 func Routes() chi.Router {
 	r := chi.NewRouter()
 	r.Get("/", List)
-	r.Post("/", Create)
+	r.With(idempotency.Middleware("time_series")).Post("/", Create)
+	r.Get("/compare", Compare)
+	r.Get("/{id}/revisions", Revisions)
+	r.Get("/{id}/forecast", ForecastSeries)
+	r.Get("/{id}/estimates", EstimateSeries)
 
 	return r
 }
@@ -57,36 +75,125 @@ func Routes() chi.Router {
 // @Param province 	query string false Allow multiple inputs, separated by a comma ',' (with no space)
 // @Param state 	query string false Allow multiple inputs, separated by a comma ',' (with no space)
 // @Param country 	query string false Allow multiple inputs, separated by a comma ',' (with no space)
-// @Param date 		query string false Must be in (mm/dd/yy) format; Allow multiple inputs, separated by a comma ',' (with no space)
-// @Param from 		query string false Must be in (mm/dd/yy) format; Allow multiple inputs, separated by a comma ',' (with no space)
-// @Param to 		query string false Must be in (mm/dd/yy) format; Allow multiple inputs, separated by a comma ',' (with no space)
+// @Param date 		query string false Must be in (yyyy-mm-dd), (mm/dd/yy) or (mm/dd/yyyy) format; Allow multiple inputs, separated by a comma ',' (with no space)
+// @Param from 		query string false Must be in (yyyy-mm-dd), (mm/dd/yy) or (mm/dd/yyyy) format; Allow multiple inputs, separated by a comma ',' (with no space)
+// @Param to 		query string false Must be in (yyyy-mm-dd), (mm/dd/yy) or (mm/dd/yyyy) format; Allow multiple inputs, separated by a comma ',' (with no space)
 // @Param death 	query bool false Is mutually exclusive with recovered; Can be used without specifying the value ("?death" is ok)
 // @Param recovered query bool false Is mutually exclusive with death; Can be used without specifying the value ("?recovered" is ok)
+// @Param level query string false Sum the series of each "province" or "country" per date
+// @Param per_100k query bool false Also return values per 100,000 inhabitants of locations with a known population; Can be used without specifying the value ("?per_100k" is ok)
 // @Success 200 {array} TimeSeries
 // @Failure 400 {string} string "Error status 400"
 // @Failure 500 {string} string "Error status 500"
 // @Router /time_series [get]
 func List(w http.ResponseWriter, r *http.Request) {
-	query, dates, death, recovered, status := makeQuery(r.URL.Query())
-	if status == 400 {
-		utils.HandleErr(w, 400, errors.New("Invalid input"))
+	l, status, err := load(r.URL.Query())
+	if err != nil {
+		utils.HandleErr(w, status, err)
 		return
 	}
+	tsArr, typeStr, death, recovered, perPopulation := l.series, l.typeStr, l.death, l.recovered, l.perPopulation
+
+	// Check 'Accept' type
+	if r.Header.Get("Accept") == "text/csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		b := new(bytes.Buffer)
+		writer := csv.NewWriter(b)
+		csvArr := [][]string{}
+		header := writeHeader(death, recovered)
+		if perPopulation {
+			header = append(header, "Per100k")
+		}
+		csvArr = append(csvArr, header)
+
+		// Writing response in CSV
+		for _, ts := range tsArr {
+			data := metricValues(ts, typeStr)
+
+			// Create a row
+			for date := range data {
+				row := []string{
+					ts.ID,
+					writeAddress(ts),
+					dates.Format(date),
+				}
+				row = append(row, writeRow(ts, date, death, recovered)...)
+				if perPopulation {
+					row = append(row, writePer100k(ts, date))
+				}
+				csvArr = append(csvArr, row)
+			}
+		}
+		// Write to buffer
+		if err := writer.WriteAll(csvArr); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+		// Write to response
+		if _, err := w.Write(b.Bytes()); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	} else {
+		// Writing response in JSON
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(tsArr); err != nil {
+			utils.HandleErr(w, 500, err)
+			return
+		}
+	}
+
+	// Successfully written the data
+	w.WriteHeader(200)
+}
+
+// Series matching the parameters of List, with their values
+type listing struct {
+	series        []TimeSeries
+	typeStr       string
+	death         bool
+	recovered     bool
+	perPopulation bool
+}
+
+// Loads the series matching params and their values of the requested
+// metric, one query per series, then rolls them up and computes per 100k
+// values if asked. On error, the status is the one to respond with.
+func load(params url.Values) (listing, int, error) {
+	perPopulation, err := parsePer100k(params)
+	if err != nil {
+		return listing{}, 400, errors.New("Invalid input")
+	}
+	// Sums per province or country, i.e. level=province
+	level, err := parseLevel(params)
+	if err != nil {
+		return listing{}, 400, errors.New("Invalid input")
+	}
+
+	query, dateClause, death, recovered, status := makeQuery(params)
+	if status == 400 {
+		return listing{}, 400, errors.New("Invalid input")
+	}
+
+	// Values as they were known at that moment, i.e. as_of=2021-03-01T00:00:00Z
+	asOf, err := parseAsOf(params)
+	if err != nil {
+		return listing{}, 400, errors.New("Invalid input")
+	}
 
 	typeStr := getType(death, recovered)
 
 	stmt, err := db.Db.Prepare(query)
 	if err != nil {
-		utils.HandleErr(w, 500, err)
-		return
+		return listing{}, 500, err
 	}
 
 	defer stmt.Close()
 
 	row, err := stmt.Query()
 	if err != nil {
-		utils.HandleErr(w, 500, err)
-		return
+		return listing{}, 500, err
 	}
 	defer row.Close()
 
@@ -105,8 +212,7 @@ func List(w http.ResponseWriter, r *http.Request) {
 		err := row.Scan(temp["id"], temp["admin2"],
 			temp["address1"], temp["address2"])
 		if err != nil {
-			utils.HandleErr(w, 500, err)
-			return
+			return listing{}, 500, err
 		}
 		nullHandler(&ts, temp)
 
@@ -124,24 +230,28 @@ func List(w http.ResponseWriter, r *http.Request) {
 
 	// Filling maps
 	columns := fmt.Sprintf("Date, %s", typeStr)
-	for _, ts := range tsArr {
+	for i := range tsArr {
+		ts := &tsArr[i]
+		ts.Location, err = locationOf(ts.ID)
+		if err != nil {
+			return listing{}, 500, err
+		}
+
 		// Querying from db
 		query := fmt.Sprintf(`
-			SELECT %s FROM TimeSeries%s
+			SELECT %s FROM %s
 			WHERE ID = %s %s
-		`, columns, typeStr, ts.ID, dates)
+		`, columns, valueSource(typeStr, asOf), ts.ID, dateClause)
 
 		stmt, err := db.Db.Prepare(query)
 		if err != nil {
-			utils.HandleErr(w, 500, err)
-			return
+			return listing{}, 500, err
 		}
 
 		defer stmt.Close()
 		rows, err := stmt.Query()
 		if err != nil {
-			utils.HandleErr(w, 500, err)
-			return
+			return listing{}, 500, err
 		}
 
 		// Reading each row
@@ -149,8 +259,7 @@ func List(w http.ResponseWriter, r *http.Request) {
 			tsd := TimeSeriesDate{}
 			err := rows.Scan(&tsd.date, &tsd.cases)
 			if err != nil {
-				utils.HandleErr(w, 500, err)
-				return
+				return listing{}, 500, err
 			}
 
 			if typeStr == "Confirmed" {
@@ -164,167 +273,335 @@ func List(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Check 'Accept' type
-	if r.Header.Get("Accept") == "text/csv" {
-		w.Header().Set("Content-Type", "text/csv")
+	if level != "" {
+		tsArr = RollUp(tsArr, level, typeStr)
+	}
+	if perPopulation {
+		for i := range tsArr {
+			tsArr[i].Per100k = per100k(metricValues(tsArr[i], typeStr), tsArr[i].Location)
+		}
+	}
 
-		b := new(bytes.Buffer)
-		writer := csv.NewWriter(b)
-		csvArr := [][]string{}
-		csvArr = append(csvArr, writeHeader(death, recovered))
+	return listing{
+		series:        tsArr,
+		typeStr:       typeStr,
+		death:         death,
+		recovered:     recovered,
+		perPopulation: perPopulation,
+	}, 0, nil
+}
 
-		// Writing response in CSV
-		for _, ts := range tsArr {
-			var data map[time.Time]int
-			if typeStr == "Confirmed" {
-				data = ts.Confirmed
-			} else if typeStr == "Death" {
-				data = ts.Death
-			} else {
-				data = ts.Recovered
-			}
+// Create godoc
+// @Summary Create/Update TimeSeries
+// @Description create/update timeseries
+// @Tags TimeSeries
+// @Accept text/csv
+// @Produce plain
+// @Param FileType header string true Must be either "confirmed", "death", or "recovered" (case insensitive)
+// @Param file body string true Must be a csv file (parsed as a binary)
+// @Param async query bool false Store the file in the background and respond 202 with a job
+// @Param dry_run query bool false Only respond with what the upload would insert, update or reject
+// @Success 200 {string} string "Successfully create/update data to the system"
+// @Success 202 {object} jobs.Job
+// @Failure 400 {string} string "Error status 400"
+// @Failure 422 {object} rejection
+// @Failure 500 {string} string "Error status 500"
+// @Router /time_series [post]
+func Create(w http.ResponseWriter, r *http.Request) {
+	fileType := r.Header.Get("FileType")
+	cfg, err := quality.ConfigFromEnv()
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
 
-			// Create a row
-			for date := range data {
-				row := []string{
-					ts.ID,
-					writeAddress(ts),
-					date.Format("2006/01/02"),
-				}
-				row = append(row, writeRow(ts, date, death, recovered)...)
-				csvArr = append(csvArr, row)
-			}
+	// Only report what the upload would do
+	if r.URL.Query().Get("dry_run") == "true" {
+		u, status, err := parseUpload(r.Context(), fileType, r.Body)
+		if err != nil {
+			uploadErr(w, status, err)
+			return
 		}
-		// Write to buffer
-		if err := writer.WriteAll(csvArr); err != nil {
+		plan, err := u.plan(cfg)
+		if err != nil {
 			utils.HandleErr(w, 500, err)
 			return
 		}
-		// Write to response
-		if _, err := w.Write(b.Bytes()); err != nil {
-			utils.HandleErr(w, 500, err)
+		preview.Write(w, r, plan)
+		return
+	}
+
+	// Large files can be stored in the background, see jobs.Submit
+	if r.URL.Query().Get("async") == "true" {
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.HandleErr(w, 400, err)
 			return
 		}
-	} else {
-		// Writing response in JSON
-		w.Header().Set("Content-Type", "application/json")
-		if err = json.NewEncoder(w).Encode(tsArr); err != nil {
+		u, status, err := parseUpload(r.Context(), fileType, bytes.NewReader(payload))
+		if err != nil {
+			uploadErr(w, status, err)
+			return
+		}
+		// Rejections are known now; the job runs the rules again to correct
+		anomalies, err := u.check(cfg)
+		if err != nil {
 			utils.HandleErr(w, 500, err)
 			return
 		}
+		if quality.Rejected(anomalies) {
+			rejectUpload(w, anomalies)
+			return
+		}
+		jobs.Submit(w, r, "time_series", fileType, payload)
+		return
 	}
 
-	// Successfully written the data
-	w.WriteHeader(200)
+	u, status, err := parseUpload(r.Context(), fileType, r.Body)
+	if err != nil {
+		uploadErr(w, status, err)
+		return
+	}
+	anomalies, err := u.check(cfg)
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+	if quality.Rejected(anomalies) {
+		rejectUpload(w, anomalies)
+		return
+	}
+	if len(anomalies) > 0 {
+		w.Header().Set(warningsHeader, strconv.Itoa(len(anomalies)))
+	}
+	err = u.store(r.Context(), func(err error) error { return err })
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+
+	// Write to respond body
+	if _, err := w.Write([]byte("201 Created: created/updated data to the system")); err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+
+	w.WriteHeader(201)
 }
 
-// Create godoc
-// @Summary Create/Update TimeSeries
-// @Description create/update timeseries
+// Diff godoc
+// @Summary Preview how a TimeSeries file differs from stored data
+// @Description per location and date, the stored and uploaded values and their difference; nothing is written
 // @Tags TimeSeries
 // @Accept text/csv
-// @Produce plain
+// @Produce json text/csv
 // @Param FileType header string true Must be either "confirmed", "death", or "recovered" (case insensitive)
+// @Param ratio query number false Flag revisions of at least this fraction of the stored value (default 0.1)
+// @Param min_delta query int false Flag revisions of at least this many cases (default 10)
 // @Param file body string true Must be a csv file (parsed as a binary)
-// @Success 200 {string} string "Successfully create/update data to the system"
+// @Success 200 {object} preview.Diff
 // @Failure 400 {string} string "Error status 400"
 // @Failure 500 {string} string "Error status 500"
-// @Router /time_series [post]
-func Create(w http.ResponseWriter, r *http.Request) {
+// @Router /time_series/diff [post]
+func Diff(w http.ResponseWriter, r *http.Request) {
+	limits, err := preview.ParseLimits(r.URL.Query())
+	if err != nil {
+		utils.HandleErrDetail(w, 400, err)
+		return
+	}
+
+	cfg, err := quality.ConfigFromEnv()
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+
+	u, status, err := parseUpload(r.Context(), r.Header.Get("FileType"), r.Body)
+	if err != nil {
+		uploadErr(w, status, err)
+		return
+	}
+	plan, err := u.plan(cfg)
+	if err != nil {
+		utils.HandleErr(w, 500, err)
+		return
+	}
+	preview.WriteDiff(w, r, preview.DiffOf(plan, limits))
+}
+
+// Process stores a time series file submitted with async=true; registered
+// with jobs by main. Rows that fail are counted and skipped.
+func Process(ctx context.Context, fileType string, payload []byte, tracker *jobs.Tracker) (string, error) {
+	// The key may have lost its role or scope since the job was submitted
+	if err := auth.CheckWrite(ctx, "time_series"); err != nil {
+		return "", err
+	}
+	u, _, err := parseUpload(ctx, fileType, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	cfg, err := quality.ConfigFromEnv()
+	if err != nil {
+		return "", err
+	}
+	anomalies, err := u.check(cfg)
+	if err != nil {
+		return "", err
+	}
+	if quality.Rejected(anomalies) {
+		return "", fmt.Errorf("%v: %d anomalies", errRejected, len(anomalies))
+	}
+	tracker.SetTotal(len(u.records))
+	err = u.store(ctx, func(err error) error {
+		tracker.Row(err)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	_, processed, errs, _ := tracker.Progress()
+	return fmt.Sprintf("created/updated %d of %d rows; %d quality warnings",
+		processed-errs, processed, len(anomalies)), nil
+}
+
+// OnStore, if set, is called with every row an upload stores and its values
+// of metric by date, i.e. to derive daily reports from it (see projection).
+// An error counts as the row's own.
+var OnStore func(ctx context.Context, ts TimeSeries, metric string, values map[time.Time]int) error
+
+// A time series file, parsed and checked but not stored yet
+type upload struct {
+	filetype       string
+	beginDate      time.Time
+	endDate        time.Time
+	beginDateIndex int
+	admin2Index    int
+	address1Index  int
+	address2Index  int
+	// Columns of location attributes (see Location), by TimeSeries column
+	locationIndex map[string]int
+	records       [][]string
+}
+
+// Parses a time series file of fileType (the FileType header). On error,
+// the status is the one to respond with.
+func parseUpload(ctx context.Context, fileType string, body io.Reader) (*upload, int, error) {
 	/* Preconditions:
 	1. Dates are contiguous.
 	2. The only column values with '/' are dates.
 	*/
-	res, headerOK := utils.HeaderValidate(r.Header.Get("FileType"))
-	var filetype string
+	res, headerOK := utils.HeaderValidate(fileType)
+	u := &upload{admin2Index: -1, locationIndex: map[string]int{}}
 	if headerOK {
-		filetype = strings.Title(res) // i.e. Recovered, Confirms, Deaths
+		u.filetype = strings.Title(res) // i.e. Recovered, Confirms, Deaths
 	} else {
-		utils.HandleErr(w, 400, errors.New("Bad Header Error"))
-		return
+		return nil, 400, errors.New("Bad Header Error")
 	}
-	ts := TimeSeries{}
-	reader := csv.NewReader(r.Body)
+	reader := csv.NewReader(body)
 
 	// get header names
 	result, err := reader.Read()
 	if err != nil {
-		utils.HandleErr(w, 400, err)
-		return
+		return nil, 400, err
 	}
 
-	// Directly access column values
-	var Admin2Index int = -1
-	var Address1Index int
-	var Address2Index int
-
 	// allows for direct access to dates
-	beginDate, endDate, beginDateIndex, err := getDates(result)
+	u.beginDate, u.endDate, u.beginDateIndex, err = getDates(result)
 	if err != nil {
-		utils.HandleErr(w, 400, errors.New("Invalid Date Format Error"))
-		return
+		return nil, 400, errors.New("Invalid Date Format Error")
 	}
 
 	// Check for duplicate dates
-	if utils.HasDupe(beginDateIndex, result) {
-		utils.HandleErr(w, 400, errors.New("File has duplicate dates"))
-		return
+	if utils.HasDupe(u.beginDateIndex, result) {
+		return nil, 400, errors.New("File has duplicate dates")
 	}
 
+	// Directly access column values
 	for i := range result {
 		switch strings.ToLower(result[i]) {
 		case "admin2":
-			Admin2Index = i
+			u.admin2Index = i
 		case "province/state":
-			Address1Index = i
+			u.address1Index = i
 		case "province_state":
-			Address1Index = i
+			u.address1Index = i
 		case "country/region":
-			Address2Index = i
+			u.address2Index = i
 		case "country_region":
-			Address2Index = i
+			u.address2Index = i
+		}
+		if column, ok := locationHeaders[strings.ToLower(result[i])]; ok {
+			u.locationIndex[column] = i
 		}
 	}
 
-	for {
-		result, err = reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			utils.HandleErr(w, 400, err)
-			return
-		}
-		if Admin2Index >= 0 { // Admin2 exists
-			ts.Admin2 = result[Admin2Index]
+	u.records, err = reader.ReadAll()
+	if err != nil {
+		return nil, 400, err
+	}
+
+	// The key must be allowed to upload every country in the file
+	countries := []string{}
+	for _, record := range u.records {
+		countries = append(countries, record[u.address2Index])
+	}
+	if err := auth.CheckCountries(ctx, countries); err != nil {
+		return nil, 403, err
+	}
+
+	return u, 0, nil
+}
+
+// Stores every row of the file. onRow is called after each row with the
+// error that kept it from being stored, if any; returning an error stops.
+func (u *upload) store(ctx context.Context, onRow func(error) error) error {
+	ts := TimeSeries{}
+	for _, result := range u.records {
+		if u.admin2Index >= 0 { // Admin2 exists
+			ts.Admin2 = result[u.admin2Index]
+		}
+		if u.address1Index > 0 {
+			ts.Address1 = result[u.address1Index]
+		}
+		ts.Address2 = result[u.address2Index]
+		id, err := injectTimeSeries(u.admin2Index, ts)
+		if err == nil {
+			var (
+				columns []string
+				args    []interface{}
+			)
+			if columns, args, err = u.location(result); err == nil {
+				err = setLocation(id, columns, args)
+			}
 		}
-		if Address1Index > 0 {
-			ts.Address1 = result[Address1Index]
+		if err == nil {
+			ts.Confirmed = make(map[time.Time]int)
+			ts.Death = make(map[time.Time]int)
+			ts.Recovered = make(map[time.Time]int)
+			_, err = InjectTimeSeriesDate(ctx, u.beginDate, u.endDate, u.beginDateIndex, result, ts, id, u.filetype)
 		}
-		ts.Address2 = result[Address2Index]
-		id, err := injectTimeSeries(Admin2Index, ts)
-		if err != nil {
-			utils.HandleErr(w, 500, err)
-			return
+		if err == nil && OnStore != nil {
+			values := map[string]map[time.Time]int{
+				"Confirmed": ts.Confirmed,
+				"Death":     ts.Death,
+				"Recovered": ts.Recovered,
+			}[u.filetype]
+			err = OnStore(ctx, ts, u.filetype, values)
 		}
-
-		ts.Confirmed = make(map[time.Time]int)
-		ts.Death = make(map[time.Time]int)
-		ts.Recovered = make(map[time.Time]int)
-		_, err = InjectTimeSeriesDate(beginDate, endDate, beginDateIndex, result, ts, id, filetype)
-		if err != nil {
-			utils.HandleErr(w, 500, err)
-			return
+		if err := onRow(err); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// Write to respond body
-	if _, err := w.Write([]byte("201 Created: created/updated data to the system")); err != nil {
-		utils.HandleErr(w, 500, err)
+// Responds to a file rejected by parseUpload
+func uploadErr(w http.ResponseWriter, status int, err error) {
+	if status == 403 {
+		auth.Forbidden(w, err)
 		return
 	}
-
-	w.WriteHeader(201)
+	utils.HandleErr(w, status, err)
 }
 
 func getDates(result []string) (time.Time, time.Time, int, error) {
@@ -338,16 +615,16 @@ func getDates(result []string) (time.Time, time.Time, int, error) {
 	beginFlag := false // true -> beginDate found; false Otherwise
 	endFlag := false
 	for i := range result {
-		if !beginFlag && len(strings.Split(result[i], "/")) == 3 {
-			beginDate, err = utils.ParseDate(result[i]) // parses Date string -> time.Time
+		if !beginFlag && dates.LooksLikeDate(result[i]) {
+			beginDate, err = dates.Parse(result[i]) // parses Date string -> time.Time
 			if err != nil {
 				return beginDate, time.Time{}, i, err
 			}
 			beginDateIndex = i
 			beginFlag = true
 		}
-		if !endFlag && len(strings.Split(result[len(result)-i-1], "/")) == 3 { // searches backwards in array
-			endDate, err = utils.ParseDate(result[len(result)-i-1])
+		if !endFlag && dates.LooksLikeDate(result[len(result)-i-1]) { // searches backwards in array
+			endDate, err = dates.Parse(result[len(result)-i-1])
 			if err != nil {
 				return time.Time{}, endDate, -1, err
 			}
@@ -435,7 +712,7 @@ func injectTimeSeries(Admin2Index int, ts TimeSeries) (int64, error) {
 	return repeatedID, nil
 }
 
-func InjectTimeSeriesDate(beginDate time.Time, endDate time.Time, beginDateIndex int, result []string, ts TimeSeries, id int64, filetype string) (bool, error) {
+func InjectTimeSeriesDate(ctx context.Context, beginDate time.Time, endDate time.Time, beginDateIndex int, result []string, ts TimeSeries, id int64, filetype string) (bool, error) {
 	query := fmt.Sprintf("INSERT INTO TimeSeries%s VALUES(?,?,?)", filetype)
 	stmt, err := db.Db.Prepare(query)
 	if err != nil {
@@ -454,6 +731,7 @@ func InjectTimeSeriesDate(beginDate time.Time, endDate time.Time, beginDateIndex
 		}
 
 		// Find row with existing id and date
+		var oldValue *int
 		rows, err := db.Db.Query(fmt.Sprintf(`
 		SELECT * FROM TimeSeries%s
 		`, filetype))
@@ -466,11 +744,11 @@ func InjectTimeSeriesDate(beginDate time.Time, endDate time.Time, beginDateIndex
 			if err != nil {
 				return false, err
 			}
-			layout := "2006-1-2"
-			if ID == id && Date.Format(layout) == date.Format(layout) {
+			if ID == id && dates.Format(Date) == dates.Format(date) {
+				oldValue = audit.Value(Type)
 				_, err = db.Db.Exec(fmt.Sprintf(`
 				DELETE FROM TimeSeries%s
-				WHERE ID = %d AND Date = '%s'`, filetype, ID, Date.Format("2006-1-2"))) // remove based on
+				WHERE ID = %d AND Date = '%s'`, filetype, ID, dates.Format(Date))) // remove based on
 				if err != nil {
 					return false, err
 				}
@@ -491,6 +769,28 @@ func InjectTimeSeriesDate(beginDate time.Time, endDate time.Time, beginDateIndex
 		if err != nil {
 			return false, err
 		}
+
+		// Keep every version of the value for as_of queries
+		if audit.Changed(oldValue, audit.Value(val)) {
+			if err := recordRevision(ctx, id, filetype, date, val); err != nil {
+				return false, err
+			}
+		}
+
+		err = audit.Record(ctx, audit.Entry{
+			Resource:   "time_series",
+			LocationID: id,
+			Admin2:     ts.Admin2,
+			Address1:   ts.Address1,
+			Address2:   ts.Address2,
+			Date:       date,
+			Metric:     filetype,
+			OldValue:   oldValue,
+			NewValue:   audit.Value(val),
+		})
+		if err != nil {
+			return false, err
+		}
 		dateIndex++
 	}
 
@@ -506,10 +806,10 @@ func makeQuery(params map[string][]string) (string, string, bool, bool, int) {
 		JOIN TimeSeriesDeath ON TimeSeries.ID = TimeSeriesDeath.ID
 		JOIN TimeSeriesRecovered ON TimeSeries.ID = TimeSeriesRecovered.ID
 	`
-	dates := ""
+	dateClause := ""
 	death, recovered := false, false
 	if len(params) == 0 {
-		return query, dates, death, recovered, 0
+		return query, dateClause, death, recovered, 0
 	}
 
 	formattedParams := map[string][]string{}
@@ -533,10 +833,18 @@ func makeQuery(params map[string][]string) (string, string, bool, bool, int) {
 		return "", "", false, false, 400
 	}
 
-	dateCounter := 0
+	// Relative dates (e.g. "latest", "-30d") are resolved against the values
+	// read, i.e. those known at as_of
+	asOf, err := parseAsOf(formattedParams)
+	if err != nil {
+		return "", "", false, false, 400
+	}
+	latest := utils.LatestDate(valueSource(getType(death, recovered), asOf))
+
 	whereCounter := 0 // counter for 'WHERE'
 	for param, value := range formattedParams {
-		if param == "death" || param == "recovered" {
+		// Metric flags, and as_of, which is handled by parseAsOf
+		if param == "death" || param == "recovered" || param == "as_of" {
 			continue
 		}
 
@@ -550,6 +858,10 @@ func makeQuery(params map[string][]string) (string, string, bool, bool, int) {
 			}
 			param = "date"
 		}
+		// A whole week/month, i.e. range=2020-W12 or month=2021-03
+		if param == "range" || param == "month" {
+			param = "date"
+		}
 
 		value := strings.Split(value[0], ",")
 		for i, v := range value {
@@ -563,23 +875,22 @@ func makeQuery(params map[string][]string) (string, string, bool, bool, int) {
 			_, ok := stringParams[param]
 			if ok {
 				if param == "date" {
-					// mm/dd/yy
-					temp, err := utils.ParseDate(v)
+					// Any expression understood by dates.Resolve
+					rng, err := dates.Resolve(v, latest)
 					if err != nil {
 						return "", "", false, false, 400
 					}
-					value[i] = temp.Format("2006/1/2")
-					value[i] = fmt.Sprintf(`"%s"`, value[i])
+					cond := utils.DateCondition(param, op, rng)
 
-					// Put to dates string, skip to next param
-					if dateCounter == 0 {
-						dates += "AND " + param + op + value[i]
-						dateCounter++
+					// Put to date clause, skip to next param.
+					// Values of the same param are alternatives of each other
+					if i == 0 {
+						dateClause += " AND (" + cond
 					} else {
-						dates += " OR " + param + op + value[i]
+						dateClause += " OR " + cond
 					}
 					if i == len(value)-1 {
-						param = fmt.Sprintf("TimeSeries%s.Date", getType(death, recovered))
+						dateClause += ")"
 					}
 					continue
 				} else {
@@ -607,7 +918,7 @@ func makeQuery(params map[string][]string) (string, string, bool, bool, int) {
 			}
 		}
 	}
-	return query, dates, death, recovered, 0
+	return query, dateClause, death, recovered, 0
 }
 
 func getType(d bool, r bool) string {
@@ -652,6 +963,24 @@ func writeAddress(ts TimeSeries) string {
 	return address
 }
 
+// Values of ts for the metric typeStr
+func metricValues(ts TimeSeries, typeStr string) map[time.Time]int {
+	if typeStr == "Confirmed" {
+		return ts.Confirmed
+	} else if typeStr == "Death" {
+		return ts.Death
+	}
+	return ts.Recovered
+}
+
+func writePer100k(ts TimeSeries, date time.Time) string {
+	v, ok := ts.Per100k[date]
+	if !ok {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
 func writeRow(ts TimeSeries, date time.Time, death bool, recovered bool) []string {
 	typeStr := getType(death, recovered)
 	arr := []string{}
@@ -664,3 +993,23 @@ func writeRow(ts TimeSeries, date time.Time, death bool, recovered bool) []strin
 	}
 	return arr
 }
+
+// SetValue stores value as the metric (Confirmed, Death or Recovered) of
+// ts's location on date, creating the location if needed. Used to reconcile
+// time series with daily reports; the change is versioned and audited as an
+// upload would be.
+func SetValue(ctx context.Context, ts TimeSeries, metric string, date time.Time, value int) error {
+	admin2Index := 0
+	if ts.Admin2 == "" {
+		admin2Index = -1
+	}
+	id, err := injectTimeSeries(admin2Index, ts)
+	if err != nil {
+		return err
+	}
+	ts.Confirmed = make(map[time.Time]int)
+	ts.Death = make(map[time.Time]int)
+	ts.Recovered = make(map[time.Time]int)
+	_, err = InjectTimeSeriesDate(ctx, date, date, 0, []string{strconv.Itoa(value)}, ts, id, metric)
+	return err
+}
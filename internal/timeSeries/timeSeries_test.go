@@ -2,6 +2,7 @@ package timeSeries
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
@@ -11,8 +12,9 @@ import (
 	"testing"
 	"time"
 
+	"gitlab.com/csc301-assignments/a2/internal/auth"
+	"gitlab.com/csc301-assignments/a2/internal/dates"
 	db "gitlab.com/csc301-assignments/a2/internal/db"
-	"gitlab.com/csc301-assignments/a2/internal/utils"
 )
 
 // Testing helper functions
@@ -238,7 +240,7 @@ func TestMakeQueryNoParams(t *testing.T) {
 	// Test no params
 	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
 
-	query, dates, death, recovered, status := makeQuery(r.URL.Query())
+	query, dateClause, death, recovered, status := makeQuery(r.URL.Query())
 
 	query = strings.TrimSpace(query)
 	expectedQuery := strings.TrimSpace(`
@@ -253,8 +255,8 @@ func TestMakeQueryNoParams(t *testing.T) {
 	}
 
 	expectedDates := ""
-	if dates != expectedDates {
-		t.Fatalf("Test failed: expected %s, got %s", expectedDates, dates)
+	if dateClause != expectedDates {
+		t.Fatalf("Test failed: expected %s, got %s", expectedDates, dateClause)
 	}
 
 	if death || recovered {
@@ -363,15 +365,15 @@ func TestMakeQueryOneParamMultvalues(t *testing.T) {
 		"http://example.com/foo?date=1/2/30,4/5/60",
 		nil)
 
-	_, dates, _, _, _ := makeQuery(r.URL.Query())
+	_, dateClause, _, _, _ := makeQuery(r.URL.Query())
 
-	checker := "date=\"2030/1/2\""
-	if !strings.Contains(dates, checker) {
+	checker := "date=\"2030-01-02\""
+	if !strings.Contains(dateClause, checker) {
 		t.Fatalf("Test failed: query does not contain %s", checker)
 	}
 
-	checker = "date=\"2060/4/5\""
-	if !strings.Contains(dates, checker) {
+	checker = "date=\"2060-04-05\""
+	if !strings.Contains(dateClause, checker) {
 		t.Fatalf("Test failed: query does not contain %s", checker)
 	}
 }
@@ -405,29 +407,69 @@ func TestMakeQueryMultParamsMultValues(t *testing.T) {
 		"http://example.com/foo?from=1/2/30,4/5/60&to=1/2/30,4/5/60",
 		nil)
 
-	_, dates, _, _, _ := makeQuery(r.URL.Query())
+	_, dateClause, _, _, _ := makeQuery(r.URL.Query())
 
-	checker = "date>=\"2030/1/2\""
-	if !strings.Contains(dates, checker) {
+	checker = "date>=\"2030-01-02\""
+	if !strings.Contains(dateClause, checker) {
 		t.Fatalf("Test failed: query does not contain %s", checker)
 	}
 
-	checker = "date>=\"2060/4/5\""
-	if !strings.Contains(dates, checker) {
+	checker = "date>=\"2060-04-05\""
+	if !strings.Contains(dateClause, checker) {
 		t.Fatalf("Test failed: query does not contain %s", checker)
 	}
 
-	checker = "date<=\"2030/1/2\""
-	if !strings.Contains(dates, checker) {
+	checker = "date<=\"2030-01-02\""
+	if !strings.Contains(dateClause, checker) {
 		t.Fatalf("Test failed: query does not contain %s", checker)
 	}
 
-	checker = "date<=\"2060/4/5\""
-	if !strings.Contains(dates, checker) {
+	checker = "date<=\"2060-04-05\""
+	if !strings.Contains(dateClause, checker) {
 		t.Fatalf("Test failed: query does not contain %s", checker)
 	}
 }
 
+func TestMakeQueryNamedRanges(t *testing.T) {
+	// ISO week and calendar month
+	r := httptest.NewRequest(
+		"GET",
+		"http://example.com/foo?range=2020-W12&month=2021-03",
+		nil)
+
+	_, dateClause, _, _, status := makeQuery(r.URL.Query())
+	if status != 0 {
+		t.Fatalf("Test failed: expected status 0, got %d", status)
+	}
+
+	checker := "(date>=\"2020-03-16\" AND date<=\"2020-03-22\")"
+	if !strings.Contains(dateClause, checker) {
+		t.Fatalf("Test failed: query does not contain %s", checker)
+	}
+
+	checker = "(date>=\"2021-03-01\" AND date<=\"2021-03-31\")"
+	if !strings.Contains(dateClause, checker) {
+		t.Fatalf("Test failed: query does not contain %s", checker)
+	}
+
+	// from and to narrow each other down
+	r = httptest.NewRequest(
+		"GET",
+		"http://example.com/foo?from=2021-03-01&to=2021-03-31",
+		nil)
+	_, dateClause, _, _, _ = makeQuery(r.URL.Query())
+	if strings.Contains(dateClause, " OR ") {
+		t.Fatalf("Test failed: from and to should not be OR-ed: %s", dateClause)
+	}
+
+	// Bad expressions
+	r = httptest.NewRequest("GET", "http://example.com/foo?month=2021-13", nil)
+	_, _, _, _, status = makeQuery(r.URL.Query())
+	if status != 400 {
+		t.Fatalf("Test failed: expected 400, got %d", status)
+	}
+}
+
 func TestListNoParams(t *testing.T) {
 	db.InitDb("development")
 	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
@@ -481,11 +523,11 @@ func TestListWithParams(t *testing.T) {
 
 	expect1 := "US"
 	expect2 := "Canada"
-	date1, err := utils.ParseDate("11/1/21")
+	date1, err := dates.Parse("11/1/21")
 	if err != nil {
 		t.Errorf("Error during parsing date: %v", err)
 	}
-	date2, err := utils.ParseDate("10/31/21")
+	date2, err := dates.Parse("10/31/21")
 	if err != nil {
 		t.Errorf("Error during parsing date: %v", err)
 	}
@@ -637,6 +679,32 @@ func TestGetDatesThreeDates(t *testing.T) {
 	}
 }
 
+func TestGetDatesISODates(t *testing.T) {
+	arr := []string{"Admin2", "Province_State", "Country_Region", "2021-01-20", "1/21/2021", "2021-01-22"}
+	beginDate, endDate, beginDateIndex, err := getDates(arr)
+	expectedBeginDate := time.Date(2021, 1, 20, 0, 0, 0, 0, time.UTC)
+	expectedEndDate := time.Date(2021, 1, 22, 0, 0, 0, 0, time.UTC)
+
+	if err != nil {
+		t.Fatalf("Error occured when getting ISO dates: %v", err)
+	}
+	if beginDate != expectedBeginDate {
+		t.Fatalf("Test failed: expected value %s, got %s", expectedBeginDate.String(), beginDate.String())
+	}
+	if endDate != expectedEndDate {
+		t.Fatalf("Test failed: expected value %s, got %v", expectedEndDate.String(), endDate.String())
+	}
+	if beginDateIndex != 3 {
+		t.Fatalf("Test failed: expected value 3, got %d", beginDateIndex)
+	}
+
+	// Impossible dates are rejected
+	arr = []string{"Country_Region", "2/30/21"}
+	if _, _, _, err = getDates(arr); err == nil {
+		t.Fatalf("Test failed: expected an error for 2/30/21")
+	}
+}
+
 // test injectTimeSeries
 // NOTE: tests assume that database is setup according to create-tables.sql
 func TestInjectTimeSeriesExistingTimeSeries(t *testing.T) {
@@ -903,3 +971,29 @@ func TestCreateDuplicatedDatesOneFile(t *testing.T) {
 		t.Fatalf("Test failed: expected body %s, got %s", expectedBody, string(body))
 	}
 }
+
+func TestParseUpload(t *testing.T) {
+	csvFile := "Province/State,Country/Region,Lat,Long,1/22/20,1/23/20\nOntario,Canada,1,1,3,5\n"
+	u, status, err := parseUpload(context.Background(), "confirmed", strings.NewReader(csvFile))
+	if err != nil || status != 0 {
+		t.Fatalf("Test failed: expected no error, got %d %v", status, err)
+	}
+	if u.filetype != "Confirmed" || u.beginDateIndex != 4 || len(u.records) != 1 {
+		t.Fatalf("Test failed: unexpected upload %+v", u)
+	}
+
+	if _, status, _ = parseUpload(context.Background(), "active", strings.NewReader(csvFile)); status != 400 {
+		t.Fatalf("Test failed: expected 400 for a bad FileType, got %d", status)
+	}
+
+	dupe := "Province/State,Country/Region,1/22/20,1/22/20\nOntario,Canada,3,5\n"
+	if _, status, _ = parseUpload(context.Background(), "death", strings.NewReader(dupe)); status != 400 {
+		t.Fatalf("Test failed: expected 400 for duplicate dates, got %d", status)
+	}
+
+	// Countries are checked against the key before anything is stored
+	ctx := auth.WithKey(context.Background(), auth.Key{ID: 1, Role: auth.RoleUploader, Scopes: []string{"country:US"}}, 0)
+	if _, status, _ = parseUpload(ctx, "confirmed", strings.NewReader(csvFile)); status != 403 {
+		t.Fatalf("Test failed: expected 403 for an out of scope country, got %d", status)
+	}
+}
@@ -1,34 +1,53 @@
 package utils
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
+
+	"gitlab.com/csc301-assignments/a2/internal/dates"
+	db "gitlab.com/csc301-assignments/a2/internal/db"
 )
 
+// Maps query parameters to the column (or option) they stand for
+var params = map[string]string{
+	"id":        "id",
+	"admin2":    "admin2",
+	"province":  "address1",
+	"state":     "address1",
+	"country":   "address2",
+	"region":    "address2",
+	"date":      "date",
+	"from":      "from",
+	"to":        "to",
+	"range":     "range",
+	"month":     "month",
+	"death":     "death",
+	"recovered": "recovered",
+	"as_of":     "as_of",
+}
+
 func ParamValidate(param string) (string, bool) {
 	param = strings.ToLower(param)
-	validator := map[string]string{
-		"id":        "id",
-		"admin2":    "admin2",
-		"province":  "address1",
-		"state":     "address1",
-		"country":   "address2",
-		"region":    "address2",
-		"date":      "date",
-		"from":      "from",
-		"to":        "to",
-		"death":     "death",
-		"recovered": "recovered",
-	}
-	result, ok := validator[param]
+	result, ok := params[param]
 	return result, ok
 }
 
+// ParamNames lists every query parameter accepted by ParamValidate, sorted
+func ParamNames() []string {
+	names := []string{}
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func HeaderValidate(header string) (string, bool) {
 	header = strings.ToLower(header)
 	validator := map[string]string{
@@ -40,39 +59,6 @@ func HeaderValidate(header string) (string, bool) {
 	return result, ok
 }
 
-/**
-Helper function for Create().
-Takes Date string and returns type Date as type time.Time
-*/
-func ParseDate(date string) (time.Time, error) {
-	temp := strings.Split(date, "/") // i.e. "mm/dd/yy" -> [ "mm", "dd", "yy" ]
-	if len(temp) != 3 {
-		return time.Time{}, errors.New("Syntax Error")
-	}
-	year, err := strconv.Atoi("20" + temp[2])
-	if err != nil {
-		return time.Time{}, err
-	}
-	if year < 0 || year > 9999 {
-		return time.Time{}, errors.New("Year Syntax Error")
-	}
-	month, err := strconv.Atoi(temp[0])
-	if err != nil {
-		return time.Time{}, err
-	}
-	if month < 0 || month > 12 {
-		return time.Time{}, errors.New("Month Syntax Error")
-	}
-	day, err := strconv.Atoi(temp[1])
-	if err != nil {
-		return time.Time{}, err
-	}
-	if day < 0 || day > 31 {
-		return time.Time{}, errors.New("Day Syntax Error")
-	}
-	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC), nil
-}
-
 /**
 Helper function for Create().
 Iterate from index until end of arr, checking for duplicate string
@@ -102,3 +88,46 @@ func HandleErr(w http.ResponseWriter, code int, err error) {
 	}
 	log.Println("Error: ", err)
 }
+
+// HandleErrDetail is HandleErr for errors the client should read, i.e. a
+// missing permission; the message is appended to the status
+func HandleErrDetail(w http.ResponseWriter, code int, err error) {
+	w.WriteHeader(code)
+	response := fmt.Sprintf("Error status %d: %v", code, err)
+	if _, err := w.Write([]byte(response)); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Error: ", err)
+}
+
+// DateCondition formats a resolved date range as a SQL condition on column.
+// op is "=" for a date (or a whole week/month), ">=" for from and "<=" for to.
+func DateCondition(column string, op string, rng dates.Range) string {
+	switch op {
+	case ">=":
+		return fmt.Sprintf(`%s>="%s"`, column, dates.Format(rng.From))
+	case "<=":
+		return fmt.Sprintf(`%s<="%s"`, column, dates.Format(rng.To))
+	}
+	if rng.IsSingle() {
+		return fmt.Sprintf(`%s="%s"`, column, dates.Format(rng.From))
+	}
+	return fmt.Sprintf(`(%s>="%s" AND %s<="%s")`,
+		column, dates.Format(rng.From), column, dates.Format(rng.To))
+}
+
+// LatestDate returns an anchor reading the last date stored in table,
+// used to resolve relative dates such as "latest" or "-30d"
+func LatestDate(table string) dates.Anchor {
+	return func() (time.Time, error) {
+		var latest sql.NullTime
+		query := fmt.Sprintf("SELECT MAX(Date) FROM %s", table)
+		if err := db.Db.QueryRow(query).Scan(&latest); err != nil {
+			return time.Time{}, err
+		}
+		if !latest.Valid {
+			return time.Time{}, errors.New("No data to resolve relative date against")
+		}
+		return latest.Time, nil
+	}
+}
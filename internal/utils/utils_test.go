@@ -7,6 +7,8 @@ import (
 	"net/http/httptest"
 	"testing"
 	"time"
+
+	"gitlab.com/csc301-assignments/a2/internal/dates"
 )
 
 func TestParamValidate(t *testing.T) {
@@ -21,8 +23,11 @@ func TestParamValidate(t *testing.T) {
 		"date",
 		"from",
 		"to",
+		"range",
+		"month",
 		"death",
 		"recovered",
+		"as_of",
 	}
 
 	var res, expect string
@@ -54,6 +59,21 @@ func TestParamValidate(t *testing.T) {
 	}
 }
 
+func TestParamNames(t *testing.T) {
+	names := ParamNames()
+	for i, name := range names {
+		if _, ok := ParamValidate(name); !ok {
+			t.Fatalf("Test failed: %s is not a valid param", name)
+		}
+		if i > 0 && names[i-1] >= name {
+			t.Fatalf("Test failed: params not sorted: %v", names)
+		}
+	}
+	if len(names) == 0 {
+		t.Fatalf("Test failed: no params")
+	}
+}
+
 func TestHeaderValidate(t *testing.T) {
 	headers := []string{
 		"abc",
@@ -77,98 +97,6 @@ func TestHeaderValidate(t *testing.T) {
 	}
 }
 
-func TestParseDataValidInput(t *testing.T) {
-	// 1 Jan 2020
-	input := "1/31/20"
-	date, err := ParseDate(input)
-	if err != nil {
-		t.Errorf("Error while parsing date: %v", err)
-	}
-
-	expect := time.Date(2020, time.Month(1), 31, 0, 0, 0, 0, time.UTC)
-
-	if expect != date {
-		t.Fatalf("Test failed: Expected %s, got %s", expect.String(), date.String())
-	}
-}
-
-func TestParseDateInvalidInput(t *testing.T) {
-	// Month over 12
-	input := "31/1/20"
-	date, err := ParseDate(input)
-	if err == nil {
-		t.Fatalf("Test failed: Error not raised")
-	}
-	expect := time.Time{}
-	if date != expect {
-		t.Fatalf("Test failed: Date not default:%s", date.String())
-	}
-
-	// Date over 99
-	input = "1/99/20"
-	date, err = ParseDate(input)
-	if err == nil {
-		t.Fatalf("Test failed: Error not raised")
-	}
-	expect = time.Time{}
-	if date != expect {
-		t.Fatalf("Test failed: Date not default:%s", date.String())
-	}
-
-	// Year over 999
-	input = "1/1/999"
-	date, err = ParseDate(input)
-	if err == nil {
-		t.Fatalf("Test failed: Error not raised")
-	}
-	expect = time.Time{}
-	if date != expect {
-		t.Fatalf("Test failed: Date not default:%s", date.String())
-	}
-
-	// Length after split not 3
-	input = "abc"
-	date, err = ParseDate(input)
-	if err == nil {
-		t.Fatalf("Test failed: Error not raised")
-	}
-	expect = time.Time{}
-	if date != expect {
-		t.Fatalf("Test failed: Date not default:%s", date.String())
-	}
-
-	// Bad inputs
-	input = "a/1/20"
-	date, err = ParseDate(input)
-	if err == nil {
-		t.Fatalf("Test failed: Error not raised")
-	}
-	expect = time.Time{}
-	if date != expect {
-		t.Fatalf("Test failed: Date not default:%s", date.String())
-	}
-
-	input = "1/b/20"
-	date, err = ParseDate(input)
-	if err == nil {
-		t.Fatalf("Test failed: Error not raised")
-	}
-	expect = time.Time{}
-	if date != expect {
-		t.Fatalf("Test failed: Date not default:%s", date.String())
-	}
-
-	input = "1/1/c"
-	date, err = ParseDate(input)
-	if err == nil {
-		t.Fatalf("Test failed: Error not raised")
-	}
-	expect = time.Time{}
-	if date != expect {
-		t.Fatalf("Test failed: Date not default:%s", date.String())
-	}
-}
-
 func TestHasDupe(t *testing.T) {
 	// No dupes
 	i := 0
@@ -203,3 +131,47 @@ func TestHandleErr(t *testing.T) {
 		t.Fatalf("Test failed: expect %d, got %d", code, resp.StatusCode)
 	}
 }
+
+func TestHandleErrDetail(t *testing.T) {
+	code := 403
+	w := httptest.NewRecorder()
+	HandleErrDetail(w, code, errors.New("API key lacks scope time_series"))
+
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+
+	expect := "Error status 403: API key lacks scope time_series"
+	if string(body) != expect {
+		t.Fatalf("Test failed: expect %s, got %s", expect, string(body))
+	}
+	if resp.StatusCode != code {
+		t.Fatalf("Test failed: expect %d, got %d", code, resp.StatusCode)
+	}
+}
+
+func TestDateCondition(t *testing.T) {
+	from := time.Date(2021, time.Month(3), 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, time.Month(3), 31, 0, 0, 0, 0, time.UTC)
+
+	single := dates.Single(from)
+	expect := `date="2021-03-01"`
+	if res := DateCondition("date", "=", single); res != expect {
+		t.Fatalf("Test failed: expect %s, got %s", expect, res)
+	}
+
+	month := dates.Range{From: from, To: to}
+	expect = `(date>="2021-03-01" AND date<="2021-03-31")`
+	if res := DateCondition("date", "=", month); res != expect {
+		t.Fatalf("Test failed: expect %s, got %s", expect, res)
+	}
+
+	// from uses the start of a range and to uses its end
+	expect = `date>="2021-03-01"`
+	if res := DateCondition("date", ">=", month); res != expect {
+		t.Fatalf("Test failed: expect %s, got %s", expect, res)
+	}
+	expect = `date<="2021-03-31"`
+	if res := DateCondition("date", "<=", month); res != expect {
+		t.Fatalf("Test failed: expect %s, got %s", expect, res)
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	// Built-ins
-
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -11,8 +11,19 @@ import (
 	"github.com/go-chi/chi"
 
 	// Internal imports
+	"gitlab.com/csc301-assignments/a2/internal/audit"
+	"gitlab.com/csc301-assignments/a2/internal/auth"
 	"gitlab.com/csc301-assignments/a2/internal/dailyReports"
 	db "gitlab.com/csc301-assignments/a2/internal/db"
+	"gitlab.com/csc301-assignments/a2/internal/importer"
+	"gitlab.com/csc301-assignments/a2/internal/jobs"
+	"gitlab.com/csc301-assignments/a2/internal/latest"
+	"gitlab.com/csc301-assignments/a2/internal/openapi"
+	"gitlab.com/csc301-assignments/a2/internal/projection"
+	"gitlab.com/csc301-assignments/a2/internal/quality"
+	"gitlab.com/csc301-assignments/a2/internal/rankings"
+	"gitlab.com/csc301-assignments/a2/internal/rateLimit"
+	"gitlab.com/csc301-assignments/a2/internal/reconcile"
 	"gitlab.com/csc301-assignments/a2/internal/timeSeries"
 )
 
@@ -43,6 +54,44 @@ func main() {
 
 	db.InitDb()
 
+	// Optionally keep each resource in step with uploads to the other,
+	// including those of the sync command
+	if projection.Enabled(projection.TimeSeries) {
+		dailyReports.OnStore = projection.ToTimeSeries
+	}
+	if projection.Enabled(projection.DailyReports) {
+		timeSeries.OnStore = projection.ToDailyReports
+	}
+
+	// Admin subcommands, i.e. ./a2 keys create uploader
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Uploads sent with async=true are stored by these workers
+	jobs.Register("time_series", timeSeries.Process)
+	jobs.Register("daily_reports", dailyReports.Process)
+	jobs.Register("reconcile", reconcile.Process)
+	if err := jobs.StartFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Files dropped in WATCH_DIR, if set, are ingested in the background
+	if err := importer.WatchFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+
+	r := newRouter()
+
+	log.Printf("Listening for requests on http://localhost:%s/", port)
+	log.Fatal(http.ListenAndServe(":"+port, r))
+}
+
+// Every route served by the API; each must also be described in openapi.Spec
+func newRouter() chi.Router {
 	// Initizalize Router
 	r := chi.NewRouter()
 
@@ -53,9 +102,45 @@ func main() {
 		}
 	})
 
-	r.Mount("/api/v1/time_series", timeSeries.Routes())
-	r.Mount("/api/v1/daily_reports", dailyReports.Routes())
+	r.Get("/api/v1/openapi.json", openapi.Serve)
 
-	log.Printf("Listening for requests on http://localhost:%s/", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	// Data routes; writes need an API key scoped to the resource, but dry
+	// runs are reads. Each route is rate limited per key (or IP) on its own,
+	// before keys are looked up and writes recorded, so throttled requests
+	// have no effect
+	r.With(rateLimit.FromEnv("time_series"), auth.DryRun, auth.Middleware, auth.Require("time_series"), auth.Record).
+		Mount("/api/v1/time_series", timeSeries.Routes())
+	// Diffs only preview an upload, so they are reads despite the POST
+	r.With(rateLimit.FromEnv("diff"), auth.Read, auth.Middleware).
+		Post("/api/v1/time_series/diff", timeSeries.Diff)
+	r.With(rateLimit.FromEnv("daily_reports"), auth.DryRun, auth.Middleware, auth.Require("daily_reports"), auth.Record).
+		Mount("/api/v1/daily_reports", dailyReports.Routes())
+	r.With(rateLimit.FromEnv("latest"), auth.Middleware).
+		Mount("/api/v1/latest", latest.Routes())
+	r.With(rateLimit.FromEnv("rankings"), auth.Middleware).
+		Mount("/api/v1/rankings", rankings.Routes())
+	r.With(rateLimit.FromEnv("audit"), auth.Middleware, auth.RequireRole(auth.RoleReader)).
+		Mount("/api/v1/audit", audit.Routes())
+	r.With(rateLimit.FromEnv("jobs"), auth.Middleware, auth.RequireRole(auth.RoleReader)).
+		Mount("/api/v1/jobs", jobs.Routes())
+	r.With(rateLimit.FromEnv("quality"), auth.Middleware, auth.RequireRole(auth.RoleReader)).
+		Mount("/api/v1/quality", quality.Routes())
+	r.With(rateLimit.FromEnv("reconcile"), auth.Middleware, auth.RequireRole(auth.RoleReader), auth.Record).
+		Mount("/api/v1/reconcile", reconcile.Routes())
+	r.With(rateLimit.FromEnv("watch"), auth.Middleware, auth.RequireRole(auth.RoleReader)).
+		Mount("/api/v1/watch", importer.Routes())
+
+	return r
+}
+
+func runCommand(args []string) error {
+	switch args[0] {
+	case "keys":
+		return auth.Command(args[1:], os.Stdout)
+	case "backfill":
+		return projection.Command(args[1:], os.Stdout)
+	case "sync":
+		return importer.Command(args[1:], os.Stdout)
+	}
+	return fmt.Errorf("unknown command %q; available: keys, backfill, sync", args[0])
 }
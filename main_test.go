@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+
+	"gitlab.com/csc301-assignments/a2/internal/openapi"
+)
+
+// Every registered route must be documented, and every documented route
+// must exist
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	registered := map[string]bool{}
+	walker := func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		// Mounted routers register their index as "/api/v1/foo/"
+		if route != "/" {
+			route = strings.TrimSuffix(route, "/")
+		}
+		registered[strings.ToLower(method)+" "+route] = true
+		return nil
+	}
+	if err := chi.Walk(newRouter(), walker); err != nil {
+		t.Fatalf("Error while walking routes: %v", err)
+	}
+
+	documented := map[string]bool{}
+	for path, item := range openapi.Spec().Paths {
+		for method := range item {
+			documented[method+" "+path] = true
+		}
+	}
+
+	missing := []string{}
+	for route := range registered {
+		if !documented[route] {
+			missing = append(missing, route)
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Fatalf("Test failed: routes missing from the spec: %v", missing)
+	}
+
+	extra := []string{}
+	for route := range documented {
+		if !registered[route] {
+			extra = append(extra, route)
+		}
+	}
+	sort.Strings(extra)
+	if len(extra) > 0 {
+		t.Fatalf("Test failed: documented routes that do not exist: %v", extra)
+	}
+}
+
+// Diffs are previews: anonymous clients may send them, unlike uploads
+func TestDiffIsARead(t *testing.T) {
+	router := newRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/time_series/diff", strings.NewReader("a,b")))
+	if status := w.Result().StatusCode; status != 400 {
+		t.Fatalf("Test failed: expected the diff to reject its missing FileType with 400, got %d", status)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/time_series", strings.NewReader("a,b")))
+	if status := w.Result().StatusCode; status != 401 {
+		t.Fatalf("Test failed: expected an anonymous upload to get 401, got %d", status)
+	}
+}
+
+func TestDryRunIsARead(t *testing.T) {
+	router := newRouter()
+
+	for _, path := range []string{"/api/v1/time_series?dry_run=true", "/api/v1/daily_reports?dry_run=true"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader("a,b")))
+		if status := w.Result().StatusCode; status != 400 {
+			t.Fatalf("Test failed: expected the dry run of %s to reject its missing header with 400, got %d", path, status)
+		}
+	}
+}